@@ -32,13 +32,22 @@ const (
 )
 
 // RequestType specifies the type of AccessRequest
-// +kubebuilder:validation:Enum:=SveltosAgent;Different
+// +kubebuilder:validation:Enum:=SveltosAgent;DriftDetection;Generic
 type RequestType string
 
 const (
 	// SveltosAgent is the request type to generate kubeconfig
 	// for sveltos agent
 	SveltosAgentRequest = RequestType("SveltosAgent")
+
+	// DriftDetectionRequest is the request type to generate kubeconfig
+	// for drift-detection-manager
+	DriftDetectionRequest = RequestType("DriftDetection")
+
+	// GenericRequest is the request type to generate kubeconfig
+	// for any other agent. Permissions are the ones granted by the
+	// ClusterRole referenced by ClusterRoleRef
+	GenericRequest = RequestType("Generic")
 )
 
 // AccessRequestSpec defines the desired state of AccessRequest
@@ -54,12 +63,56 @@ type AccessRequestSpec struct {
 	// Type represent the type of the request
 	Type RequestType `json:"type"`
 
+	// ClusterRoleRef references the ClusterRole granted to the service account.
+	// Required, and only used, when Type is Generic.
+	// +optional
+	ClusterRoleRef *corev1.LocalObjectReference `json:"clusterRoleRef,omitempty"`
+
 	// ControlPlaneEndpoint represents the endpoint used to communicate with the
 	// management cluster controlplane endpoint. It will be used when generating the
 	// kubeconfig.
 	ControlPlaneEndpoint clusterv1.APIEndpoint `json:"controlPlaneEndpoint"`
 
-	// TODO: Add also necessary information for a secure (mTLS) connection
+	// CABundleRef references the CA bundle used to verify the management
+	// cluster controlplane endpoint certificate. If not set, the CA bundle
+	// published in the kube-root-ca.crt ConfigMap of the AccessRequest
+	// namespace is used.
+	// +optional
+	CABundleRef *CABundleReference `json:"caBundleRef,omitempty"`
+
+	// CertificateTTL is the requested duration of validity of the client
+	// certificate issued for this AccessRequest. It is required to issue a
+	// client certificate. Minimum is 10 minutes, maximum is 24 hours.
+	// Issued certificate cannot be revoked and remains valid till it expires.
+	// +optional
+	CertificateTTL *metav1.Duration `json:"certificateTTL,omitempty"`
+
+	// TLSServerName is passed to the server for SNI and is used in the client
+	// to check server certificates against. If not set, the hostname of
+	// ControlPlaneEndpoint is used.
+	// +optional
+	TLSServerName string `json:"tlsServerName,omitempty"`
+}
+
+// CABundleReference references a key of a Secret or ConfigMap
+// containing a PEM encoded CA bundle
+type CABundleReference struct {
+	// Kind of the resource containing the CA bundle.
+	// +kubebuilder:validation:Enum:=Secret;ConfigMap
+	Kind string `json:"kind"`
+
+	// Namespace of the resource containing the CA bundle.
+	// If not set, the AccessRequest namespace is used.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name of the resource containing the CA bundle.
+	Name string `json:"name"`
+
+	// Key within the resource data containing the CA bundle.
+	// Defaults to ca.crt
+	// +optional
+	Key string `json:"key,omitempty"`
 }
 
 // AccessRequestStatus defines the status of AccessRequest
@@ -68,6 +121,11 @@ type AccessRequestStatus struct {
 	// +optional
 	SecretRef *corev1.ObjectReference `json:"secretRef,omitempty"`
 
+	// CertificateExpiration is the time the client certificate contained
+	// in the Kubeconfig expires.
+	// +optional
+	CertificateExpiration *metav1.Time `json:"certificateExpiration,omitempty"`
+
 	// FailureMessage provides more information if an error occurs.
 	// +optional
 	FailureMessage *string `json:"failureMessage,omitempty"`
@@ -20,6 +20,7 @@ import (
 	"errors"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
@@ -71,6 +72,44 @@ var (
 
 type Selector string
 
+// ClusterSelector identifies a set of clusters.
+// A cluster is selected if it is listed in ClusterRefs or if its labels and annotations
+// match LabelSelector and MatchAnnotations. If neither LabelSelector nor MatchAnnotations
+// is set, only clusters listed in ClusterRefs are selected.
+// Selected clusters are then filtered by ClusterTypes, Namespaces and RequireReady.
+type ClusterSelector struct {
+	// LabelSelector selects clusters by labels (matchLabels and matchExpressions)
+	// +optional
+	metav1.LabelSelector `json:",inline"`
+
+	// Selector selects clusters by labels using the label selector string form
+	// (for instance "env=prod,version>2"). It supports operators not available in
+	// LabelSelector (gt and lt). Contrary to an empty LabelSelector, an empty Selector
+	// selects all clusters. Used for selectors converted from the legacy Selector string.
+	// +optional
+	Selector *Selector `json:"selector,omitempty"`
+
+	// MatchAnnotations selects clusters with all the given annotations
+	// +optional
+	MatchAnnotations map[string]string `json:"matchAnnotations,omitempty"`
+
+	// ClusterRefs lists clusters explicitly selected
+	// +optional
+	ClusterRefs []corev1.ObjectReference `json:"clusterRefs,omitempty"`
+
+	// ClusterTypes, if set, restricts selection to clusters of the given types
+	// +optional
+	ClusterTypes []ClusterType `json:"clusterTypes,omitempty"`
+
+	// Namespaces, if set, restricts selection to clusters in the given namespaces
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// RequireReady, if set, restricts selection to clusters ready to be configured
+	// +optional
+	RequireReady bool `json:"requireReady,omitempty"`
+}
+
 // +kubebuilder:validation:Enum:=Provisioning;Provisioned;Failed;Removing;Removed
 type SveltosFeatureStatus string
 
@@ -72,6 +72,30 @@ type RoleRequestStatus struct {
 	// FailureMessage provides more information if an error occurs.
 	// +optional
 	FailureMessage *string `json:"failureMessage,omitempty"`
+
+	// PermissionSummary summarizes the permissions granted to the tenant
+	// by the Roles/ClusterRoles referenced in RoleRefs
+	// +optional
+	PermissionSummary *PermissionSummary `json:"permissionSummary,omitempty"`
+}
+
+// PermissionSummary summarizes the permissions granted to a tenant
+type PermissionSummary struct {
+	// ClusterRules is the number of (aggregated) rules granted cluster wide
+	ClusterRules int `json:"clusterRules"`
+
+	// NamespacedRules is the number of (aggregated) rules granted in specific
+	// namespaces
+	NamespacedRules int `json:"namespacedRules"`
+
+	// Namespaces lists the namespaces where permissions are granted by Roles
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// EscalationRisks lists the granted permissions that allow a tenant to
+	// escalate privileges (wildcards, secrets access, bind/escalate/impersonate)
+	// +optional
+	EscalationRisks []string `json:"escalationRisks,omitempty"`
 }
 
 //+kubebuilder:object:root=true
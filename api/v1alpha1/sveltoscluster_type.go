@@ -33,10 +33,39 @@ type SveltosClusterSpec struct {
 	// and will be used to connect to the Kubernetes cluster.
 	// +optional
 	KubeconfigName string `json:"kubeconfigName,omitempty"`
+
+	// KubeconfigContext is the name of the context, within the kubeconfig, to use
+	// to connect to the Kubernetes cluster. Needed only when kubeconfig contains
+	// more than one context. If not set, kubeconfig current-context is used.
+	// +optional
+	KubeconfigContext string `json:"kubeconfigContext,omitempty"`
+
+	// KubeconfigKeyName is the key, within the Secret, containing the kubeconfig.
+	// If set, the Secret must contain it and it is always used.
+	// If not set, key "value" or "kubeconfig" is used (Secret containing both is
+	// considered an error). Otherwise Secret must contain exactly one key.
+	// +optional
+	KubeconfigKeyName string `json:"kubeconfigKeyName,omitempty"`
+
 	// Paused can be used to prevent controllers from processing the
 	// SveltosCluster and all its associated objects.
 	// +optional
 	Paused bool `json:"paused,omitempty"`
+
+	// TokenRequestRenewalOption, when set, makes Sveltos periodically renew the
+	// token contained in the kubeconfig. Kubeconfig must authenticate as a
+	// ServiceAccount of the managed cluster, which must be allowed to create
+	// tokens for itself.
+	// +optional
+	TokenRequestRenewalOption *TokenRequestRenewalOption `json:"tokenRequestRenewalOption,omitempty"`
+}
+
+// TokenRequestRenewalOption configures kubeconfig token renewal
+type TokenRequestRenewalOption struct {
+	// RenewTokenRequestInterval is the interval at which the token is renewed. Must be positive.
+	// Each new token is requested to be valid for twice this interval (and no less than 10 minutes).
+	// If API server issues a token valid for less, token is renewed once half of its validity has elapsed.
+	RenewTokenRequestInterval metav1.Duration `json:"renewTokenRequestInterval"`
 }
 
 // SveltosClusterStatus defines the status of SveltosCluster
@@ -53,6 +82,26 @@ type SveltosClusterStatus struct {
 	// misconfiguration
 	// +optional
 	FailureMessage *string `json:"failureMessage,omitempty"`
+
+	// LastSuccessfulConnection is the last time the cluster API server
+	// was successfully contacted
+	// +optional
+	LastSuccessfulConnection *metav1.Time `json:"lastSuccessfulConnection,omitempty"`
+
+	// ConsecutiveFailures is the number of consecutive failed attempts
+	// to contact the cluster API server
+	// +optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+
+	// ConnectionLatency is the time it took, during last successful attempt,
+	// for the cluster API server to answer
+	// +optional
+	ConnectionLatency *metav1.Duration `json:"connectionLatency,omitempty"`
+
+	// TokenExpiration is the expiration time of the token last requested
+	// because of TokenRequestRenewalOption
+	// +optional
+	TokenExpiration *metav1.Time `json:"tokenExpiration,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -23,6 +23,7 @@ package v1alpha1
 
 import (
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -31,7 +32,7 @@ func (in *AccessRequest) DeepCopyInto(out *AccessRequest) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -88,7 +89,22 @@ func (in *AccessRequestList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AccessRequestSpec) DeepCopyInto(out *AccessRequestSpec) {
 	*out = *in
+	if in.ClusterRoleRef != nil {
+		in, out := &in.ClusterRoleRef, &out.ClusterRoleRef
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
 	out.ControlPlaneEndpoint = in.ControlPlaneEndpoint
+	if in.CABundleRef != nil {
+		in, out := &in.CABundleRef, &out.CABundleRef
+		*out = new(CABundleReference)
+		**out = **in
+	}
+	if in.CertificateTTL != nil {
+		in, out := &in.CertificateTTL, &out.CertificateTTL
+		*out = new(metav1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AccessRequestSpec.
@@ -109,6 +125,10 @@ func (in *AccessRequestStatus) DeepCopyInto(out *AccessRequestStatus) {
 		*out = new(v1.ObjectReference)
 		**out = **in
 	}
+	if in.CertificateExpiration != nil {
+		in, out := &in.CertificateExpiration, &out.CertificateExpiration
+		*out = (*in).DeepCopy()
+	}
 	if in.FailureMessage != nil {
 		in, out := &in.FailureMessage, &out.FailureMessage
 		*out = new(string)
@@ -270,6 +290,21 @@ func (in *AddonComplianceStatus) DeepCopy() *AddonComplianceStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CABundleReference) DeepCopyInto(out *CABundleReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CABundleReference.
+func (in *CABundleReference) DeepCopy() *CABundleReference {
+	if in == nil {
+		return nil
+	}
+	out := new(CABundleReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Classifier) DeepCopyInto(out *Classifier) {
 	*out = *in
@@ -670,6 +705,49 @@ func (in *ClusterInfo) DeepCopy() *ClusterInfo {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterSelector) DeepCopyInto(out *ClusterSelector) {
+	*out = *in
+	in.LabelSelector.DeepCopyInto(&out.LabelSelector)
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(Selector)
+		**out = **in
+	}
+	if in.MatchAnnotations != nil {
+		in, out := &in.MatchAnnotations, &out.MatchAnnotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ClusterRefs != nil {
+		in, out := &in.ClusterRefs, &out.ClusterRefs
+		*out = make([]v1.ObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClusterTypes != nil {
+		in, out := &in.ClusterTypes, &out.ClusterTypes
+		*out = make([]ClusterType, len(*in))
+		copy(*out, *in)
+	}
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterSelector.
+func (in *ClusterSelector) DeepCopy() *ClusterSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ComponentConfiguration) DeepCopyInto(out *ComponentConfiguration) {
 	*out = *in
@@ -1358,6 +1436,31 @@ func (in *OpenAPIValidationRef) DeepCopy() *OpenAPIValidationRef {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PermissionSummary) DeepCopyInto(out *PermissionSummary) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.EscalationRisks != nil {
+		in, out := &in.EscalationRisks, &out.EscalationRisks
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PermissionSummary.
+func (in *PermissionSummary) DeepCopy() *PermissionSummary {
+	if in == nil {
+		return nil
+	}
+	out := new(PermissionSummary)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PolicyRef) DeepCopyInto(out *PolicyRef) {
 	*out = *in
@@ -1842,6 +1945,11 @@ func (in *RoleRequestStatus) DeepCopyInto(out *RoleRequestStatus) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.PermissionSummary != nil {
+		in, out := &in.PermissionSummary, &out.PermissionSummary
+		*out = new(PermissionSummary)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RoleRequestStatus.
@@ -1859,7 +1967,7 @@ func (in *SveltosCluster) DeepCopyInto(out *SveltosCluster) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -1916,6 +2024,11 @@ func (in *SveltosClusterList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SveltosClusterSpec) DeepCopyInto(out *SveltosClusterSpec) {
 	*out = *in
+	if in.TokenRequestRenewalOption != nil {
+		in, out := &in.TokenRequestRenewalOption, &out.TokenRequestRenewalOption
+		*out = new(TokenRequestRenewalOption)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SveltosClusterSpec.
@@ -1936,6 +2049,19 @@ func (in *SveltosClusterStatus) DeepCopyInto(out *SveltosClusterStatus) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.LastSuccessfulConnection != nil {
+		in, out := &in.LastSuccessfulConnection, &out.LastSuccessfulConnection
+		*out = (*in).DeepCopy()
+	}
+	if in.ConnectionLatency != nil {
+		in, out := &in.ConnectionLatency, &out.ConnectionLatency
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.TokenExpiration != nil {
+		in, out := &in.TokenExpiration, &out.TokenExpiration
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SveltosClusterStatus.
@@ -1948,6 +2074,22 @@ func (in *SveltosClusterStatus) DeepCopy() *SveltosClusterStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TokenRequestRenewalOption) DeepCopyInto(out *TokenRequestRenewalOption) {
+	*out = *in
+	out.RenewTokenRequestInterval = in.RenewTokenRequestInterval
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TokenRequestRenewalOption.
+func (in *TokenRequestRenewalOption) DeepCopy() *TokenRequestRenewalOption {
+	if in == nil {
+		return nil
+	}
+	out := new(TokenRequestRenewalOption)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *UnManagedLabel) DeepCopyInto(out *UnManagedLabel) {
 	*out = *in
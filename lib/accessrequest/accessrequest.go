@@ -0,0 +1,564 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accessrequest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+	"github.com/projectsveltos/libsveltos/lib/utils"
+)
+
+const (
+	// Key is the key, within the Secret generated for an AccessRequest, containing the Kubeconfig
+	Key = "kubeconfig"
+
+	kubeconfigSecretNamePostfix = "-kubeconfig"
+
+	privateKeySecretNamePostfix = "-client-key"
+
+	defaultCABundleKey = "ca.crt"
+
+	// rootCAConfigMapName is the ConfigMap published by kube-controller-manager in
+	// each namespace with the CA bundle to verify the kube-apiserver certificate
+	rootCAConfigMapName = "kube-root-ca.crt"
+
+	// minimumCertificateTTL is the minimum expirationSeconds accepted by the
+	// CertificateSigningRequest API
+	minimumCertificateTTL = 10 * time.Minute
+
+	// maximumCertificateTTL is the maximum CertificateTTL accepted. Issued client
+	// certificates cannot be revoked, so their validity is kept short.
+	maximumCertificateTTL = 24 * time.Hour
+)
+
+var (
+	// ErrCertificatePending is returned while the CertificateSigningRequest created for an
+	// AccessRequest is waiting to be approved and signed. Caller should retry later.
+	ErrCertificatePending = errors.New("client certificate not issued yet")
+)
+
+// GenerateSveltosAgentKubeconfig generates the Kubeconfig for an AccessRequest of type SveltosAgent.
+// See GenerateKubeconfig.
+func GenerateSveltosAgentKubeconfig(ctx context.Context, c client.Client,
+	accessRequest *libsveltosv1alpha1.AccessRequest, logger logr.Logger) (*corev1.Secret, error) {
+
+	if accessRequest.Spec.Type != libsveltosv1alpha1.SveltosAgentRequest {
+		return nil, fmt.Errorf("AccessRequest type %q is not %q", accessRequest.Spec.Type,
+			libsveltosv1alpha1.SveltosAgentRequest)
+	}
+
+	return GenerateKubeconfig(ctx, c, accessRequest, logger)
+}
+
+// GenerateKubeconfig generates the Kubeconfig for an AccessRequest.
+// The Kubeconfig authenticates with a client certificate (mTLS). Certificate is issued by the
+// management cluster CertificateSigningRequest API (signer kubernetes.io/kube-apiserver-client)
+// for user system:serviceaccount:<spec.namespace>:<spec.name>, so RBAC granted to the
+// AccessRequest ServiceAccount (see DeployServiceAccountAndRBAC) applies.
+// CertificateSigningRequest is not approved by GenerateKubeconfig: approving requests for the
+// kube-apiserver-client signer allows to impersonate any user. An external approver (a cluster
+// admin or an approver controller) must approve it. CertificateSigningRequest is created with the
+// identity of the caller (controller ServiceAccount), not the one requested. So whoever approves
+// must verify the requested CommonName (system:serviceaccount:<spec.namespace>:<spec.name>) and
+// Organizations (system:serviceaccounts, system:serviceaccounts:<spec.namespace>) match the
+// AccessRequest (CertificateSigningRequest is labelled with AccessRequest namespace and name).
+// Till certificate is issued, ErrCertificatePending is returned and caller should retry later.
+// CertificateSigningRequest and private key are kept across calls and removed once
+// certificate is collected.
+// Issued certificate cannot be revoked, it is valid till it expires. So accessRequest must
+// set CertificateTTL, between 10 minutes and 24 hours.
+// The Kubeconfig is stored in a Secret, in the AccessRequest namespace, owned by the AccessRequest.
+// AccessRequest Status SecretRef and CertificateExpiration are set. It is the caller responsibility
+// to persist AccessRequest Status.
+func GenerateKubeconfig(ctx context.Context, c client.Client,
+	accessRequest *libsveltosv1alpha1.AccessRequest, logger logr.Logger) (*corev1.Secret, error) {
+
+	if err := validateAccessRequest(accessRequest); err != nil {
+		return nil, err
+	}
+
+	expirationSeconds, err := getExpirationSeconds(accessRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	logger = logger.WithValues("accessrequest", fmt.Sprintf("%s/%s", accessRequest.Namespace, accessRequest.Name))
+
+	server, err := getServer(accessRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	caData, err := getCABundle(ctx, c, accessRequest)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to get CA bundle: %v", err))
+		return nil, err
+	}
+
+	certData, keyData, err := getClientCertificate(ctx, c, accessRequest, expirationSeconds, logger)
+	if err != nil {
+		if !errors.Is(err, ErrCertificatePending) {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to get client certificate: %v", err))
+		}
+		return nil, err
+	}
+
+	kubeconfig, err := utils.GetKubeconfigWithClientCertificate(certData, keyData, caData,
+		GetUserName(accessRequest), server)
+	if err != nil {
+		return nil, err
+	}
+
+	if accessRequest.Spec.TLSServerName != "" {
+		kubeconfig, err = setTLSServerName(kubeconfig, accessRequest.Spec.TLSServerName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	secret, err := createOrUpdateSecret(ctx, c, accessRequest, kubeconfig)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to create kubeconfig secret: %v", err))
+		return nil, err
+	}
+
+	expiration, err := getCertificateExpiration(certData)
+	if err != nil {
+		return nil, err
+	}
+
+	// Certificate has been collected. CertificateSigningRequest and private key are not needed anymore.
+	if err := removeCertificateRequest(ctx, c, accessRequest); err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to remove CertificateSigningRequest: %v", err))
+		return nil, err
+	}
+
+	accessRequest.Status.SecretRef = &corev1.ObjectReference{
+		Kind:       "Secret",
+		APIVersion: "v1",
+		Namespace:  secret.Namespace,
+		Name:       secret.Name,
+	}
+	accessRequest.Status.CertificateExpiration = &metav1.Time{Time: expiration}
+
+	logger.V(logs.LogDebug).Info(fmt.Sprintf("kubeconfig stored in secret %s/%s (certificate expires %s)",
+		secret.Namespace, secret.Name, expiration.String()))
+	return secret, nil
+}
+
+// GetUserName returns the user the client certificate generated for accessRequest is issued to
+func GetUserName(accessRequest *libsveltosv1alpha1.AccessRequest) string {
+	return fmt.Sprintf("system:serviceaccount:%s:%s", accessRequest.Spec.Namespace, accessRequest.Spec.Name)
+}
+
+// getExpirationSeconds validates accessRequest CertificateTTL and returns it in seconds
+func getExpirationSeconds(accessRequest *libsveltosv1alpha1.AccessRequest) (int32, error) {
+	ttl := accessRequest.Spec.CertificateTTL
+	if ttl == nil {
+		return 0, fmt.Errorf("certificateTTL must be set")
+	}
+
+	if ttl.Duration < minimumCertificateTTL {
+		return 0, fmt.Errorf("certificateTTL %s is less than minimum %s",
+			ttl.Duration.String(), minimumCertificateTTL.String())
+	}
+
+	if ttl.Duration > maximumCertificateTTL {
+		return 0, fmt.Errorf("certificateTTL %s is more than maximum %s",
+			ttl.Duration.String(), maximumCertificateTTL.String())
+	}
+
+	seconds := int64(ttl.Duration.Seconds())
+	if seconds > math.MaxInt32 {
+		return 0, fmt.Errorf("certificateTTL %s overflows expirationSeconds", ttl.Duration.String())
+	}
+
+	return int32(seconds), nil
+}
+
+// getServer returns the management cluster controlplane endpoint URL
+func getServer(accessRequest *libsveltosv1alpha1.AccessRequest) (string, error) {
+	endpoint := accessRequest.Spec.ControlPlaneEndpoint
+	if endpoint.Host == "" {
+		return "", fmt.Errorf("controlPlaneEndpoint host cannot be empty")
+	}
+
+	if endpoint.Port == 0 {
+		host := endpoint.Host
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+		return "https://" + host, nil
+	}
+
+	return "https://" + net.JoinHostPort(endpoint.Host, strconv.Itoa(int(endpoint.Port))), nil
+}
+
+// getCABundle returns the CA bundle referenced by accessRequest CABundleRef. If not set,
+// the CA bundle contained in the kube-root-ca.crt ConfigMap is returned
+func getCABundle(ctx context.Context, c client.Client,
+	accessRequest *libsveltosv1alpha1.AccessRequest) ([]byte, error) {
+
+	ref := accessRequest.Spec.CABundleRef
+	if ref == nil {
+		ref = &libsveltosv1alpha1.CABundleReference{
+			Kind: "ConfigMap",
+			Name: rootCAConfigMapName,
+		}
+	}
+
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = accessRequest.Namespace
+	}
+	key := ref.Key
+	if key == "" {
+		key = defaultCABundleKey
+	}
+
+	var caData []byte
+	switch ref.Kind {
+	case "Secret":
+		secret := &corev1.Secret{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, secret); err != nil {
+			return nil, err
+		}
+		caData = secret.Data[key]
+	case "ConfigMap":
+		configMap := &corev1.ConfigMap{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, configMap); err != nil {
+			return nil, err
+		}
+		caData = []byte(configMap.Data[key])
+	default:
+		return nil, fmt.Errorf("unsupported CA bundle kind %q", ref.Kind)
+	}
+
+	if len(caData) == 0 {
+		return nil, fmt.Errorf("%s %s/%s does not contain CA bundle in key %s", ref.Kind, namespace, ref.Name, key)
+	}
+
+	return caData, nil
+}
+
+// getClientCertificate returns the client certificate, and its private key, issued for accessRequest
+// via the CertificateSigningRequest API. Returns PEM encoded certificate and key.
+// Private key is generated once and kept in a Secret till certificate is collected. If no
+// CertificateSigningRequest exists, one is created. ErrCertificatePending is returned till
+// CertificateSigningRequest is approved (by an external approver) and signed.
+func getClientCertificate(ctx context.Context, c client.Client, accessRequest *libsveltosv1alpha1.AccessRequest,
+	expirationSeconds int32, logger logr.Logger) (certData, keyData []byte, err error) {
+
+	keyData, err = getPrivateKey(ctx, c, accessRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csr := &certificatesv1.CertificateSigningRequest{}
+	err = c.Get(ctx, types.NamespacedName{Name: getCertificateSigningRequestName(accessRequest)}, csr)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, nil, err
+		}
+		return nil, nil, requestClientCertificate(ctx, c, accessRequest, keyData, expirationSeconds, logger)
+	}
+
+	for i := range csr.Status.Conditions {
+		condition := &csr.Status.Conditions[i]
+		if condition.Type == certificatesv1.CertificateDenied ||
+			condition.Type == certificatesv1.CertificateFailed {
+
+			// Remove it so a new CertificateSigningRequest is created on next attempt
+			if cleanupErr := removeCertificateRequest(ctx, c, accessRequest); cleanupErr != nil {
+				return nil, nil, cleanupErr
+			}
+			return nil, nil, fmt.Errorf("CertificateSigningRequest %s %s: %s", csr.Name,
+				condition.Type, condition.Message)
+		}
+	}
+
+	if len(csr.Status.Certificate) == 0 {
+		logger.V(logs.LogDebug).Info(fmt.Sprintf("CertificateSigningRequest %s not approved and signed yet",
+			csr.Name))
+		return nil, nil, ErrCertificatePending
+	}
+
+	if keyData == nil || !isKeyPair(csr.Status.Certificate, keyData) {
+		// Certificate cannot be used. Start over.
+		if cleanupErr := removeCertificateRequest(ctx, c, accessRequest); cleanupErr != nil {
+			return nil, nil, cleanupErr
+		}
+		return nil, nil, fmt.Errorf("private key for CertificateSigningRequest %s not found", csr.Name)
+	}
+
+	return csr.Status.Certificate, keyData, nil
+}
+
+// requestClientCertificate creates the CertificateSigningRequest for accessRequest. If keyData is
+// nil, a new private key is generated and stored. Returns ErrCertificatePending on success.
+func requestClientCertificate(ctx context.Context, c client.Client, accessRequest *libsveltosv1alpha1.AccessRequest,
+	keyData []byte, expirationSeconds int32, logger logr.Logger) error {
+
+	var key *ecdsa.PrivateKey
+	var err error
+	if keyData == nil {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		if err := createPrivateKeySecret(ctx, c, accessRequest, key); err != nil {
+			return err
+		}
+	} else {
+		key, err = parsePrivateKey(keyData)
+		if err != nil {
+			return err
+		}
+	}
+
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName: GetUserName(accessRequest),
+			Organization: []string{
+				"system:serviceaccounts",
+				"system:serviceaccounts:" + accessRequest.Spec.Namespace,
+			},
+		},
+	}, key)
+	if err != nil {
+		return err
+	}
+
+	csr, err := createCertificateSigningRequest(ctx, c, accessRequest, expirationSeconds,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes}))
+	if err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return ErrCertificatePending
+		}
+		return err
+	}
+
+	logger.V(logs.LogDebug).Info(fmt.Sprintf("created CertificateSigningRequest %s", csr.Name))
+	return ErrCertificatePending
+}
+
+func createCertificateSigningRequest(ctx context.Context, c client.Client, accessRequest *libsveltosv1alpha1.AccessRequest,
+	expirationSeconds int32, request []byte) (*certificatesv1.CertificateSigningRequest, error) {
+
+	csr := &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   getCertificateSigningRequestName(accessRequest),
+			Labels: getLabels(accessRequest),
+		},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:           request,
+			SignerName:        certificatesv1.KubeAPIServerClientSignerName,
+			ExpirationSeconds: &expirationSeconds,
+			Usages: []certificatesv1.KeyUsage{
+				certificatesv1.UsageDigitalSignature,
+				certificatesv1.UsageClientAuth,
+			},
+		},
+	}
+
+	if err := c.Create(ctx, csr); err != nil {
+		return nil, err
+	}
+
+	return csr, nil
+}
+
+// getCertificateSigningRequestName returns the name of the CertificateSigningRequest created
+// for accessRequest
+func getCertificateSigningRequestName(accessRequest *libsveltosv1alpha1.AccessRequest) string {
+	return getRBACName(accessRequest)
+}
+
+// getPrivateKeySecretName returns the name of the Secret containing the private key
+// of the client certificate requested for accessRequest
+func getPrivateKeySecretName(accessRequest *libsveltosv1alpha1.AccessRequest) string {
+	return accessRequest.Name + privateKeySecretNamePostfix
+}
+
+// getPrivateKey returns the PEM encoded private key of the client certificate requested
+// for accessRequest. Returns nil if no key was generated yet.
+func getPrivateKey(ctx context.Context, c client.Client,
+	accessRequest *libsveltosv1alpha1.AccessRequest) ([]byte, error) {
+
+	secret := &corev1.Secret{}
+	err := c.Get(ctx,
+		types.NamespacedName{Namespace: accessRequest.Namespace, Name: getPrivateKeySecretName(accessRequest)}, secret)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return secret.Data[corev1.TLSPrivateKeyKey], nil
+}
+
+// createPrivateKeySecret stores key in a Secret, in the AccessRequest namespace, owned by the AccessRequest
+func createPrivateKeySecret(ctx context.Context, c client.Client,
+	accessRequest *libsveltosv1alpha1.AccessRequest, key *ecdsa.PrivateKey) error {
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: accessRequest.Namespace,
+			Name:      getPrivateKeySecretName(accessRequest),
+			Labels:    getLabels(accessRequest),
+		},
+		Data: map[string][]byte{
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}),
+		},
+	}
+	if err := controllerutil.SetOwnerReference(accessRequest, secret, c.Scheme()); err != nil {
+		return err
+	}
+
+	return c.Create(ctx, secret)
+}
+
+func parsePrivateKey(keyData []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM encoded")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// isKeyPair returns true if certData (PEM encoded) was issued for keyData (PEM encoded) private key
+func isKeyPair(certData, keyData []byte) bool {
+	_, err := tls.X509KeyPair(certData, keyData)
+	return err == nil
+}
+
+// removeCertificateRequest deletes the CertificateSigningRequest and the private key
+// Secret created for accessRequest
+func removeCertificateRequest(ctx context.Context, c client.Client,
+	accessRequest *libsveltosv1alpha1.AccessRequest) error {
+
+	objects := []client.Object{
+		&certificatesv1.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: getCertificateSigningRequestName(accessRequest)},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: accessRequest.Namespace, Name: getPrivateKeySecretName(accessRequest),
+			},
+		},
+	}
+
+	for i := range objects {
+		if err := c.Delete(ctx, objects[i]); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// getCertificateExpiration returns NotAfter of the first certificate in certData
+func getCertificateExpiration(certData []byte) (time.Time, error) {
+	block, _ := pem.Decode(certData)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("issued certificate is not PEM encoded")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return cert.NotAfter, nil
+}
+
+// setTLSServerName sets tlsServerName for all clusters in kubeconfig
+func setTLSServerName(kubeconfig []byte, tlsServerName string) ([]byte, error) {
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range config.Clusters {
+		config.Clusters[name].TLSServerName = tlsServerName
+	}
+
+	return clientcmd.Write(*config)
+}
+
+// GetSecretName returns the name of the Secret containing the Kubeconfig generated
+// for accessRequest
+func GetSecretName(accessRequest *libsveltosv1alpha1.AccessRequest) string {
+	return accessRequest.Name + kubeconfigSecretNamePostfix
+}
+
+func createOrUpdateSecret(ctx context.Context, c client.Client,
+	accessRequest *libsveltosv1alpha1.AccessRequest, kubeconfig []byte) (*corev1.Secret, error) {
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: accessRequest.Namespace,
+			Name:      GetSecretName(accessRequest),
+		},
+	}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, c, secret, func() error {
+		if secret.Labels == nil {
+			secret.Labels = map[string]string{}
+		}
+		secret.Labels[libsveltosv1alpha1.AccessRequestNameLabel] = accessRequest.Name
+		secret.Data = map[string][]byte{
+			Key: kubeconfig,
+		}
+		return controllerutil.SetOwnerReference(accessRequest, secret, c.Scheme())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,475 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accessrequest_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2/klogr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/accessrequest"
+)
+
+// testSigner approves CertificateSigningRequests, as an external approver would do,
+// and signs them, as kube-controller-manager (not running in envtest) would do
+type testSigner struct {
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+}
+
+func newTestSigner() *testSigner {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caBytes, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	Expect(err).To(BeNil())
+	caCert, err := x509.ParseCertificate(caBytes)
+	Expect(err).To(BeNil())
+
+	return &testSigner{caCert: caCert, caKey: caKey}
+}
+
+// run approves and signs all CertificateSigningRequests created for accessRequestName till ctx is canceled
+func (s *testSigner) run(ctx context.Context, accessRequestName string) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(200 * time.Millisecond):
+		}
+
+		csrs := &certificatesv1.CertificateSigningRequestList{}
+		if err := testEnv.List(ctx, csrs,
+			client.MatchingLabels{libsveltosv1alpha1.AccessRequestNameLabel: accessRequestName}); err != nil {
+			continue
+		}
+
+		for i := range csrs.Items {
+			csr := &csrs.Items[i]
+			if len(csr.Status.Certificate) != 0 {
+				continue
+			}
+			if !isApproved(csr) {
+				approve(ctx, csr)
+				continue
+			}
+			certData, err := s.sign(csr)
+			if err != nil {
+				continue
+			}
+			csr.Status.Certificate = certData
+			_ = testEnv.Status().Update(ctx, csr)
+		}
+	}
+}
+
+func (s *testSigner) sign(csr *certificatesv1.CertificateSigningRequest) ([]byte, error) {
+	block, _ := pem.Decode(csr.Spec.Request)
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	validity := 365 * 24 * time.Hour
+	if csr.Spec.ExpirationSeconds != nil {
+		validity = time.Duration(*csr.Spec.ExpirationSeconds) * time.Second
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      request.Subject,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, s.caCert, request.PublicKey, s.caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}), nil
+}
+
+func approve(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) {
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+		Type:           certificatesv1.CertificateApproved,
+		Status:         corev1.ConditionTrue,
+		Reason:         "TestApprover",
+		LastUpdateTime: metav1.Now(),
+	})
+	_ = testEnv.SubResource("approval").Update(ctx, csr)
+}
+
+func isApproved(csr *certificatesv1.CertificateSigningRequest) bool {
+	for i := range csr.Status.Conditions {
+		if csr.Status.Conditions[i].Type == certificatesv1.CertificateApproved {
+			return true
+		}
+	}
+	return false
+}
+
+var _ = Describe("AccessRequest", func() {
+	var namespace string
+	var accessRequest *libsveltosv1alpha1.AccessRequest
+
+	BeforeEach(func() {
+		namespace = randomString()
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+		Expect(testEnv.Create(context.TODO(), ns)).To(Succeed())
+
+		accessRequest = &libsveltosv1alpha1.AccessRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      randomString(),
+			},
+			Spec: libsveltosv1alpha1.AccessRequestSpec{
+				Namespace: randomString(),
+				Name:      randomString(),
+				Type:      libsveltosv1alpha1.SveltosAgentRequest,
+				ControlPlaneEndpoint: clusterv1.APIEndpoint{
+					Host: "10.0.0.1",
+					Port: 6443,
+				},
+				CertificateTTL: &metav1.Duration{Duration: time.Hour},
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), accessRequest)).To(Succeed())
+	})
+
+	It("GenerateSveltosAgentKubeconfig issues a client certificate and stores kubeconfig", func() {
+		rootCA := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      "kube-root-ca.crt",
+			},
+			Data: map[string]string{
+				"ca.crt": string(testEnv.Config.CAData),
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), rootCA)).To(Succeed())
+
+		accessRequest.Spec.TLSServerName = "management.example.com"
+
+		signerCtx, signerCancel := context.WithCancel(ctx)
+		defer signerCancel()
+		go newTestSigner().run(signerCtx, accessRequest.Name)
+
+		// Eventual loop so testEnv Cache is synced
+		var secret *corev1.Secret
+		Eventually(func() error {
+			var err error
+			secret, err = accessrequest.GenerateSveltosAgentKubeconfig(context.TODO(), testEnv.Client,
+				accessRequest, klogr.New())
+			return err
+		}, time.Minute, time.Second).Should(BeNil())
+
+		Expect(secret.Namespace).To(Equal(accessRequest.Namespace))
+		Expect(secret.Name).To(Equal(accessrequest.GetSecretName(accessRequest)))
+		Expect(secret.Labels[libsveltosv1alpha1.AccessRequestNameLabel]).To(Equal(accessRequest.Name))
+		Expect(secret.OwnerReferences).To(HaveLen(1))
+		Expect(secret.OwnerReferences[0].Name).To(Equal(accessRequest.Name))
+
+		Expect(accessRequest.Status.SecretRef).ToNot(BeNil())
+		Expect(accessRequest.Status.SecretRef.Namespace).To(Equal(secret.Namespace))
+		Expect(accessRequest.Status.SecretRef.Name).To(Equal(secret.Name))
+		Expect(accessRequest.Status.CertificateExpiration).ToNot(BeNil())
+		Expect(accessRequest.Status.CertificateExpiration.Time).To(
+			BeTemporally("~", time.Now().Add(time.Hour), 2*time.Minute))
+
+		config, err := clientcmd.Load(secret.Data[accessrequest.Key])
+		Expect(err).To(BeNil())
+		Expect(clientcmd.Validate(*config)).To(Succeed())
+
+		userName := accessrequest.GetUserName(accessRequest)
+		cluster := config.Clusters[userName]
+		Expect(cluster).ToNot(BeNil())
+		Expect(cluster.Server).To(Equal("https://10.0.0.1:6443"))
+		Expect(cluster.TLSServerName).To(Equal("management.example.com"))
+		Expect(cluster.CertificateAuthorityData).To(Equal(testEnv.Config.CAData))
+
+		authInfo := config.AuthInfos[userName]
+		Expect(authInfo).ToNot(BeNil())
+		block, _ := pem.Decode(authInfo.ClientCertificateData)
+		Expect(block).ToNot(BeNil())
+		cert, err := x509.ParseCertificate(block.Bytes)
+		Expect(err).To(BeNil())
+		Expect(cert.Subject.CommonName).To(Equal(
+			"system:serviceaccount:" + accessRequest.Spec.Namespace + ":" + accessRequest.Spec.Name))
+		Expect(cert.NotAfter.Unix()).To(Equal(accessRequest.Status.CertificateExpiration.Unix()))
+
+		// CertificateSigningRequests are removed once certificate is collected
+		Eventually(func() int {
+			csrs := &certificatesv1.CertificateSigningRequestList{}
+			if err := testEnv.List(context.TODO(), csrs,
+				client.MatchingLabels{libsveltosv1alpha1.AccessRequestNameLabel: accessRequest.Name}); err != nil {
+				return -1
+			}
+			return len(csrs.Items)
+		}, time.Minute, time.Second).Should(BeZero())
+	})
+
+	It("GenerateSveltosAgentKubeconfig does not approve and keeps CertificateSigningRequest till signed", func() {
+		rootCA := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      "kube-root-ca.crt",
+			},
+			Data: map[string]string{
+				"ca.crt": string(testEnv.Config.CAData),
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), rootCA)).To(Succeed())
+
+		listCSRs := func() []certificatesv1.CertificateSigningRequest {
+			csrs := &certificatesv1.CertificateSigningRequestList{}
+			Expect(testEnv.List(context.TODO(), csrs,
+				client.MatchingLabels{libsveltosv1alpha1.AccessRequestNameLabel: accessRequest.Name})).To(Succeed())
+			return csrs.Items
+		}
+
+		// Eventual loop so testEnv Cache is synced. No approver is running, so
+		// certificate is not issued.
+		Eventually(func() bool {
+			_, err := accessrequest.GenerateSveltosAgentKubeconfig(context.TODO(), testEnv.Client,
+				accessRequest, klogr.New())
+			return errors.Is(err, accessrequest.ErrCertificatePending)
+		}, time.Minute, time.Second).Should(BeTrue())
+		Eventually(listCSRs, time.Minute, time.Second).Should(HaveLen(1))
+
+		// Retries do not create new CertificateSigningRequests
+		for i := 0; i < 3; i++ {
+			_, err := accessrequest.GenerateSveltosAgentKubeconfig(context.TODO(), testEnv.Client,
+				accessRequest, klogr.New())
+			Expect(errors.Is(err, accessrequest.ErrCertificatePending)).To(BeTrue())
+		}
+		csrs := listCSRs()
+		Expect(csrs).To(HaveLen(1))
+		Expect(isApproved(&csrs[0])).To(BeFalse())
+		Expect(accessRequest.Status.SecretRef).To(BeNil())
+
+		// Late approval: certificate is collected on a later call
+		signerCtx, signerCancel := context.WithCancel(ctx)
+		defer signerCancel()
+		go newTestSigner().run(signerCtx, accessRequest.Name)
+
+		Eventually(func() error {
+			_, err := accessrequest.GenerateSveltosAgentKubeconfig(context.TODO(), testEnv.Client,
+				accessRequest, klogr.New())
+			return err
+		}, time.Minute, time.Second).Should(BeNil())
+		Expect(accessRequest.Status.SecretRef).ToNot(BeNil())
+
+		// CertificateSigningRequest and private key are removed once certificate is collected
+		Eventually(listCSRs, time.Minute, time.Second).Should(BeEmpty())
+		Eventually(func() bool {
+			secrets := &corev1.SecretList{}
+			Expect(testEnv.List(context.TODO(), secrets, client.InNamespace(namespace))).To(Succeed())
+			return len(secrets.Items) == 1 && secrets.Items[0].Name == accessrequest.GetSecretName(accessRequest)
+		}, time.Minute, time.Second).Should(BeTrue())
+	})
+
+	It("GenerateSveltosAgentKubeconfig fails and removes denied CertificateSigningRequest", func() {
+		rootCA := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      "kube-root-ca.crt",
+			},
+			Data: map[string]string{
+				"ca.crt": string(testEnv.Config.CAData),
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), rootCA)).To(Succeed())
+
+		csr := &certificatesv1.CertificateSigningRequest{}
+		Eventually(func() error {
+			_, err := accessrequest.GenerateSveltosAgentKubeconfig(context.TODO(), testEnv.Client,
+				accessRequest, klogr.New())
+			if !errors.Is(err, accessrequest.ErrCertificatePending) {
+				return err
+			}
+			csrs := &certificatesv1.CertificateSigningRequestList{}
+			if err := testEnv.List(context.TODO(), csrs,
+				client.MatchingLabels{libsveltosv1alpha1.AccessRequestNameLabel: accessRequest.Name}); err != nil {
+				return err
+			}
+			if len(csrs.Items) != 1 {
+				return fmt.Errorf("found %d CertificateSigningRequests", len(csrs.Items))
+			}
+			csrs.Items[0].DeepCopyInto(csr)
+			return nil
+		}, time.Minute, time.Second).Should(BeNil())
+
+		csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+			Type:           certificatesv1.CertificateDenied,
+			Status:         corev1.ConditionTrue,
+			Reason:         "TestApprover",
+			Message:        "not allowed",
+			LastUpdateTime: metav1.Now(),
+		})
+		Expect(testEnv.SubResource("approval").Update(context.TODO(), csr)).To(Succeed())
+
+		Eventually(func() bool {
+			_, err := accessrequest.GenerateSveltosAgentKubeconfig(context.TODO(), testEnv.Client,
+				accessRequest, klogr.New())
+			return err != nil && strings.Contains(err.Error(), "not allowed")
+		}, time.Minute, time.Second).Should(BeTrue())
+
+		Eventually(func() bool {
+			err := testEnv.Get(context.TODO(), types.NamespacedName{Name: csr.Name},
+				&certificatesv1.CertificateSigningRequest{})
+			return apierrors.IsNotFound(err)
+		}, time.Minute, time.Second).Should(BeTrue())
+	})
+
+	It("GenerateSveltosAgentKubeconfig uses referenced CA bundle", func() {
+		caData := []byte("custom-ca-bundle")
+		caSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      randomString(),
+			},
+			Data: map[string][]byte{
+				"bundle": caData,
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), caSecret)).To(Succeed())
+
+		accessRequest.Spec.CABundleRef = &libsveltosv1alpha1.CABundleReference{
+			Kind: "Secret",
+			Name: caSecret.Name,
+			Key:  "bundle",
+		}
+
+		signerCtx, signerCancel := context.WithCancel(ctx)
+		defer signerCancel()
+		go newTestSigner().run(signerCtx, accessRequest.Name)
+
+		Eventually(func() error {
+			_, err := accessrequest.GenerateSveltosAgentKubeconfig(context.TODO(), testEnv.Client,
+				accessRequest, klogr.New())
+			return err
+		}, time.Minute, time.Second).Should(BeNil())
+
+		Eventually(func() error {
+			secret := &corev1.Secret{}
+			err := testEnv.Get(context.TODO(),
+				types.NamespacedName{Namespace: namespace, Name: accessrequest.GetSecretName(accessRequest)}, secret)
+			if err != nil {
+				return err
+			}
+			config, err := clientcmd.Load(secret.Data[accessrequest.Key])
+			if err != nil {
+				return err
+			}
+			Expect(config.Clusters[accessrequest.GetUserName(accessRequest)].CertificateAuthorityData).To(Equal(caData))
+			return nil
+		}, time.Minute, time.Second).Should(BeNil())
+	})
+
+	It("GenerateSveltosAgentKubeconfig validates AccessRequest", func() {
+		accessRequest.Spec.CABundleRef = &libsveltosv1alpha1.CABundleReference{
+			Kind: "ConfigMap",
+			Name: randomString(),
+		}
+		_, err := accessrequest.GenerateSveltosAgentKubeconfig(context.TODO(), testEnv.Client,
+			accessRequest, klogr.New())
+		Expect(err).ToNot(BeNil())
+
+		accessRequest.Spec.Type = libsveltosv1alpha1.RequestType("Different")
+		_, err = accessrequest.GenerateSveltosAgentKubeconfig(context.TODO(), testEnv.Client,
+			accessRequest, klogr.New())
+		Expect(err).ToNot(BeNil())
+		Expect(accessRequest.Status.SecretRef).To(BeNil())
+	})
+
+	It("GenerateSveltosAgentKubeconfig rejects certificateTTL not set or out of range", func() {
+		for _, ttl := range []*metav1.Duration{
+			nil,
+			{Duration: time.Minute},
+			{Duration: 25 * time.Hour},
+			{Duration: 100 * 365 * 24 * time.Hour},
+		} {
+			accessRequest.Spec.CertificateTTL = ttl
+
+			_, err := accessrequest.GenerateSveltosAgentKubeconfig(context.TODO(), testEnv.Client,
+				accessRequest, klogr.New())
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("certificateTTL"))
+		}
+
+		// No CertificateSigningRequest is created
+		csrs := &certificatesv1.CertificateSigningRequestList{}
+		Expect(testEnv.List(context.TODO(), csrs,
+			client.MatchingLabels{libsveltosv1alpha1.AccessRequestNameLabel: accessRequest.Name})).To(Succeed())
+		Expect(csrs.Items).To(BeEmpty())
+	})
+
+	It("getServer returns controlplane endpoint URL", func() {
+		accessRequest.Spec.ControlPlaneEndpoint = clusterv1.APIEndpoint{Host: "fd00::1", Port: 6443}
+		server, err := accessrequest.GetServer(accessRequest)
+		Expect(err).To(BeNil())
+		Expect(server).To(Equal("https://[fd00::1]:6443"))
+
+		accessRequest.Spec.ControlPlaneEndpoint = clusterv1.APIEndpoint{Host: "fd00::1"}
+		server, err = accessrequest.GetServer(accessRequest)
+		Expect(err).To(BeNil())
+		Expect(server).To(Equal("https://[fd00::1]"))
+
+		accessRequest.Spec.ControlPlaneEndpoint = clusterv1.APIEndpoint{Host: "management.example.com", Port: 443}
+		server, err = accessrequest.GetServer(accessRequest)
+		Expect(err).To(BeNil())
+		Expect(server).To(Equal("https://management.example.com:443"))
+
+		accessRequest.Spec.ControlPlaneEndpoint = clusterv1.APIEndpoint{}
+		_, err = accessrequest.GetServer(accessRequest)
+		Expect(err).ToNot(BeNil())
+	})
+})
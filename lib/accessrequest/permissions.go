@@ -0,0 +1,106 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accessrequest
+
+import (
+	"fmt"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+)
+
+// Each AccessRequest type gets a least-privilege identity in the management cluster.
+// Here there are the permission sets granted to each (predefined) AccessRequest type.
+
+var (
+	// sveltosAgentPolicyRules are the permissions needed by sveltos-agent
+	// to report to the management cluster
+	sveltosAgentPolicyRules = []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{libsveltosv1alpha1.GroupVersion.Group},
+			Resources: []string{"classifierreports", "healthcheckreports", "eventreports", "reloaderreports"},
+			Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+		},
+		{
+			APIGroups: []string{libsveltosv1alpha1.GroupVersion.Group},
+			Resources: []string{"classifierreports/status", "healthcheckreports/status",
+				"eventreports/status", "reloaderreports/status"},
+			Verbs: []string{"get", "update", "patch"},
+		},
+	}
+
+	// driftDetectionPolicyRules are the permissions needed by drift-detection-manager
+	// to report configuration drifts to the management cluster
+	driftDetectionPolicyRules = []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{libsveltosv1alpha1.GroupVersion.Group},
+			Resources: []string{"resourcesummaries"},
+			Verbs:     []string{"get", "list", "watch", "update", "patch"},
+		},
+		{
+			APIGroups: []string{libsveltosv1alpha1.GroupVersion.Group},
+			Resources: []string{"resourcesummaries/status"},
+			Verbs:     []string{"get", "update", "patch"},
+		},
+	}
+)
+
+// GetPolicyRules returns the permissions granted, in the management cluster, to an
+// AccessRequest of type requestType.
+// GenericRequest has no predefined permissions (ClusterRole referenced by the AccessRequest
+// is used instead), so nil is returned.
+func GetPolicyRules(requestType libsveltosv1alpha1.RequestType) ([]rbacv1.PolicyRule, error) {
+	var rules []rbacv1.PolicyRule
+
+	switch requestType {
+	case libsveltosv1alpha1.SveltosAgentRequest:
+		rules = sveltosAgentPolicyRules
+	case libsveltosv1alpha1.DriftDetectionRequest:
+		rules = driftDetectionPolicyRules
+	case libsveltosv1alpha1.GenericRequest:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown AccessRequest type %q", requestType)
+	}
+
+	result := make([]rbacv1.PolicyRule, len(rules))
+	for i := range rules {
+		rules[i].DeepCopyInto(&result[i])
+	}
+	return result, nil
+}
+
+// validateAccessRequest verifies accessRequest type is known and all fields required
+// by such type are set
+func validateAccessRequest(accessRequest *libsveltosv1alpha1.AccessRequest) error {
+	if accessRequest.Spec.Namespace == "" || accessRequest.Spec.Name == "" {
+		return fmt.Errorf("AccessRequest service account namespace and name cannot be empty")
+	}
+
+	switch accessRequest.Spec.Type {
+	case libsveltosv1alpha1.SveltosAgentRequest, libsveltosv1alpha1.DriftDetectionRequest:
+		return nil
+	case libsveltosv1alpha1.GenericRequest:
+		if accessRequest.Spec.ClusterRoleRef == nil || accessRequest.Spec.ClusterRoleRef.Name == "" {
+			return fmt.Errorf("clusterRoleRef is required for AccessRequest type %q", accessRequest.Spec.Type)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown AccessRequest type %q", accessRequest.Spec.Type)
+	}
+}
@@ -0,0 +1,303 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accessrequest
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+const (
+	// accessRequestNamespaceLabel is added, along with AccessRequestNameLabel, to each
+	// ServiceAccount and RBAC resource generated for an AccessRequest.
+	// Those resources are either cluster wide or in a different namespace than the
+	// AccessRequest, so they cannot be owned by it.
+	accessRequestNamespaceLabel = "projectsveltos.io/access-request-namespace"
+
+	rbacNamePrefix = "sveltos-accessrequest-"
+)
+
+// GetServiceAccount returns the ServiceAccount generated for accessRequest
+func GetServiceAccount(accessRequest *libsveltosv1alpha1.AccessRequest) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: accessRequest.Spec.Namespace,
+			Name:      accessRequest.Spec.Name,
+			Labels:    getLabels(accessRequest),
+		},
+	}
+}
+
+// GetClusterRole returns the ClusterRole generated for accessRequest, with the permission set
+// of accessRequest type.
+// Returns nil for GenericRequest, which uses the referenced ClusterRole.
+func GetClusterRole(accessRequest *libsveltosv1alpha1.AccessRequest) (*rbacv1.ClusterRole, error) {
+	if err := validateAccessRequest(accessRequest); err != nil {
+		return nil, err
+	}
+
+	if accessRequest.Spec.Type == libsveltosv1alpha1.GenericRequest {
+		return nil, nil
+	}
+
+	rules, err := GetPolicyRules(accessRequest.Spec.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   getRBACName(accessRequest),
+			Labels: getLabels(accessRequest),
+		},
+		Rules: rules,
+	}, nil
+}
+
+// GetClusterRoleBinding returns the ClusterRoleBinding, generated for accessRequest, granting
+// the ClusterRole (generated or, for GenericRequest, referenced) to the AccessRequest ServiceAccount
+func GetClusterRoleBinding(accessRequest *libsveltosv1alpha1.AccessRequest) (*rbacv1.ClusterRoleBinding, error) {
+	if err := validateAccessRequest(accessRequest); err != nil {
+		return nil, err
+	}
+
+	clusterRoleName := getRBACName(accessRequest)
+	if accessRequest.Spec.Type == libsveltosv1alpha1.GenericRequest {
+		clusterRoleName = accessRequest.Spec.ClusterRoleRef.Name
+	}
+
+	return &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   getRBACName(accessRequest),
+			Labels: getLabels(accessRequest),
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     clusterRoleName,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Namespace: accessRequest.Spec.Namespace,
+				Name:      accessRequest.Spec.Name,
+			},
+		},
+	}, nil
+}
+
+// DeployServiceAccountAndRBAC creates (or updates) in the management cluster the ServiceAccount,
+// ClusterRole and ClusterRoleBinding generated for accessRequest.
+// Existing resources not generated for accessRequest (missing AccessRequest labels) are never
+// adopted, an error is returned instead.
+func DeployServiceAccountAndRBAC(ctx context.Context, c client.Client,
+	accessRequest *libsveltosv1alpha1.AccessRequest, logger logr.Logger) error {
+
+	clusterRole, err := GetClusterRole(accessRequest)
+	if err != nil {
+		return err
+	}
+
+	clusterRoleBinding, err := GetClusterRoleBinding(accessRequest)
+	if err != nil {
+		return err
+	}
+
+	logger = logger.WithValues("accessrequest", fmt.Sprintf("%s/%s", accessRequest.Namespace, accessRequest.Name))
+
+	serviceAccount := GetServiceAccount(accessRequest)
+	_, err = controllerutil.CreateOrUpdate(ctx, c, serviceAccount, func() error {
+		if err := verifyNotAdopted(serviceAccount, accessRequest); err != nil {
+			return err
+		}
+		mergeLabels(serviceAccount, getLabels(accessRequest))
+		return nil
+	})
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to deploy ServiceAccount: %v", err))
+		return err
+	}
+
+	if clusterRole != nil {
+		currentClusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: clusterRole.Name}}
+		_, err = controllerutil.CreateOrUpdate(ctx, c, currentClusterRole, func() error {
+			if err := verifyNotAdopted(currentClusterRole, accessRequest); err != nil {
+				return err
+			}
+			mergeLabels(currentClusterRole, clusterRole.Labels)
+			currentClusterRole.Rules = clusterRole.Rules
+			return nil
+		})
+		if err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to deploy ClusterRole: %v", err))
+			return err
+		}
+	}
+
+	if err := deployClusterRoleBinding(ctx, c, accessRequest, clusterRoleBinding, logger); err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to deploy ClusterRoleBinding: %v", err))
+		return err
+	}
+
+	return nil
+}
+
+// deployClusterRoleBinding creates or updates clusterRoleBinding. Since RoleRef is immutable,
+// ClusterRoleBinding is recreated if RoleRef changed.
+func deployClusterRoleBinding(ctx context.Context, c client.Client, accessRequest *libsveltosv1alpha1.AccessRequest,
+	clusterRoleBinding *rbacv1.ClusterRoleBinding, logger logr.Logger) error {
+
+	currentClusterRoleBinding := &rbacv1.ClusterRoleBinding{}
+	err := c.Get(ctx, client.ObjectKeyFromObject(clusterRoleBinding), currentClusterRoleBinding)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return c.Create(ctx, clusterRoleBinding)
+		}
+		return err
+	}
+
+	if err := verifyNotAdopted(currentClusterRoleBinding, accessRequest); err != nil {
+		return err
+	}
+
+	if currentClusterRoleBinding.RoleRef != clusterRoleBinding.RoleRef {
+		logger.V(logs.LogDebug).Info("referenced ClusterRole changed. Recreating ClusterRoleBinding")
+		if err := c.Delete(ctx, currentClusterRoleBinding); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		return c.Create(ctx, clusterRoleBinding)
+	}
+
+	mergeLabels(currentClusterRoleBinding, clusterRoleBinding.Labels)
+	currentClusterRoleBinding.Subjects = clusterRoleBinding.Subjects
+	return c.Update(ctx, currentClusterRoleBinding)
+}
+
+// RemoveServiceAccountAndRBAC deletes the ServiceAccount, ClusterRole and ClusterRoleBinding
+// generated for accessRequest, along with any pending CertificateSigningRequest.
+// Resources not generated for accessRequest (for instance the ClusterRole referenced by
+// a GenericRequest) are never deleted.
+func RemoveServiceAccountAndRBAC(ctx context.Context, c client.Client,
+	accessRequest *libsveltosv1alpha1.AccessRequest, logger logr.Logger) error {
+
+	objects := []client.Object{
+		&certificatesv1.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: getCertificateSigningRequestName(accessRequest)},
+		},
+		&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: getRBACName(accessRequest)}},
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: getRBACName(accessRequest)}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Namespace: accessRequest.Spec.Namespace, Name: accessRequest.Spec.Name}},
+	}
+
+	for i := range objects {
+		if err := removeObject(ctx, c, accessRequest, objects[i]); err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to remove %T %s: %v",
+				objects[i], objects[i].GetName(), err))
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeObject deletes object if it exists and it was generated for accessRequest
+func removeObject(ctx context.Context, c client.Client, accessRequest *libsveltosv1alpha1.AccessRequest,
+	object client.Object) error {
+
+	if err := c.Get(ctx, client.ObjectKeyFromObject(object), object); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !isGeneratedFor(object, accessRequest) {
+		return nil
+	}
+
+	if err := c.Delete(ctx, object); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// getRBACName returns the name of the ClusterRole and ClusterRoleBinding generated for accessRequest.
+// If name would be longer than the maximum allowed, AccessRequest namespace and name are hashed.
+func getRBACName(accessRequest *libsveltosv1alpha1.AccessRequest) string {
+	name := fmt.Sprintf("%s%s--%s", rbacNamePrefix, accessRequest.Namespace, accessRequest.Name)
+	if len(name) <= validation.DNS1123SubdomainMaxLength {
+		return name
+	}
+
+	hash := sha256.Sum256([]byte(accessRequest.Namespace + "/" + accessRequest.Name))
+	return fmt.Sprintf("%s%x", rbacNamePrefix, hash)
+}
+
+func getLabels(accessRequest *libsveltosv1alpha1.AccessRequest) map[string]string {
+	return map[string]string{
+		libsveltosv1alpha1.AccessRequestNameLabel: accessRequest.Name,
+		accessRequestNamespaceLabel:               accessRequest.Namespace,
+	}
+}
+
+func isGeneratedFor(object client.Object, accessRequest *libsveltosv1alpha1.AccessRequest) bool {
+	labels := object.GetLabels()
+	return labels != nil &&
+		labels[libsveltosv1alpha1.AccessRequestNameLabel] == accessRequest.Name &&
+		labels[accessRequestNamespaceLabel] == accessRequest.Namespace
+}
+
+// verifyNotAdopted returns an error if object already exists (was fetched from the API server)
+// but it was not generated for accessRequest
+func verifyNotAdopted(object client.Object, accessRequest *libsveltosv1alpha1.AccessRequest) error {
+	if object.GetResourceVersion() == "" || isGeneratedFor(object, accessRequest) {
+		return nil
+	}
+
+	name := object.GetName()
+	if object.GetNamespace() != "" {
+		name = object.GetNamespace() + "/" + name
+	}
+	return fmt.Errorf("%T %s already exists and was not generated for AccessRequest %s/%s",
+		object, name, accessRequest.Namespace, accessRequest.Name)
+}
+
+func mergeLabels(object client.Object, labels map[string]string) {
+	current := object.GetLabels()
+	if current == nil {
+		current = map[string]string{}
+	}
+	for k := range labels {
+		current[k] = labels[k]
+	}
+	object.SetLabels(current)
+}
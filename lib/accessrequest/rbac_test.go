@@ -0,0 +1,274 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accessrequest_test
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/accessrequest"
+)
+
+func getAccessRequest(requestType libsveltosv1alpha1.RequestType) *libsveltosv1alpha1.AccessRequest {
+	return &libsveltosv1alpha1.AccessRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: randomString(),
+			Name:      randomString(),
+		},
+		Spec: libsveltosv1alpha1.AccessRequestSpec{
+			Namespace: randomString(),
+			Name:      randomString(),
+			Type:      requestType,
+		},
+	}
+}
+
+var _ = Describe("AccessRequest RBAC", func() {
+	It("GetPolicyRules returns permission set for each AccessRequest type", func() {
+		rules, err := accessrequest.GetPolicyRules(libsveltosv1alpha1.SveltosAgentRequest)
+		Expect(err).To(BeNil())
+		Expect(rules).ToNot(BeEmpty())
+
+		rules, err = accessrequest.GetPolicyRules(libsveltosv1alpha1.DriftDetectionRequest)
+		Expect(err).To(BeNil())
+		Expect(rules).ToNot(BeEmpty())
+		for i := range rules {
+			Expect(rules[i].APIGroups).To(ConsistOf(libsveltosv1alpha1.GroupVersion.Group))
+			Expect(rules[i].Resources).ToNot(ContainElement("*"))
+			Expect(rules[i].Verbs).ToNot(ContainElement("*"))
+		}
+
+		// Returned rules are a copy
+		rules[0].Verbs[0] = "*"
+		rules, err = accessrequest.GetPolicyRules(libsveltosv1alpha1.DriftDetectionRequest)
+		Expect(err).To(BeNil())
+		Expect(rules[0].Verbs).ToNot(ContainElement("*"))
+
+		rules, err = accessrequest.GetPolicyRules(libsveltosv1alpha1.GenericRequest)
+		Expect(err).To(BeNil())
+		Expect(rules).To(BeNil())
+
+		_, err = accessrequest.GetPolicyRules(libsveltosv1alpha1.RequestType(randomString()))
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("GetClusterRoleBinding binds ServiceAccount to generated or referenced ClusterRole", func() {
+		accessRequest := getAccessRequest(libsveltosv1alpha1.DriftDetectionRequest)
+
+		clusterRole, err := accessrequest.GetClusterRole(accessRequest)
+		Expect(err).To(BeNil())
+		Expect(clusterRole).ToNot(BeNil())
+
+		clusterRoleBinding, err := accessrequest.GetClusterRoleBinding(accessRequest)
+		Expect(err).To(BeNil())
+		Expect(clusterRoleBinding.RoleRef.Name).To(Equal(clusterRole.Name))
+		Expect(clusterRoleBinding.Subjects).To(ConsistOf(rbacv1.Subject{
+			Kind:      rbacv1.ServiceAccountKind,
+			Namespace: accessRequest.Spec.Namespace,
+			Name:      accessRequest.Spec.Name,
+		}))
+
+		accessRequest = getAccessRequest(libsveltosv1alpha1.GenericRequest)
+		_, err = accessrequest.GetClusterRoleBinding(accessRequest)
+		Expect(err).ToNot(BeNil())
+
+		accessRequest.Spec.ClusterRoleRef = &corev1.LocalObjectReference{Name: randomString()}
+		clusterRole, err = accessrequest.GetClusterRole(accessRequest)
+		Expect(err).To(BeNil())
+		Expect(clusterRole).To(BeNil())
+
+		clusterRoleBinding, err = accessrequest.GetClusterRoleBinding(accessRequest)
+		Expect(err).To(BeNil())
+		Expect(clusterRoleBinding.RoleRef.Name).To(Equal(accessRequest.Spec.ClusterRoleRef.Name))
+	})
+
+	It("GenerateKubeconfig requires clusterRoleRef for Generic AccessRequest", func() {
+		accessRequest := getAccessRequest(libsveltosv1alpha1.GenericRequest)
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		_, err := accessrequest.GenerateKubeconfig(context.TODO(), c, accessRequest, klogr.New())
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("clusterRoleRef"))
+	})
+
+	It("DeployServiceAccountAndRBAC and RemoveServiceAccountAndRBAC manage generated resources", func() {
+		accessRequest := getAccessRequest(libsveltosv1alpha1.GenericRequest)
+		accessRequest.Spec.ClusterRoleRef = &corev1.LocalObjectReference{Name: randomString()}
+
+		referencedClusterRole := &rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: accessRequest.Spec.ClusterRoleRef.Name},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(referencedClusterRole).Build()
+
+		Expect(accessrequest.DeployServiceAccountAndRBAC(context.TODO(), c, accessRequest, klogr.New())).To(Succeed())
+
+		serviceAccount := &corev1.ServiceAccount{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: accessRequest.Spec.Namespace,
+			Name: accessRequest.Spec.Name}, serviceAccount)).To(Succeed())
+
+		clusterRoleBindings := &rbacv1.ClusterRoleBindingList{}
+		Expect(c.List(context.TODO(), clusterRoleBindings,
+			client.MatchingLabels{libsveltosv1alpha1.AccessRequestNameLabel: accessRequest.Name})).To(Succeed())
+		Expect(clusterRoleBindings.Items).To(HaveLen(1))
+		Expect(clusterRoleBindings.Items[0].RoleRef.Name).To(Equal(referencedClusterRole.Name))
+
+		// Change referenced ClusterRole. ClusterRoleBinding is recreated
+		accessRequest.Spec.ClusterRoleRef.Name = randomString()
+		Expect(accessrequest.DeployServiceAccountAndRBAC(context.TODO(), c, accessRequest, klogr.New())).To(Succeed())
+		Expect(c.List(context.TODO(), clusterRoleBindings,
+			client.MatchingLabels{libsveltosv1alpha1.AccessRequestNameLabel: accessRequest.Name})).To(Succeed())
+		Expect(clusterRoleBindings.Items).To(HaveLen(1))
+		Expect(clusterRoleBindings.Items[0].RoleRef.Name).To(Equal(accessRequest.Spec.ClusterRoleRef.Name))
+
+		Expect(accessrequest.RemoveServiceAccountAndRBAC(context.TODO(), c, accessRequest, klogr.New())).To(Succeed())
+
+		err := c.Get(context.TODO(), types.NamespacedName{Namespace: accessRequest.Spec.Namespace,
+			Name: accessRequest.Spec.Name}, serviceAccount)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(c.List(context.TODO(), clusterRoleBindings,
+			client.MatchingLabels{libsveltosv1alpha1.AccessRequestNameLabel: accessRequest.Name})).To(Succeed())
+		Expect(clusterRoleBindings.Items).To(BeEmpty())
+
+		// Referenced ClusterRole is not removed
+		Expect(c.Get(context.TODO(), types.NamespacedName{Name: referencedClusterRole.Name},
+			&rbacv1.ClusterRole{})).To(Succeed())
+
+		// Removing again is a no-op
+		Expect(accessrequest.RemoveServiceAccountAndRBAC(context.TODO(), c, accessRequest, klogr.New())).To(Succeed())
+	})
+
+	It("RemoveServiceAccountAndRBAC does not remove ServiceAccount not generated for AccessRequest", func() {
+		accessRequest := getAccessRequest(libsveltosv1alpha1.SveltosAgentRequest)
+
+		serviceAccount := &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: accessRequest.Spec.Namespace,
+				Name:      accessRequest.Spec.Name,
+			},
+		}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(serviceAccount).Build()
+
+		Expect(accessrequest.RemoveServiceAccountAndRBAC(context.TODO(), c, accessRequest, klogr.New())).To(Succeed())
+		Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(serviceAccount), serviceAccount)).To(Succeed())
+	})
+
+	It("DeployServiceAccountAndRBAC does not adopt existing resources not generated for AccessRequest", func() {
+		accessRequest := getAccessRequest(libsveltosv1alpha1.SveltosAgentRequest)
+		accessRequest.Spec.Name = "default"
+
+		serviceAccount := &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: accessRequest.Spec.Namespace,
+				Name:      accessRequest.Spec.Name,
+			},
+		}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(serviceAccount).Build()
+
+		err := accessrequest.DeployServiceAccountAndRBAC(context.TODO(), c, accessRequest, klogr.New())
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("was not generated for AccessRequest"))
+
+		Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(serviceAccount), serviceAccount)).To(Succeed())
+		Expect(serviceAccount.Labels).To(BeEmpty())
+
+		Expect(accessrequest.RemoveServiceAccountAndRBAC(context.TODO(), c, accessRequest, klogr.New())).To(Succeed())
+		Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(serviceAccount), serviceAccount)).To(Succeed())
+
+		// Same for ClusterRole
+		accessRequest = getAccessRequest(libsveltosv1alpha1.SveltosAgentRequest)
+		clusterRole, err := accessrequest.GetClusterRole(accessRequest)
+		Expect(err).To(BeNil())
+		clusterRole.Labels = nil
+		Expect(c.Create(context.TODO(), clusterRole)).To(Succeed())
+
+		err = accessrequest.DeployServiceAccountAndRBAC(context.TODO(), c, accessRequest, klogr.New())
+		Expect(err).ToNot(BeNil())
+		Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(clusterRole), clusterRole)).To(Succeed())
+		Expect(clusterRole.Labels).To(BeEmpty())
+	})
+
+	It("GetClusterRole and GetClusterRoleBinding names do not exceed maximum length", func() {
+		accessRequest := getAccessRequest(libsveltosv1alpha1.SveltosAgentRequest)
+		accessRequest.Namespace = strings.Repeat("n", validation.DNS1123LabelMaxLength)
+		accessRequest.Name = strings.Repeat("a", validation.DNS1123SubdomainMaxLength)
+
+		clusterRole, err := accessrequest.GetClusterRole(accessRequest)
+		Expect(err).To(BeNil())
+		Expect(len(clusterRole.Name)).To(BeNumerically("<=", validation.DNS1123SubdomainMaxLength))
+
+		clusterRoleBinding, err := accessrequest.GetClusterRoleBinding(accessRequest)
+		Expect(err).To(BeNil())
+		Expect(clusterRoleBinding.Name).To(Equal(clusterRole.Name))
+
+		// Different AccessRequests get different names
+		other := accessRequest.DeepCopy()
+		other.Name = strings.Repeat("b", validation.DNS1123SubdomainMaxLength)
+		otherClusterRole, err := accessrequest.GetClusterRole(other)
+		Expect(err).To(BeNil())
+		Expect(otherClusterRole.Name).ToNot(Equal(clusterRole.Name))
+	})
+
+	It("DeployServiceAccountAndRBAC grants least-privilege permissions", func() {
+		accessRequest := getAccessRequest(libsveltosv1alpha1.DriftDetectionRequest)
+
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: accessRequest.Spec.Namespace}}
+		Expect(testEnv.Create(context.TODO(), ns)).To(Succeed())
+
+		c, err := client.New(testEnv.Config, client.Options{Scheme: scheme})
+		Expect(err).To(BeNil())
+		Expect(accessrequest.DeployServiceAccountAndRBAC(context.TODO(), c, accessRequest, klogr.New())).To(Succeed())
+
+		isAllowed := func(group, resource, verb string) bool {
+			review := &authorizationv1.SubjectAccessReview{
+				Spec: authorizationv1.SubjectAccessReviewSpec{
+					User: accessrequest.GetUserName(accessRequest),
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Namespace: randomString(),
+						Group:     group,
+						Resource:  resource,
+						Verb:      verb,
+					},
+				},
+			}
+			Expect(c.Create(context.TODO(), review)).To(Succeed())
+			return review.Status.Allowed
+		}
+
+		Expect(isAllowed(libsveltosv1alpha1.GroupVersion.Group, "resourcesummaries", "update")).To(BeTrue())
+		Expect(isAllowed(libsveltosv1alpha1.GroupVersion.Group, "resourcesummaries", "delete")).To(BeFalse())
+		Expect(isAllowed(libsveltosv1alpha1.GroupVersion.Group, "classifierreports", "create")).To(BeFalse())
+		Expect(isAllowed("", "secrets", "get")).To(BeFalse())
+
+		Expect(accessrequest.RemoveServiceAccountAndRBAC(context.TODO(), c, accessRequest, klogr.New())).To(Succeed())
+	})
+})
@@ -0,0 +1,232 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/go-logr/logr"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/projectsveltos/libsveltos/lib/crd"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+const (
+	capiClusterCRDName = "clusters.cluster.x-k8s.io"
+)
+
+// CAPIPresenceHook is invoked every time ClusterAPI is installed (present is true)
+// or uninstalled (present is false)
+type CAPIPresenceHook func(present bool)
+
+// CAPIDetector detects whether ClusterAPI is installed.
+// Until Start is called, ClusterAPI presence is verified (ClusterAPI Cluster CRD exists)
+// on first check only and the result is cached. Once started, CAPIDetector watches CustomResourceDefinitions and keeps track of
+// ClusterAPI presence, invoking registered hooks whenever it changes.
+type CAPIDetector struct {
+	crdName string
+	group   string
+	kind    string
+
+	mu *sync.RWMutex
+
+	// watching is true while CustomResourceDefinitions are being watched.
+	// checked is true once present has been verified at least once.
+	// Present is valid if either is true.
+	watching bool
+	checked  bool
+	present  bool
+
+	// c is the client used, once started, to verify CRD presence
+	c client.Client
+
+	hooks []CAPIPresenceHook
+
+	log logr.Logger
+}
+
+var (
+	// capiDetector is the CAPIDetector used by clusterproxy
+	capiDetector = newCRDDetector(capiClusterCRDName, clusterv1.GroupVersion.Group, "Cluster")
+)
+
+// GetCAPIDetector returns the CAPIDetector used by clusterproxy to decide
+// whether ClusterAPI Clusters need to be considered.
+func GetCAPIDetector() *CAPIDetector {
+	return capiDetector
+}
+
+func newCRDDetector(crdName, group, kind string) *CAPIDetector {
+	return &CAPIDetector{
+		crdName: crdName,
+		group:   group,
+		kind:    kind,
+		mu:      &sync.RWMutex{},
+		log:     logr.Discard(),
+	}
+}
+
+// AddPresenceHook registers a hook invoked every time ClusterAPI is installed or uninstalled.
+// Hooks are only invoked once CAPIDetector is started.
+func (d *CAPIDetector) AddPresenceHook(hook CAPIPresenceHook) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.hooks = append(d.hooks, hook)
+}
+
+// Start starts watching CustomResourceDefinitions. Caller must have RBAC to
+// get, list and watch CustomResourceDefinitions. Returns an error if watcher
+// cannot be started.
+// Watcher stops when ctx is canceled. CAPIDetector then goes back to using the
+// last known ClusterAPI presence.
+func (d *CAPIDetector) Start(ctx context.Context, config *rest.Config, logger logr.Logger) error {
+	s := runtime.NewScheme()
+	if err := apiextensionsv1.AddToScheme(s); err != nil {
+		return err
+	}
+
+	// Uncached client so that CRD deletion is immediately visible
+	c, err := client.New(config, client.Options{Scheme: s})
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.watching {
+		return fmt.Errorf("CAPIDetector already started")
+	}
+
+	present, err := isCRDPresent(ctx, c, d.crdName, logger)
+	if err != nil {
+		return err
+	}
+
+	d.c = c
+	d.log = logger
+	d.checked = true
+	d.present = present
+
+	watcherCtx, cancel := context.WithCancel(ctx)
+	// onCRDChange acquires the lock. Release it while watcher cache syncs.
+	d.watching = true
+	d.mu.Unlock()
+	err = crd.StartCustomResourceDefinitionWatcher(watcherCtx, config, d.onCRDChange, logger)
+	d.mu.Lock()
+	if err != nil {
+		cancel()
+		d.watching = false
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to watch CustomResourceDefinitions: %v", err))
+		return err
+	}
+
+	go func() {
+		defer cancel()
+		<-watcherCtx.Done()
+
+		d.mu.Lock()
+		d.watching = false
+		d.mu.Unlock()
+	}()
+
+	return nil
+}
+
+// IsPresent returns whether ClusterAPI is installed.
+// If CAPIDetector is not started and ClusterAPI presence was never verified, c is used
+// to verify ClusterAPI Cluster CRD exists. Result is cached.
+func (d *CAPIDetector) IsPresent(ctx context.Context, c client.Client, logger logr.Logger) (bool, error) {
+	d.mu.RLock()
+	valid, present := d.watching || d.checked, d.present
+	d.mu.RUnlock()
+
+	if valid {
+		return present, nil
+	}
+
+	present, err := isCRDPresent(ctx, c, d.crdName, logger)
+	if err != nil {
+		return false, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.watching {
+		d.checked = true
+		d.present = present
+	}
+	return d.present, nil
+}
+
+// onCRDChange is invoked every time a CustomResourceDefinition is created, updated or deleted
+func (d *CAPIDetector) onCRDChange(gvk *schema.GroupVersionKind) {
+	if gvk.Group != d.group || gvk.Kind != d.kind {
+		return
+	}
+
+	d.mu.RLock()
+	c, logger := d.c, d.log
+	d.mu.RUnlock()
+
+	// Handler is invoked on creation, update and deletion. Fetch CRD to know which one.
+	present, err := isCRDPresent(context.TODO(), c, d.crdName, logger)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to verify if %s is present: %v", d.crdName, err))
+		return
+	}
+
+	d.mu.Lock()
+	changed := d.watching && d.present != present
+	d.present = present
+	hooks := d.hooks
+	d.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	logger.V(logs.LogInfo).Info(fmt.Sprintf("ClusterAPI present: %t", present))
+	for _, hook := range hooks {
+		hook(present)
+	}
+}
+
+func isCRDPresent(ctx context.Context, c client.Client, crdName string, logger logr.Logger) (bool, error) {
+	currentCRD := &apiextensionsv1.CustomResourceDefinition{}
+	err := c.Get(ctx, types.NamespacedName{Name: crdName}, currentCRD)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.V(logs.LogDebug).Info(fmt.Sprintf("%s CRD not present", crdName))
+			return false, nil
+		}
+		return false, err
+	}
+
+	return currentCRD.DeletionTimestamp.IsZero(), nil
+}
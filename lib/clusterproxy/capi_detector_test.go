@@ -0,0 +1,181 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/projectsveltos/libsveltos/internal/test/helpers/external"
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+)
+
+var _ = Describe("CAPIDetector", func() {
+	It("IsPresent verifies ClusterAPI presence once and caches result when not started", func() {
+		clusterCRD := external.TestClusterCRD.DeepCopy()
+		detector := clusterproxy.NewCRDDetector(clusterCRD.Name, clusterCRD.Spec.Group, clusterCRD.Spec.Names.Kind)
+
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		present, err := detector.IsPresent(context.TODO(), c, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(present).To(BeFalse())
+
+		Expect(c.Create(context.TODO(), clusterCRD)).To(Succeed())
+
+		// Negative result is cached
+		present, err = detector.IsPresent(context.TODO(), c, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(present).To(BeFalse())
+
+		detector = clusterproxy.NewCRDDetector(clusterCRD.Name, clusterCRD.Spec.Group, clusterCRD.Spec.Names.Kind)
+		present, err = detector.IsPresent(context.TODO(), c, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(present).To(BeTrue())
+
+		// Positive result is cached
+		Expect(c.Delete(context.TODO(), clusterCRD)).To(Succeed())
+		present, err = detector.IsPresent(context.TODO(), c, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(present).To(BeTrue())
+	})
+
+	It("Start returns an error when CustomResourceDefinitions cannot be watched", func() {
+		// User can get but not list/watch CustomResourceDefinitions
+		userName := "capi-detector-" + randomString()
+		clusterRole := &rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: userName},
+			Rules: []rbacv1.PolicyRule{
+				{
+					APIGroups: []string{apiextensionsv1.GroupName},
+					Resources: []string{"customresourcedefinitions"},
+					Verbs:     []string{"get"},
+				},
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), clusterRole)).To(Succeed())
+		clusterRoleBinding := &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: userName},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: userName},
+			Subjects:   []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: userName}},
+		}
+		Expect(testEnv.Create(context.TODO(), clusterRoleBinding)).To(Succeed())
+
+		config := rest.CopyConfig(testEnv.Config)
+		config.Impersonate = rest.ImpersonationConfig{UserName: userName}
+
+		detectorCtx, detectorCancel := context.WithTimeout(context.TODO(), 10*time.Second)
+		defer detectorCancel()
+
+		clusterCRD := external.TestClusterCRD.DeepCopy()
+		detector := clusterproxy.NewCRDDetector(clusterCRD.Name, clusterCRD.Spec.Group, clusterCRD.Spec.Names.Kind)
+		Eventually(func() error {
+			// Eventual loop as RBAC might take a moment to be effective. Only the watcher must fail.
+			c, err := client.New(config, client.Options{Scheme: scheme})
+			if err != nil {
+				return err
+			}
+			_, err = detector.IsPresent(context.TODO(), c, klogr.New())
+			return err
+		}, time.Minute, time.Second).Should(BeNil())
+
+		Expect(detector.Start(detectorCtx, config, klogr.New())).ToNot(Succeed())
+	})
+
+	It("Start watches CustomResourceDefinitions and invokes hooks on changes", func() {
+		group := strings.ToLower(randomString()) + ".example.com"
+		widgetCRD := &apiextensionsv1.CustomResourceDefinition{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "widgets." + group,
+			},
+			Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+				Group: group,
+				Scope: apiextensionsv1.NamespaceScoped,
+				Names: apiextensionsv1.CustomResourceDefinitionNames{
+					Plural: "widgets", Singular: "widget", Kind: "Widget", ListKind: "WidgetList",
+				},
+				Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+					{
+						Name: "v1", Served: true, Storage: true,
+						Schema: &apiextensionsv1.CustomResourceValidation{
+							OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{Type: "object"},
+						},
+					},
+				},
+			},
+		}
+
+		detectorCtx, detectorCancel := context.WithCancel(context.TODO())
+		defer detectorCancel()
+
+		mu := &sync.Mutex{}
+		notifications := make([]bool, 0)
+
+		detector := clusterproxy.NewCRDDetector(widgetCRD.Name, group, "Widget")
+		detector.AddPresenceHook(func(present bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			notifications = append(notifications, present)
+		})
+		Expect(detector.Start(detectorCtx, testEnv.Config, klogr.New())).To(Succeed())
+		Expect(detector.Start(detectorCtx, testEnv.Config, klogr.New())).ToNot(Succeed())
+
+		// Once started, client passed to IsPresent is not used
+		var c client.Client
+		present, err := detector.IsPresent(context.TODO(), c, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(present).To(BeFalse())
+
+		Expect(testEnv.Create(context.TODO(), widgetCRD)).To(Succeed())
+
+		Eventually(func() bool {
+			present, err := detector.IsPresent(context.TODO(), c, klogr.New())
+			return err == nil && present
+		}, time.Minute, time.Second).Should(BeTrue())
+
+		Expect(testEnv.Delete(context.TODO(), widgetCRD)).To(Succeed())
+		Eventually(func() bool {
+			err := testEnv.Get(context.TODO(), types.NamespacedName{Name: widgetCRD.Name},
+				&apiextensionsv1.CustomResourceDefinition{})
+			return apierrors.IsNotFound(err)
+		}, time.Minute, time.Second).Should(BeTrue())
+
+		Eventually(func() bool {
+			present, err := detector.IsPresent(context.TODO(), c, klogr.New())
+			return err == nil && !present
+		}, time.Minute, time.Second).Should(BeTrue())
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(notifications).To(Equal([]bool{true, false}))
+	})
+})
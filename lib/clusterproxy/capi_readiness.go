@@ -0,0 +1,204 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CAPIReadinessPolicy defines when a ClusterAPI Cluster is considered ready to be configured.
+// A Cluster is never considered ready before its control plane is initialized. The zero
+// value requires nothing more.
+type CAPIReadinessPolicy struct {
+	// MinReadyControlPlaneMachines is the minimum number of control plane Machines
+	// in Running phase
+	MinReadyControlPlaneMachines int
+
+	// MinReadyWorkerMachines is the minimum number of worker Machines in Running phase
+	MinReadyWorkerMachines int
+
+	// RequireInfrastructureReady requires Cluster Status.InfrastructureReady to be true
+	RequireInfrastructureReady bool
+
+	// RejectFailedMachines requires no Machine of the Cluster to be failed
+	RejectFailedMachines bool
+}
+
+// ClusterNotReadyReason explains why a cluster is not ready to be configured
+type ClusterNotReadyReason string
+
+const (
+	// ClusterNotReadyControlPlaneNotInitialized indicates control plane is not initialized yet
+	ClusterNotReadyControlPlaneNotInitialized = ClusterNotReadyReason("ControlPlaneNotInitialized")
+
+	// ClusterNotReadyInfrastructureNotReady indicates cluster infrastructure is not ready yet
+	ClusterNotReadyInfrastructureNotReady = ClusterNotReadyReason("InfrastructureNotReady")
+
+	// ClusterNotReadyNotEnoughControlPlaneMachines indicates not enough control plane
+	// Machines are running
+	ClusterNotReadyNotEnoughControlPlaneMachines = ClusterNotReadyReason("NotEnoughControlPlaneMachines")
+
+	// ClusterNotReadyNotEnoughWorkerMachines indicates not enough worker Machines are running
+	ClusterNotReadyNotEnoughWorkerMachines = ClusterNotReadyReason("NotEnoughWorkerMachines")
+
+	// ClusterNotReadyFailedMachines indicates at least one Machine is failed
+	ClusterNotReadyFailedMachines = ClusterNotReadyReason("FailedMachines")
+)
+
+// ClusterReadiness is the result of a readiness evaluation
+type ClusterReadiness struct {
+	// Ready is true if cluster is ready to be configured
+	Ready bool
+
+	// Reason is set when cluster is not ready
+	Reason ClusterNotReadyReason
+
+	// Message provides details on why cluster is not ready
+	Message string
+}
+
+var (
+	capiReadinessPolicyLock = &sync.RWMutex{}
+	capiReadinessPolicy     CAPIReadinessPolicy
+)
+
+// SetCAPIReadinessPolicy sets the policy used by IsClusterReadyToBeConfigured
+// for ClusterAPI Clusters
+func SetCAPIReadinessPolicy(policy CAPIReadinessPolicy) {
+	capiReadinessPolicyLock.Lock()
+	defer capiReadinessPolicyLock.Unlock()
+
+	capiReadinessPolicy = policy
+}
+
+// GetCAPIReadinessPolicy returns the policy used by IsClusterReadyToBeConfigured
+// for ClusterAPI Clusters
+func GetCAPIReadinessPolicy() CAPIReadinessPolicy {
+	capiReadinessPolicyLock.RLock()
+	defer capiReadinessPolicyLock.RUnlock()
+
+	return capiReadinessPolicy
+}
+
+// EvaluateCAPIClusterReadiness evaluates whether ClusterAPI Cluster is ready to be
+// configured according to policy. When Cluster is not ready, returned ClusterReadiness
+// contains the reason.
+func EvaluateCAPIClusterReadiness(ctx context.Context, c client.Client,
+	cluster *corev1.ObjectReference, policy *CAPIReadinessPolicy, logger logr.Logger,
+) (*ClusterReadiness, error) {
+
+	capiCluster := &clusterv1.Cluster{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: cluster.Name}, capiCluster)
+	if err != nil {
+		logger.Info(fmt.Sprintf("Failed to get Cluster %v", err))
+		return nil, err
+	}
+
+	if !isCAPIControlPlaneInitialized(capiCluster) {
+		return notReady(ClusterNotReadyControlPlaneNotInitialized, "control plane is not initialized"), nil
+	}
+
+	if policy.RequireInfrastructureReady && !capiCluster.Status.InfrastructureReady {
+		return notReady(ClusterNotReadyInfrastructureNotReady, "infrastructure is not ready"), nil
+	}
+
+	if policy.MinReadyControlPlaneMachines == 0 && policy.MinReadyWorkerMachines == 0 &&
+		!policy.RejectFailedMachines {
+
+		return &ClusterReadiness{Ready: true}, nil
+	}
+
+	machineList, err := GetMachinesForCluster(ctx, c, cluster, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return evaluateMachines(machineList.Items, policy), nil
+}
+
+// isCAPIControlPlaneInitialized checks whether Cluster:
+// - ControlPlaneInitialized condition is set to true on Cluster object or
+// - Status.ControlPlaneReady is set to true
+func isCAPIControlPlaneInitialized(capiCluster *clusterv1.Cluster) bool {
+	for i := range capiCluster.Status.Conditions {
+		c := capiCluster.Status.Conditions[i]
+		if c.Type == clusterv1.ControlPlaneInitializedCondition &&
+			c.Status == corev1.ConditionTrue {
+
+			return true
+		}
+	}
+
+	return capiCluster.Status.ControlPlaneReady
+}
+
+func evaluateMachines(machines []clusterv1.Machine, policy *CAPIReadinessPolicy) *ClusterReadiness {
+	readyControlPlane := 0
+	readyWorkers := 0
+	failed := make([]string, 0)
+
+	for i := range machines {
+		machine := &machines[i]
+		if isMachineFailed(machine) {
+			failed = append(failed, machine.Name)
+			continue
+		}
+		if machine.Status.GetTypedPhase() != clusterv1.MachinePhaseRunning {
+			continue
+		}
+		if _, ok := machine.Labels[clusterv1.MachineControlPlaneLabel]; ok {
+			readyControlPlane++
+		} else {
+			readyWorkers++
+		}
+	}
+
+	if policy.RejectFailedMachines && len(failed) != 0 {
+		return notReady(ClusterNotReadyFailedMachines, fmt.Sprintf("failed machines: %v", failed))
+	}
+
+	if readyControlPlane < policy.MinReadyControlPlaneMachines {
+		return notReady(ClusterNotReadyNotEnoughControlPlaneMachines,
+			fmt.Sprintf("%d control plane machines running. Required: %d",
+				readyControlPlane, policy.MinReadyControlPlaneMachines))
+	}
+
+	if readyWorkers < policy.MinReadyWorkerMachines {
+		return notReady(ClusterNotReadyNotEnoughWorkerMachines,
+			fmt.Sprintf("%d worker machines running. Required: %d",
+				readyWorkers, policy.MinReadyWorkerMachines))
+	}
+
+	return &ClusterReadiness{Ready: true}
+}
+
+func isMachineFailed(machine *clusterv1.Machine) bool {
+	return machine.Status.GetTypedPhase() == clusterv1.MachinePhaseFailed ||
+		machine.Status.FailureReason != nil || machine.Status.FailureMessage != nil
+}
+
+func notReady(reason ClusterNotReadyReason, message string) *ClusterReadiness {
+	return &ClusterReadiness{Ready: false, Reason: reason, Message: message}
+}
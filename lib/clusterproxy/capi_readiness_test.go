@@ -0,0 +1,163 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+)
+
+var _ = Describe("CAPI readiness", func() {
+	var cluster *clusterv1.Cluster
+	var clusterRef *corev1.ObjectReference
+
+	BeforeEach(func() {
+		cluster = &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+			},
+			Status: clusterv1.ClusterStatus{
+				ControlPlaneReady: true,
+			},
+		}
+
+		clusterRef = &corev1.ObjectReference{Namespace: cluster.Namespace, Name: cluster.Name,
+			Kind: "Cluster", APIVersion: clusterv1.GroupVersion.String()}
+	})
+
+	AfterEach(func() {
+		clusterproxy.SetCAPIReadinessPolicy(clusterproxy.CAPIReadinessPolicy{})
+	})
+
+	getMachine := func(controlPlane bool, phase clusterv1.MachinePhase) *clusterv1.Machine {
+		machine := &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: cluster.Namespace,
+				Name:      randomString(),
+				Labels: map[string]string{
+					clusterv1.ClusterNameLabel: cluster.Name,
+				},
+			},
+		}
+		if controlPlane {
+			machine.Labels[clusterv1.MachineControlPlaneLabel] = ""
+		}
+		machine.Status.SetTypedPhase(phase)
+		return machine
+	}
+
+	It("EvaluateCAPIClusterReadiness with default policy only requires control plane to be initialized", func() {
+		notInitialized := cluster.DeepCopy()
+		notInitialized.Name = randomString()
+		notInitialized.Status.ControlPlaneReady = false
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cluster, notInitialized).Build()
+
+		readiness, err := clusterproxy.EvaluateCAPIClusterReadiness(context.TODO(), c, clusterRef,
+			&clusterproxy.CAPIReadinessPolicy{}, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(readiness.Ready).To(BeTrue())
+
+		readiness, err = clusterproxy.EvaluateCAPIClusterReadiness(context.TODO(), c,
+			&corev1.ObjectReference{Namespace: notInitialized.Namespace, Name: notInitialized.Name},
+			&clusterproxy.CAPIReadinessPolicy{}, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(readiness.Ready).To(BeFalse())
+		Expect(readiness.Reason).To(Equal(clusterproxy.ClusterNotReadyControlPlaneNotInitialized))
+	})
+
+	It("EvaluateCAPIClusterReadiness returns the reason cluster is not ready", func() {
+		failedMachine := getMachine(false, clusterv1.MachinePhaseRunning)
+		failure := errors.UpdateMachineError
+		failedMachine.Status.FailureReason = &failure
+
+		initObjects := []client.Object{
+			cluster,
+			getMachine(true, clusterv1.MachinePhaseRunning),
+			getMachine(true, clusterv1.MachinePhaseProvisioning),
+			getMachine(false, clusterv1.MachinePhaseRunning),
+			failedMachine,
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		policy := &clusterproxy.CAPIReadinessPolicy{RequireInfrastructureReady: true}
+		readiness, err := clusterproxy.EvaluateCAPIClusterReadiness(context.TODO(), c, clusterRef, policy, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(readiness.Ready).To(BeFalse())
+		Expect(readiness.Reason).To(Equal(clusterproxy.ClusterNotReadyInfrastructureNotReady))
+
+		policy = &clusterproxy.CAPIReadinessPolicy{MinReadyControlPlaneMachines: 2}
+		readiness, err = clusterproxy.EvaluateCAPIClusterReadiness(context.TODO(), c, clusterRef, policy, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(readiness.Ready).To(BeFalse())
+		Expect(readiness.Reason).To(Equal(clusterproxy.ClusterNotReadyNotEnoughControlPlaneMachines))
+
+		policy = &clusterproxy.CAPIReadinessPolicy{MinReadyControlPlaneMachines: 1, MinReadyWorkerMachines: 2}
+		readiness, err = clusterproxy.EvaluateCAPIClusterReadiness(context.TODO(), c, clusterRef, policy, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(readiness.Ready).To(BeFalse())
+		Expect(readiness.Reason).To(Equal(clusterproxy.ClusterNotReadyNotEnoughWorkerMachines))
+
+		policy = &clusterproxy.CAPIReadinessPolicy{MinReadyControlPlaneMachines: 1, MinReadyWorkerMachines: 1}
+		readiness, err = clusterproxy.EvaluateCAPIClusterReadiness(context.TODO(), c, clusterRef, policy, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(readiness.Ready).To(BeTrue())
+
+		policy.RejectFailedMachines = true
+		readiness, err = clusterproxy.EvaluateCAPIClusterReadiness(context.TODO(), c, clusterRef, policy, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(readiness.Ready).To(BeFalse())
+		Expect(readiness.Reason).To(Equal(clusterproxy.ClusterNotReadyFailedMachines))
+		Expect(readiness.Message).To(ContainSubstring(failedMachine.Name))
+	})
+
+	It("IsClusterReadyToBeConfigured uses the configured CAPIReadinessPolicy", func() {
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cluster).Build()
+
+		ready, err := clusterproxy.IsClusterReadyToBeConfigured(context.TODO(), c, clusterRef, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(ready).To(BeTrue())
+
+		// Reference to a different ClusterAPI version is served by the CAPI provider
+		otherVersionRef := clusterRef.DeepCopy()
+		otherVersionRef.APIVersion = "cluster.x-k8s.io/v1alpha4"
+		ready, err = clusterproxy.IsClusterReadyToBeConfigured(context.TODO(), c, otherVersionRef, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(ready).To(BeTrue())
+
+		clusterproxy.SetCAPIReadinessPolicy(clusterproxy.CAPIReadinessPolicy{MinReadyWorkerMachines: 1})
+		Expect(clusterproxy.GetCAPIReadinessPolicy().MinReadyWorkerMachines).To(Equal(1))
+
+		ready, err = clusterproxy.IsClusterReadyToBeConfigured(context.TODO(), c, clusterRef, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(ready).To(BeFalse())
+	})
+})
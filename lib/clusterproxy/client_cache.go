@@ -0,0 +1,445 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+	"github.com/projectsveltos/libsveltos/lib/roles"
+)
+
+// Getting a client for a managed cluster requires reading Cluster and Secret,
+// parsing the kubeconfig and building a new client (which runs discovery).
+// ClientCache keeps, per cluster and admin, rest.Config and client.Client.
+// An entry is invalidated when:
+// - the Secret containing the kubeconfig changes (WatchKubeconfigSecrets must be called);
+// - Invalidate/InvalidateAll is explicitly called;
+// - cache is full and entry is the least recently used one.
+
+var (
+	clientCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "projectsveltos",
+		Subsystem: "cluster_client_cache",
+		Name:      "hits_total",
+		Help:      "Number of times a managed cluster client was found in cache",
+	})
+	clientCacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "projectsveltos",
+		Subsystem: "cluster_client_cache",
+		Name:      "misses_total",
+		Help:      "Number of times a managed cluster client was not found in cache",
+	})
+	clientCacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "projectsveltos",
+		Subsystem: "cluster_client_cache",
+		Name:      "evictions_total",
+		Help:      "Number of managed cluster clients evicted because cache was full",
+	})
+	clientCacheInvalidations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "projectsveltos",
+		Subsystem: "cluster_client_cache",
+		Name:      "invalidations_total",
+		Help:      "Number of managed cluster clients invalidated",
+	})
+	clientCacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "projectsveltos",
+		Subsystem: "cluster_client_cache",
+		Name:      "size",
+		Help:      "Number of managed cluster clients currently cached",
+	})
+)
+
+// RegisterMetrics registers ClientCache metrics with registerer.
+// Metrics are not registered by default. Controllers wanting them exposed along with
+// controller-runtime metrics can pass sigs.k8s.io/controller-runtime/pkg/metrics.Registry.
+func RegisterMetrics(registerer prometheus.Registerer) error {
+	collectors := []prometheus.Collector{clientCacheHits, clientCacheMisses, clientCacheEvictions,
+		clientCacheInvalidations, clientCacheSize}
+	for i := range collectors {
+		if err := registerer.Register(collectors[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type clientCacheKey struct {
+	clusterNamespace string
+	clusterName      string
+	clusterType      libsveltosv1alpha1.ClusterType
+	adminNamespace   string
+	adminName        string
+}
+
+type clientCacheEntry struct {
+	key clientCacheKey
+
+	// secret is the Secret containing the kubeconfig. Empty if kubeconfig
+	// was not read from a Secret
+	secret types.NamespacedName
+	// resourceVersion is the Secret resourceVersion when entry was created
+	resourceVersion string
+
+	config *rest.Config
+	// client is created the first time it is requested
+	client client.Client
+}
+
+// ClientCache caches rest.Config and client.Client to access managed clusters.
+type ClientCache struct {
+	mu *sync.Mutex
+
+	maxSize int
+
+	// entries contains, per key, the corresponding element in lru
+	entries map[clientCacheKey]*list.Element
+
+	// lru contains *clientCacheEntry. Most recently used entry is at the front.
+	lru *list.List
+
+	// secretStore contains Secrets metadata as seen by the informer started by
+	// WatchKubeconfigSecrets. Nil if Secrets are not watched.
+	secretStore cache.Store
+}
+
+// NewClientCache returns a ClientCache holding at most maxSize entries.
+// If maxSize is zero or negative, cache has no size limit.
+func NewClientCache(maxSize int) *ClientCache {
+	return &ClientCache{
+		mu:      &sync.Mutex{},
+		maxSize: maxSize,
+		entries: make(map[clientCacheKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// GetKubernetesRestConfig returns restConfig for a cluster. Same as clusterproxy.GetKubernetesRestConfig
+// but uses cached value when available.
+func (cc *ClientCache) GetKubernetesRestConfig(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName, adminNamespace, adminName string,
+	clusterType libsveltosv1alpha1.ClusterType, logger logr.Logger) (*rest.Config, error) {
+
+	entry, err := cc.getEntry(ctx, c, clusterNamespace, clusterName, adminNamespace, adminName,
+		clusterType, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry.config, nil
+}
+
+// GetKubernetesClient returns client to access cluster. Same as clusterproxy.GetKubernetesClient
+// but uses cached value when available.
+func (cc *ClientCache) GetKubernetesClient(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName, adminNamespace, adminName string,
+	clusterType libsveltosv1alpha1.ClusterType, logger logr.Logger) (client.Client, error) {
+
+	entry, err := cc.getEntry(ctx, c, clusterNamespace, clusterName, adminNamespace, adminName,
+		clusterType, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	cc.mu.Lock()
+	remoteClient := entry.client
+	cc.mu.Unlock()
+	if remoteClient != nil {
+		return remoteClient, nil
+	}
+
+	logger.V(logs.LogVerbose).Info("return new client")
+	remoteClient, err = client.New(entry.config, client.Options{Scheme: c.Scheme()})
+	if err != nil {
+		return nil, err
+	}
+
+	cc.mu.Lock()
+	entry.client = remoteClient
+	cc.mu.Unlock()
+
+	return remoteClient, nil
+}
+
+// Invalidate removes from cache all entries for cluster (for any admin)
+func (cc *ClientCache) Invalidate(clusterNamespace, clusterName string,
+	clusterType libsveltosv1alpha1.ClusterType) {
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	for key, element := range cc.entries {
+		if key.clusterNamespace == clusterNamespace && key.clusterName == clusterName &&
+			key.clusterType == clusterType {
+
+			cc.remove(element)
+			clientCacheInvalidations.Inc()
+		}
+	}
+}
+
+// InvalidateAll removes all entries from cache
+func (cc *ClientCache) InvalidateAll() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	clientCacheInvalidations.Add(float64(len(cc.entries)))
+	cc.entries = make(map[clientCacheKey]*list.Element)
+	cc.lru.Init()
+	clientCacheSize.Set(0)
+}
+
+// Len returns the number of entries currently in cache
+func (cc *ClientCache) Len() int {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	return cc.lru.Len()
+}
+
+// WatchKubeconfigSecrets starts an informer on Secrets in the management cluster.
+// Any time a Secret containing a cached kubeconfig is modified or deleted, corresponding
+// entries are removed from cache.
+// Only Secrets metadata are watched. Informer stops when ctx is canceled.
+func (cc *ClientCache) WatchKubeconfigSecrets(ctx context.Context, config *rest.Config,
+	logger logr.Logger) error {
+
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	factory := metadatainformer.NewSharedInformerFactory(metadataClient, 0)
+	informer := factory.ForResource(corev1.SchemeGroupVersion.WithResource("secrets")).Informer()
+	_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(_, newObj interface{}) {
+			cc.onSecretChange(newObj, false, logger)
+		},
+		DeleteFunc: func(obj interface{}) {
+			cc.onSecretChange(obj, true, logger)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("failed to sync Secret informer")
+	}
+
+	cc.mu.Lock()
+	cc.secretStore = informer.GetStore()
+	cc.mu.Unlock()
+
+	return nil
+}
+
+func (cc *ClientCache) onSecretChange(obj interface{}, deleted bool, logger logr.Logger) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	secret, ok := obj.(*metav1.PartialObjectMetadata)
+	if !ok {
+		return
+	}
+
+	secretName := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	for _, element := range cc.entries {
+		entry := element.Value.(*clientCacheEntry)
+		if entry.secret != secretName {
+			continue
+		}
+		if deleted || entry.resourceVersion != secret.ResourceVersion {
+			logger.V(logs.LogDebug).Info(fmt.Sprintf("kubeconfig secret %s changed. Invalidating cache for cluster %s/%s",
+				secretName, entry.key.clusterNamespace, entry.key.clusterName))
+			cc.remove(element)
+			clientCacheInvalidations.Inc()
+		}
+	}
+}
+
+func (cc *ClientCache) getEntry(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName, adminNamespace, adminName string,
+	clusterType libsveltosv1alpha1.ClusterType, logger logr.Logger) (*clientCacheEntry, error) {
+
+	if adminName == kubernetesAdmin {
+		adminNamespace = ""
+		adminName = ""
+	}
+
+	key := clientCacheKey{
+		clusterNamespace: clusterNamespace,
+		clusterName:      clusterName,
+		clusterType:      clusterType,
+		adminNamespace:   adminNamespace,
+		adminName:        adminName,
+	}
+
+	cc.mu.Lock()
+	if element, ok := cc.entries[key]; ok {
+		cc.lru.MoveToFront(element)
+		cc.mu.Unlock()
+		clientCacheHits.Inc()
+		return element.Value.(*clientCacheEntry), nil
+	}
+	cc.mu.Unlock()
+
+	clientCacheMisses.Inc()
+
+	entry, err := buildClientCacheEntry(ctx, c, &key, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if !cc.isSecretVersionCurrent(entry) {
+		// Secret changed while entry was being built. Corresponding event might have
+		// already been processed, so entry would never be invalidated. Do not cache it.
+		logger.V(logs.LogDebug).Info(fmt.Sprintf("kubeconfig secret %s changed. Not caching client for cluster %s/%s",
+			entry.secret, clusterNamespace, clusterName))
+		return entry, nil
+	}
+
+	if element, ok := cc.entries[key]; ok {
+		// Another caller added it in the meantime
+		cc.remove(element)
+	}
+	cc.entries[key] = cc.lru.PushFront(entry)
+
+	if cc.maxSize > 0 {
+		for cc.lru.Len() > cc.maxSize {
+			cc.remove(cc.lru.Back())
+			clientCacheEvictions.Inc()
+		}
+	}
+	clientCacheSize.Set(float64(cc.lru.Len()))
+
+	return entry, nil
+}
+
+// isSecretVersionCurrent returns true if the Secret resourceVersion used to build entry
+// is the one last seen by the Secret informer. Secret events are processed with lock held
+// after informer store is updated, so any later change will invalidate the entry.
+// Always returns true if Secrets are not watched or kubeconfig was not read from a Secret.
+// Must be called with lock held.
+func (cc *ClientCache) isSecretVersionCurrent(entry *clientCacheEntry) bool {
+	if cc.secretStore == nil || entry.secret.Name == "" {
+		return true
+	}
+
+	obj, exists, err := cc.secretStore.GetByKey(entry.secret.String())
+	if err != nil || !exists {
+		return false
+	}
+
+	secret, ok := obj.(*metav1.PartialObjectMetadata)
+	if !ok {
+		return false
+	}
+
+	return secret.ResourceVersion == entry.resourceVersion
+}
+
+// remove must be called with lock held
+func (cc *ClientCache) remove(element *list.Element) {
+	entry := element.Value.(*clientCacheEntry)
+	delete(cc.entries, entry.key)
+	cc.lru.Remove(element)
+	clientCacheSize.Set(float64(cc.lru.Len()))
+}
+
+func buildClientCacheEntry(ctx context.Context, c client.Client, key *clientCacheKey,
+	logger logr.Logger) (*clientCacheEntry, error) {
+
+	var secret *corev1.Secret
+	var kubeconfig []byte
+	var kubeconfigContext string
+	var err error
+
+	switch {
+	case key.adminName != "" && GetTenantAccessMode() == TenantAccessImpersonation:
+		var source *KubeconfigSource
+		source, err = getImpersonatingKubeconfigSource(ctx, c, key.clusterNamespace, key.clusterName,
+			key.adminNamespace, key.adminName, key.clusterType, logger)
+		if err != nil {
+			return nil, err
+		}
+		secret = source.Secret
+		kubeconfig = source.Kubeconfig
+	case key.adminName != "":
+		secret, err = roles.GetSecret(ctx, c, key.clusterNamespace, key.clusterName,
+			key.adminNamespace, key.adminName, key.clusterType)
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil {
+			return nil, fmt.Errorf("kubeconfig for %s/%s in cluster %s/%s not found",
+				key.adminNamespace, key.adminName, key.clusterNamespace, key.clusterName)
+		}
+		kubeconfig = roles.GetKubeconfigFromSecret(secret)
+	default:
+		var source *KubeconfigSource
+		source, err = getKubeconfigSource(ctx, c, key.clusterNamespace, key.clusterName, key.clusterType, logger)
+		if err != nil {
+			return nil, err
+		}
+		secret = source.Secret
+		kubeconfig = source.Kubeconfig
+		kubeconfigContext = source.Context
+	}
+
+	config, err := GetRestConfigFromKubeconfig(logger, kubeconfig, kubeconfigContext)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &clientCacheEntry{
+		key:    *key,
+		config: config,
+	}
+	// A ClusterProvider might not store kubeconfig in a Secret. In such case entry
+	// cannot be invalidated when kubeconfig changes.
+	if secret != nil {
+		entry.secret = types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+		entry.resourceVersion = secret.ResourceVersion
+	}
+
+	return entry, nil
+}
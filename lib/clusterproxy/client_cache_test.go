@@ -0,0 +1,162 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+)
+
+func getSveltosClusterWithSecret(namespace string) (*libsveltosv1alpha1.SveltosCluster, *corev1.Secret) {
+	sveltosCluster := &libsveltosv1alpha1.SveltosCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      randomString(),
+		},
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      sveltosCluster.Name + clusterproxy.SveltosKubeconfigSecretNamePostfix,
+		},
+		Data: map[string][]byte{
+			"value": []byte(fmt.Sprintf(multiContextKubeconfig, randomString())),
+		},
+	}
+
+	return sveltosCluster, secret
+}
+
+var _ = Describe("ClientCache", func() {
+	It("GetKubernetesRestConfig returns cached rest.Config till Invalidate is called", func() {
+		sveltosCluster, secret := getSveltosClusterWithSecret(randomString())
+		initObjects := []client.Object{sveltosCluster, secret}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		clientCache := clusterproxy.NewClientCache(0)
+
+		config, err := clientCache.GetKubernetesRestConfig(context.TODO(), c, sveltosCluster.Namespace,
+			sveltosCluster.Name, "", "", libsveltosv1alpha1.ClusterTypeSveltos, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(config.Host).To(Equal("https://cluster-a:6443"))
+
+		cachedConfig, err := clientCache.GetKubernetesRestConfig(context.TODO(), c, sveltosCluster.Namespace,
+			sveltosCluster.Name, "", "", libsveltosv1alpha1.ClusterTypeSveltos, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(cachedConfig).To(BeIdenticalTo(config))
+		Expect(clientCache.Len()).To(Equal(1))
+
+		clientCache.Invalidate(sveltosCluster.Namespace, sveltosCluster.Name, libsveltosv1alpha1.ClusterTypeSveltos)
+		Expect(clientCache.Len()).To(Equal(0))
+
+		newConfig, err := clientCache.GetKubernetesRestConfig(context.TODO(), c, sveltosCluster.Namespace,
+			sveltosCluster.Name, "", "", libsveltosv1alpha1.ClusterTypeSveltos, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(newConfig).ToNot(BeIdenticalTo(config))
+	})
+
+	It("ClientCache evicts least recently used entry when full", func() {
+		namespace := randomString()
+		sveltosCluster1, secret1 := getSveltosClusterWithSecret(namespace)
+		sveltosCluster2, secret2 := getSveltosClusterWithSecret(namespace)
+		initObjects := []client.Object{sveltosCluster1, secret1, sveltosCluster2, secret2}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		clientCache := clusterproxy.NewClientCache(1)
+
+		config1, err := clientCache.GetKubernetesRestConfig(context.TODO(), c, namespace,
+			sveltosCluster1.Name, "", "", libsveltosv1alpha1.ClusterTypeSveltos, klogr.New())
+		Expect(err).To(BeNil())
+
+		_, err = clientCache.GetKubernetesRestConfig(context.TODO(), c, namespace,
+			sveltosCluster2.Name, "", "", libsveltosv1alpha1.ClusterTypeSveltos, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(clientCache.Len()).To(Equal(1))
+
+		// sveltosCluster1 entry was evicted
+		currentConfig1, err := clientCache.GetKubernetesRestConfig(context.TODO(), c, namespace,
+			sveltosCluster1.Name, "", "", libsveltosv1alpha1.ClusterTypeSveltos, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(currentConfig1).ToNot(BeIdenticalTo(config1))
+	})
+
+	It("WatchKubeconfigSecrets invalidates entries when kubeconfig Secret changes", func() {
+		namespace := "client-cache" + randomString()
+		ns := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: namespace,
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), ns)).To(Succeed())
+
+		sveltosCluster, secret := getSveltosClusterWithSecret(namespace)
+		Expect(testEnv.Create(context.TODO(), sveltosCluster)).To(Succeed())
+		Expect(testEnv.Create(context.TODO(), secret)).To(Succeed())
+
+		clientCache := clusterproxy.NewClientCache(0)
+		Expect(clientCache.WatchKubeconfigSecrets(ctx, testEnv.Config, klogr.New())).To(Succeed())
+
+		const timeout = 20 * time.Second
+		// Eventual loop so testEnv Cache and Secret informer are synced. Entry is
+		// cached only once Secret informer has seen same Secret version
+		Eventually(func() int {
+			_, err := clientCache.GetKubernetesRestConfig(context.TODO(), testEnv.Client, namespace,
+				sveltosCluster.Name, "", "", libsveltosv1alpha1.ClusterTypeSveltos, klogr.New())
+			if err != nil {
+				return -1
+			}
+			return clientCache.Len()
+		}, timeout, time.Second).Should(Equal(1))
+
+		currentSecret := &corev1.Secret{}
+		Expect(testEnv.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: secret.Name},
+			currentSecret)).To(Succeed())
+		currentSecret.Data["value"] = []byte(fmt.Sprintf(multiContextKubeconfig, randomString()))
+		Expect(testEnv.Update(context.TODO(), currentSecret)).To(Succeed())
+
+		Eventually(func() int {
+			return clientCache.Len()
+		}, timeout, time.Second).Should(Equal(0))
+	})
+
+	It("RegisterMetrics registers ClientCache metrics only once", func() {
+		registry := prometheus.NewRegistry()
+		Expect(clusterproxy.RegisterMetrics(registry)).To(Succeed())
+
+		err := clusterproxy.RegisterMetrics(registry)
+		Expect(err).ToNot(BeNil())
+		alreadyRegistered := prometheus.AlreadyRegisteredError{}
+		Expect(errors.As(err, &alreadyRegistered)).To(BeTrue())
+	})
+})
@@ -0,0 +1,493 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/discovery"
+	memory "k8s.io/client-go/discovery/cached"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/projectsveltos/libsveltos/lib/crd"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+// ClusterIndex keeps, via shared informers, an in-memory view of all clusters
+// served by registered ClusterProviders. Clusters are indexed by label so that
+// finding clusters matching a selector does not require listing all clusters.
+// Selectors can be registered by key. ClusterIndex then tracks, per cluster, the
+// keys of all selectors matching it and notifies registered handlers every time
+// this set changes.
+
+const (
+	// labelIndexName is the name of the informer index of clusters by label
+	labelIndexName = "labels"
+)
+
+// ClusterMatchChangeHandler is invoked when the set of selectors matching a cluster
+// changes. added contains the keys of selectors now matching the cluster, removed
+// the keys of selectors not matching the cluster anymore.
+type ClusterMatchChangeHandler func(cluster *corev1.ObjectReference, added, removed []string)
+
+// clusterMatchChange represents a change in the set of selectors matching a cluster
+type clusterMatchChange struct {
+	cluster corev1.ObjectReference
+	added   []string
+	removed []string
+}
+
+// clusterInformer is the informer watching clusters served by a ClusterProvider
+type clusterInformer struct {
+	provider ClusterProvider
+	informer cache.SharedIndexInformer
+}
+
+// ClusterIndex indexes clusters by label
+type ClusterIndex struct {
+	log logr.Logger
+
+	mu *sync.RWMutex
+
+	// startMu serializes Start and the start of informers for ClusterProviders
+	// whose resource is installed after Start
+	startMu *sync.Mutex
+
+	// started is set once Start succeeds
+	started bool
+
+	// ctx, factory and mapper are set by Start and used to start informers
+	// for ClusterProviders whose resource is installed later on
+	ctx     context.Context
+	factory metadatainformer.SharedInformerFactory
+	mapper  *restmapper.DeferredDiscoveryRESTMapper
+
+	// informers contains one informer per ClusterProvider whose clusters
+	// are watched
+	informers []clusterInformer
+
+	// selectors contains all registered selectors
+	selectors map[string]labels.Selector
+
+	// matches contains, per cluster, the keys of the selectors currently matching it
+	matches map[corev1.ObjectReference]map[string]bool
+
+	handlers []ClusterMatchChangeHandler
+}
+
+// NewClusterIndex returns a ClusterIndex. ClusterIndex needs to be started
+// before being used.
+func NewClusterIndex(logger logr.Logger) *ClusterIndex {
+	return &ClusterIndex{
+		log:       logger,
+		mu:        &sync.RWMutex{},
+		startMu:   &sync.Mutex{},
+		selectors: make(map[string]labels.Selector),
+		matches:   make(map[corev1.ObjectReference]map[string]bool),
+	}
+}
+
+// Start starts an informer for the clusters of each registered ClusterProvider and
+// waits for all informers to sync. Clusters whose resource is not installed are skipped.
+// CustomResourceDefinitions are then watched, so that clusters whose resource is installed
+// later on (for instance ClusterAPI installed after Sveltos) are indexed as well. Caller must
+// have RBAC to watch CustomResourceDefinitions.
+// If Start fails, it can be called again. Informers stop when ctx is canceled.
+func (ci *ClusterIndex) Start(ctx context.Context, config *rest.Config) error {
+	ci.startMu.Lock()
+	defer ci.startMu.Unlock()
+
+	if ci.started {
+		return fmt.Errorf("ClusterIndex already started")
+	}
+
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	indexCtx, cancel := context.WithCancel(ctx)
+	ci.ctx = indexCtx
+	ci.factory = metadatainformer.NewSharedInformerFactory(metadataClient, 0)
+	ci.mapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc))
+
+	for _, provider := range getClusterProviders() {
+		if err := ci.startInformer(provider); err != nil {
+			cancel()
+			ci.reset()
+			return err
+		}
+	}
+
+	ci.started = true
+
+	go func() {
+		defer cancel()
+		// Informer lists all existing CustomResourceDefinitions first. So resources installed
+		// while informers above were being started are not missed.
+		if err := crd.WatchCustomResourceDefinition(indexCtx, config, ci.onCRDChange, ci.log); err != nil {
+			ci.log.V(logs.LogInfo).Info(fmt.Sprintf("failed to watch CustomResourceDefinitions: %v", err))
+		}
+		// Keep already started informers running till ctx is canceled
+		<-indexCtx.Done()
+	}()
+
+	ci.mu.RLock()
+	watched := len(ci.informers)
+	ci.mu.RUnlock()
+	ci.log.V(logs.LogInfo).Info(fmt.Sprintf("started ClusterIndex watching %d cluster types", watched))
+	return nil
+}
+
+// reset removes all informers and matches. Used when Start fails.
+// Must be called with startMu held.
+func (ci *ClusterIndex) reset() {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	ci.informers = nil
+	ci.matches = make(map[corev1.ObjectReference]map[string]bool)
+}
+
+// onCRDChange is invoked every time a CustomResourceDefinition is created, updated or deleted.
+// If CustomResourceDefinition is for a ClusterProvider whose clusters are not watched yet,
+// an informer is started.
+func (ci *ClusterIndex) onCRDChange(gvk *schema.GroupVersionKind) {
+	for _, provider := range getClusterProviders() {
+		if provider.GroupVersionKind().GroupKind() != gvk.GroupKind() {
+			continue
+		}
+
+		ci.startMu.Lock()
+		if ci.started {
+			if err := ci.startInformer(provider); err != nil {
+				ci.log.V(logs.LogInfo).Info(fmt.Sprintf("failed to start informer for %s: %v",
+					provider.GroupVersionKind().String(), err))
+			}
+		}
+		ci.startMu.Unlock()
+	}
+}
+
+// startInformer starts, if not started already, an informer for the clusters of provider
+// and waits for it to sync. Nothing is done if provider resource is not installed.
+// Must be called with startMu held.
+func (ci *ClusterIndex) startInformer(provider ClusterProvider) error {
+	if ci.isWatched(provider) {
+		return nil
+	}
+
+	gvk := provider.GroupVersionKind()
+	mapping, err := ci.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil && meta.IsNoMatchError(err) {
+		// Resource might have been installed after discovery information was cached
+		ci.mapper.Reset()
+		mapping, err = ci.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			ci.log.V(logs.LogDebug).Info(fmt.Sprintf("%s not installed. Skipping it", gvk.String()))
+			return nil
+		}
+		return err
+	}
+
+	informer := ci.factory.ForResource(mapping.Resource).Informer()
+	if err := informer.AddIndexers(cache.Indexers{labelIndexName: labelIndexFunc}); err != nil {
+		return err
+	}
+
+	if _, err := informer.AddEventHandler(ci.getEventHandler(provider)); err != nil {
+		return err
+	}
+
+	ci.mu.Lock()
+	ci.informers = append(ci.informers, clusterInformer{provider: provider, informer: informer})
+	ci.mu.Unlock()
+
+	ci.factory.Start(ci.ctx.Done())
+	if !cache.WaitForCacheSync(ci.ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("failed to sync informer for %s", gvk.String())
+	}
+
+	ci.log.V(logs.LogDebug).Info(fmt.Sprintf("watching %s", gvk.String()))
+	return nil
+}
+
+func (ci *ClusterIndex) isWatched(provider ClusterProvider) bool {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+
+	for i := range ci.informers {
+		if ci.informers[i].provider.ClusterType() == provider.ClusterType() {
+			return true
+		}
+	}
+	return false
+}
+
+// AddMatchChangeHandler registers a handler invoked every time the set of
+// selectors matching a cluster changes
+func (ci *ClusterIndex) AddMatchChangeHandler(handler ClusterMatchChangeHandler) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	ci.handlers = append(ci.handlers, handler)
+}
+
+// SetSelector registers (or updates) the selector identified by key
+func (ci *ClusterIndex) SetSelector(key string, selector labels.Selector) {
+	ci.mu.Lock()
+	ci.selectors[key] = selector
+
+	changes := make([]clusterMatchChange, 0)
+	for i := range ci.informers {
+		provider := ci.informers[i].provider
+		for _, obj := range ci.informers[i].informer.GetStore().List() {
+			cluster, ok := obj.(client.Object)
+			if !ok {
+				continue
+			}
+			ref := getObjectReference(provider, cluster)
+			matching := cluster.GetDeletionTimestamp().IsZero() &&
+				selector.Matches(labels.Set(cluster.GetLabels()))
+			if change := ci.setMatch(&ref, key, matching); change != nil {
+				changes = append(changes, *change)
+			}
+		}
+	}
+	handlers := ci.handlers
+	ci.mu.Unlock()
+
+	notify(handlers, changes)
+}
+
+// RemoveSelector removes the selector identified by key
+func (ci *ClusterIndex) RemoveSelector(key string) {
+	ci.mu.Lock()
+	delete(ci.selectors, key)
+
+	changes := make([]clusterMatchChange, 0)
+	for ref := range ci.matches {
+		ref := ref
+		if change := ci.setMatch(&ref, key, false); change != nil {
+			changes = append(changes, *change)
+		}
+	}
+	handlers := ci.handlers
+	ci.mu.Unlock()
+
+	notify(handlers, changes)
+}
+
+// GetMatchingSelectors returns the sorted keys of all registered selectors
+// currently matching cluster
+func (ci *ClusterIndex) GetMatchingSelectors(cluster *corev1.ObjectReference) []string {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+
+	return sortedKeys(ci.matches[*cluster])
+}
+
+// GetMatchingClusters returns all clusters currently matching selector
+func (ci *ClusterIndex) GetMatchingClusters(selector labels.Selector) []corev1.ObjectReference {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+
+	matching := make([]corev1.ObjectReference, 0)
+	for i := range ci.informers {
+		provider := ci.informers[i].provider
+		for _, obj := range getCandidates(ci.informers[i].informer, selector) {
+			cluster, ok := obj.(client.Object)
+			if !ok {
+				continue
+			}
+			if !cluster.GetDeletionTimestamp().IsZero() {
+				// Only existing cluster can match
+				continue
+			}
+			if selector.Matches(labels.Set(cluster.GetLabels())) {
+				matching = append(matching, getObjectReference(provider, cluster))
+			}
+		}
+	}
+
+	return matching
+}
+
+func (ci *ClusterIndex) getEventHandler(provider ClusterProvider) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			ci.onClusterChange(provider, obj, false)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			ci.onClusterChange(provider, newObj, false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			ci.onClusterChange(provider, obj, true)
+		},
+	}
+}
+
+// onClusterChange re-evaluates all selectors against cluster
+func (ci *ClusterIndex) onClusterChange(provider ClusterProvider, obj interface{}, deleted bool) {
+	cluster, ok := obj.(client.Object)
+	if !ok {
+		ci.log.V(logs.LogInfo).Info(fmt.Sprintf("unexpected object type %T", obj))
+		return
+	}
+
+	ref := getObjectReference(provider, cluster)
+	deleted = deleted || !cluster.GetDeletionTimestamp().IsZero()
+
+	ci.mu.Lock()
+	var added, removed []string
+	for key, selector := range ci.selectors {
+		matching := !deleted && selector.Matches(labels.Set(cluster.GetLabels()))
+		if change := ci.setMatch(&ref, key, matching); change != nil {
+			added = append(added, change.added...)
+			removed = append(removed, change.removed...)
+		}
+	}
+	if deleted {
+		delete(ci.matches, ref)
+	}
+	handlers := ci.handlers
+	ci.mu.Unlock()
+
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	notify(handlers, []clusterMatchChange{{cluster: ref, added: added, removed: removed}})
+}
+
+// setMatch records whether selector key matches cluster. Returns the corresponding change
+// if that modifies the set of selectors matching cluster, nil otherwise.
+// Must be called with lock held.
+func (ci *ClusterIndex) setMatch(cluster *corev1.ObjectReference, key string, matching bool,
+) *clusterMatchChange {
+
+	current := ci.matches[*cluster]
+	if current[key] == matching {
+		return nil
+	}
+
+	if !matching {
+		delete(current, key)
+		if len(current) == 0 {
+			delete(ci.matches, *cluster)
+		}
+		return &clusterMatchChange{cluster: *cluster, removed: []string{key}}
+	}
+
+	if current == nil {
+		current = make(map[string]bool)
+		ci.matches[*cluster] = current
+	}
+	current[key] = true
+	return &clusterMatchChange{cluster: *cluster, added: []string{key}}
+}
+
+func notify(handlers []ClusterMatchChangeHandler, changes []clusterMatchChange) {
+	for i := range changes {
+		for _, handler := range handlers {
+			handler(&changes[i].cluster, changes[i].added, changes[i].removed)
+		}
+	}
+}
+
+// getCandidates returns the clusters which can possibly match selector.
+// If selector has an equality or set based requirement, label index is used to
+// restrict candidates to clusters with one of the required label values.
+// Otherwise all clusters are returned.
+func getCandidates(informer cache.SharedIndexInformer, selector labels.Selector) []interface{} {
+	requirements, selectable := selector.Requirements()
+	if !selectable {
+		return nil
+	}
+
+	for i := range requirements {
+		switch requirements[i].Operator() {
+		case selection.Equals, selection.DoubleEquals, selection.In:
+		default:
+			continue
+		}
+
+		candidates := make([]interface{}, 0)
+		for _, value := range requirements[i].Values().List() {
+			objs, err := informer.GetIndexer().ByIndex(labelIndexName,
+				labelIndexKey(requirements[i].Key(), value))
+			if err != nil {
+				return informer.GetStore().List()
+			}
+			candidates = append(candidates, objs...)
+		}
+		return candidates
+	}
+
+	return informer.GetStore().List()
+}
+
+func labelIndexFunc(obj interface{}) ([]string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(accessor.GetLabels()))
+	for k, v := range accessor.GetLabels() {
+		keys = append(keys, labelIndexKey(k, v))
+	}
+	return keys, nil
+}
+
+func labelIndexKey(key, value string) string {
+	return key + "=" + value
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
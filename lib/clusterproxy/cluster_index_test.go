@@ -0,0 +1,241 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/klogr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+)
+
+// widgetProvider is a ClusterProvider for Widgets, a resource installed by tests
+// after ClusterIndex is started. Only methods used by ClusterIndex are implemented.
+type widgetProvider struct {
+	clusterproxy.ClusterProvider
+	group string
+}
+
+func (p *widgetProvider) ClusterType() libsveltosv1alpha1.ClusterType {
+	return libsveltosv1alpha1.ClusterType("Widget")
+}
+
+func (p *widgetProvider) GroupVersionKind() schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: p.group, Version: "v1", Kind: "Widget"}
+}
+
+type matchEvent struct {
+	cluster corev1.ObjectReference
+	added   []string
+	removed []string
+}
+
+var _ = Describe("ClusterIndex", func() {
+	var namespace string
+	var env string
+
+	BeforeEach(func() {
+		namespace = "cluster-index" + randomString()
+		env = randomString()
+
+		ns := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: namespace,
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), ns)).To(Succeed())
+	})
+
+	It("GetMatchingClusters and GetMatchingSelectors return matches and handlers are notified", func() {
+		indexCtx, indexCancel := context.WithCancel(context.TODO())
+		defer indexCancel()
+
+		mu := &sync.Mutex{}
+		events := make([]matchEvent, 0)
+
+		index := clusterproxy.NewClusterIndex(klogr.New())
+		index.AddMatchChangeHandler(func(cluster *corev1.ObjectReference, added, removed []string) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, matchEvent{cluster: *cluster, added: added, removed: removed})
+		})
+
+		production, err := labels.Parse("env=" + env)
+		Expect(err).To(BeNil())
+		index.SetSelector("production", production)
+
+		Expect(index.Start(indexCtx, testEnv.Config)).To(Succeed())
+		Expect(index.Start(indexCtx, testEnv.Config)).ToNot(Succeed())
+
+		cluster := &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      randomString(),
+				Labels:    map[string]string{"env": env},
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), cluster)).To(Succeed())
+
+		sveltosCluster := &libsveltosv1alpha1.SveltosCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      randomString(),
+				Labels:    map[string]string{"env": env, "zone": "west"},
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), sveltosCluster)).To(Succeed())
+
+		clusterRef := corev1.ObjectReference{Namespace: cluster.Namespace, Name: cluster.Name,
+			Kind: "Cluster", APIVersion: clusterv1.GroupVersion.String()}
+		sveltosClusterRef := corev1.ObjectReference{Namespace: sveltosCluster.Namespace, Name: sveltosCluster.Name,
+			Kind: libsveltosv1alpha1.SveltosClusterKind, APIVersion: libsveltosv1alpha1.GroupVersion.String()}
+
+		Eventually(func() []corev1.ObjectReference {
+			return index.GetMatchingClusters(production)
+		}, time.Minute, time.Second).Should(ConsistOf(clusterRef, sveltosClusterRef))
+
+		west, err := labels.Parse("env in (" + env + "), zone=west")
+		Expect(err).To(BeNil())
+		Expect(index.GetMatchingClusters(west)).To(ConsistOf(sveltosClusterRef))
+
+		notWest, err := labels.Parse("env=" + env + ",zone!=west")
+		Expect(err).To(BeNil())
+		Expect(index.GetMatchingClusters(notWest)).To(ConsistOf(clusterRef))
+
+		index.SetSelector("west", west)
+		Expect(index.GetMatchingSelectors(&sveltosClusterRef)).To(Equal([]string{"production", "west"}))
+		Expect(index.GetMatchingSelectors(&clusterRef)).To(Equal([]string{"production"}))
+
+		Eventually(func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(events) == 3
+		}, time.Minute, time.Second).Should(BeTrue())
+		mu.Lock()
+		Expect(events).To(ContainElement(matchEvent{cluster: clusterRef, added: []string{"production"}}))
+		Expect(events).To(ContainElement(matchEvent{cluster: sveltosClusterRef, added: []string{"production"}}))
+		Expect(events).To(ContainElement(matchEvent{cluster: sveltosClusterRef, added: []string{"west"}}))
+		events = make([]matchEvent, 0)
+		mu.Unlock()
+
+		// Changing labels so that SveltosCluster does not match anymore
+		// Eventual loop so testEnv Cache is synced
+		Eventually(func() error {
+			return testEnv.Get(context.TODO(),
+				types.NamespacedName{Namespace: sveltosCluster.Namespace, Name: sveltosCluster.Name},
+				sveltosCluster)
+		}, time.Minute, time.Second).Should(BeNil())
+		sveltosCluster.Labels = map[string]string{"env": randomString()}
+		Expect(testEnv.Update(context.TODO(), sveltosCluster)).To(Succeed())
+
+		Eventually(func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(events) == 1
+		}, time.Minute, time.Second).Should(BeTrue())
+		mu.Lock()
+		Expect(events[0]).To(Equal(matchEvent{cluster: sveltosClusterRef, removed: []string{"production", "west"}}))
+		events = make([]matchEvent, 0)
+		mu.Unlock()
+		Expect(index.GetMatchingSelectors(&sveltosClusterRef)).To(BeEmpty())
+		Expect(index.GetMatchingClusters(production)).To(ConsistOf(clusterRef))
+
+		// Removing selector
+		index.RemoveSelector("production")
+		Expect(index.GetMatchingSelectors(&clusterRef)).To(BeEmpty())
+		mu.Lock()
+		Expect(events).To(ConsistOf(matchEvent{cluster: clusterRef, removed: []string{"production"}}))
+		mu.Unlock()
+	})
+
+	It("Start indexes clusters whose resource is installed after ClusterIndex is started", func() {
+		group := strings.ToLower(randomString()) + ".example.com"
+		provider := &widgetProvider{group: group}
+		Expect(clusterproxy.RegisterClusterProvider(provider)).To(Succeed())
+		defer clusterproxy.UnregisterClusterProvider(provider.ClusterType())
+
+		indexCtx, indexCancel := context.WithCancel(context.TODO())
+		defer indexCancel()
+
+		index := clusterproxy.NewClusterIndex(klogr.New())
+		production, err := labels.Parse("env=" + env)
+		Expect(err).To(BeNil())
+
+		// Start fails if informers cannot sync. Start can then be called again.
+		canceledCtx, cancel := context.WithCancel(context.TODO())
+		cancel()
+		Expect(index.Start(canceledCtx, testEnv.Config)).ToNot(Succeed())
+		Expect(index.Start(indexCtx, testEnv.Config)).To(Succeed())
+
+		widgetCRD := &apiextensionsv1.CustomResourceDefinition{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "widgets." + group,
+			},
+			Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+				Group: group,
+				Scope: apiextensionsv1.NamespaceScoped,
+				Names: apiextensionsv1.CustomResourceDefinitionNames{
+					Plural: "widgets", Singular: "widget", Kind: "Widget", ListKind: "WidgetList",
+				},
+				Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+					{
+						Name: "v1", Served: true, Storage: true,
+						Schema: &apiextensionsv1.CustomResourceValidation{
+							OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{Type: "object"},
+						},
+					},
+				},
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), widgetCRD)).To(Succeed())
+		defer func() {
+			Expect(testEnv.Delete(context.TODO(), widgetCRD)).To(Succeed())
+		}()
+
+		widget := &unstructured.Unstructured{}
+		widget.SetGroupVersionKind(provider.GroupVersionKind())
+		widget.SetNamespace(namespace)
+		widget.SetName(randomString())
+		widget.SetLabels(map[string]string{"env": env})
+		// Eventual loop so CustomResourceDefinition is established
+		Eventually(func() error {
+			return testEnv.Create(context.TODO(), widget)
+		}, time.Minute, time.Second).Should(Succeed())
+
+		widgetRef := corev1.ObjectReference{Namespace: namespace, Name: widget.GetName(),
+			Kind: "Widget", APIVersion: group + "/v1"}
+		Eventually(func() []corev1.ObjectReference {
+			return index.GetMatchingClusters(production)
+		}, time.Minute, time.Second).Should(ConsistOf(widgetRef))
+	})
+})
@@ -0,0 +1,198 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+)
+
+// Sveltos manages CAPI Clusters and SveltosClusters out of the box.
+// Other sources of clusters (for instance Rancher, OCM ManagedCluster or GKE fleet
+// memberships) can be supported by implementing a ClusterProvider and registering
+// it with RegisterClusterProvider.
+
+// KubeconfigSource contains the kubeconfig to access a cluster
+type KubeconfigSource struct {
+	// Secret is the Secret the kubeconfig was read from.
+	// Used to detect when kubeconfig changes. Optional: if nil, ClientCache
+	// entries for the cluster are only invalidated explicitly or by eviction.
+	Secret *corev1.Secret
+
+	// Kubeconfig is the kubeconfig content
+	Kubeconfig []byte
+
+	// Context is the kubeconfig context to use. If empty, kubeconfig
+	// current-context is used.
+	Context string
+}
+
+// ClusterProvider gives access to all clusters of a given ClusterType
+type ClusterProvider interface {
+	// ClusterType returns the type of clusters served by this provider
+	ClusterType() libsveltosv1alpha1.ClusterType
+
+	// GroupVersionKind returns the GroupVersionKind of the resources representing
+	// clusters served by this provider
+	GroupVersionKind() schema.GroupVersionKind
+
+	// ListClusters returns all existing clusters. Clusters being deleted must not be returned.
+	// If the resource representing clusters is not installed, no cluster and no error
+	// must be returned.
+	ListClusters(ctx context.Context, c client.Client, logger logr.Logger) ([]client.Object, error)
+
+	// GetCluster returns the cluster clusterNamespace/clusterName
+	GetCluster(ctx context.Context, c client.Client, clusterNamespace, clusterName string) (client.Object, error)
+
+	// IsClusterReadyToBeConfigured returns true if cluster is ready to be configured
+	IsClusterReadyToBeConfigured(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
+		logger logr.Logger) (bool, error)
+
+	// IsClusterPaused returns true if cluster is currently paused
+	IsClusterPaused(ctx context.Context, c client.Client, clusterNamespace, clusterName string) (bool, error)
+
+	// GetKubeconfigSource returns the kubeconfig to access cluster as cluster-admin
+	GetKubeconfigSource(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
+		logger logr.Logger) (*KubeconfigSource, error)
+}
+
+// ErrUnknownClusterType is returned when no ClusterProvider is registered
+// for a cluster type or for a cluster reference
+var ErrUnknownClusterType = errors.New("unknown cluster type")
+
+var (
+	providersLock = &sync.RWMutex{}
+	// providers contains all registered ClusterProviders in registration order
+	providers []ClusterProvider
+)
+
+func init() {
+	providers = []ClusterProvider{&capiProvider{}, &sveltosProvider{}}
+}
+
+// RegisterClusterProvider registers a ClusterProvider. Returns an error if a ClusterProvider
+// for same ClusterType or same group and Kind is already registered.
+func RegisterClusterProvider(provider ClusterProvider) error {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+
+	for i := range providers {
+		if providers[i].ClusterType() == provider.ClusterType() {
+			return fmt.Errorf("a ClusterProvider for cluster type %s is already registered",
+				provider.ClusterType())
+		}
+		if providers[i].GroupVersionKind().GroupKind() == provider.GroupVersionKind().GroupKind() {
+			return fmt.Errorf("a ClusterProvider for %s is already registered",
+				provider.GroupVersionKind().String())
+		}
+	}
+
+	providers = append(providers, provider)
+	return nil
+}
+
+// UnregisterClusterProvider removes the ClusterProvider registered for clusterType, if any
+func UnregisterClusterProvider(clusterType libsveltosv1alpha1.ClusterType) {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+
+	for i := range providers {
+		if providers[i].ClusterType() == clusterType {
+			providers = append(providers[:i], providers[i+1:]...)
+			return
+		}
+	}
+}
+
+// GetClusterProvider returns the ClusterProvider registered for clusterType
+func GetClusterProvider(clusterType libsveltosv1alpha1.ClusterType) (ClusterProvider, error) {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+
+	for i := range providers {
+		if providers[i].ClusterType() == clusterType {
+			return providers[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnknownClusterType, clusterType)
+}
+
+// getClusterProviders returns all registered ClusterProviders
+func getClusterProviders() []ClusterProvider {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+
+	result := make([]ClusterProvider, len(providers))
+	copy(result, providers)
+	return result
+}
+
+// getClusterProviderForReference returns the ClusterProvider serving cluster.
+// Cluster is matched on API group and Kind, so any version of the provider resource
+// is accepted. A reference with neither APIVersion nor Kind set is considered a CAPI Cluster.
+func getClusterProviderForReference(cluster *corev1.ObjectReference) ClusterProvider {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+
+	for i := range providers {
+		if cluster.APIVersion == "" && cluster.Kind == "" {
+			if providers[i].ClusterType() == libsveltosv1alpha1.ClusterTypeCapi {
+				return providers[i]
+			}
+			continue
+		}
+		if referenceMatchesGroupKind(cluster, providers[i].GroupVersionKind().GroupKind()) {
+			return providers[i]
+		}
+	}
+
+	return nil
+}
+
+// referenceMatchesGroupKind returns true if ref API group is gk Group and, when set,
+// ref Kind is gk Kind. ref version is ignored.
+func referenceMatchesGroupKind(ref *corev1.ObjectReference, gk schema.GroupKind) bool {
+	if ref.APIVersion != "" {
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil || gv.Group != gk.Group {
+			return false
+		}
+	}
+
+	return ref.Kind == "" || ref.Kind == gk.Kind
+}
+
+// getObjectReference returns the ObjectReference for a cluster served by provider
+func getObjectReference(provider ClusterProvider, cluster client.Object) corev1.ObjectReference {
+	apiVersion, kind := provider.GroupVersionKind().ToAPIVersionAndKind()
+	return corev1.ObjectReference{
+		Namespace:  cluster.GetNamespace(),
+		Name:       cluster.GetName(),
+		APIVersion: apiVersion,
+		Kind:       kind,
+	}
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/klogr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/internal/test/helpers/external"
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+)
+
+const (
+	configMapClusterType = libsveltosv1alpha1.ClusterType("ConfigMap")
+	configMapClusterKey  = "cluster"
+)
+
+// configMapProvider is a ClusterProvider where each ConfigMap with the configMapClusterKey
+// label represents a cluster. Data contains the kubeconfig, the readiness and paused state.
+type configMapProvider struct{}
+
+func (p *configMapProvider) ClusterType() libsveltosv1alpha1.ClusterType {
+	return configMapClusterType
+}
+
+func (p *configMapProvider) GroupVersionKind() schema.GroupVersionKind {
+	return corev1.SchemeGroupVersion.WithKind("ConfigMap")
+}
+
+func (p *configMapProvider) ListClusters(ctx context.Context, c client.Client, logger logr.Logger,
+) ([]client.Object, error) {
+
+	configMaps := &corev1.ConfigMapList{}
+	if err := c.List(ctx, configMaps, client.HasLabels{configMapClusterKey}); err != nil {
+		return nil, err
+	}
+
+	clusters := make([]client.Object, 0, len(configMaps.Items))
+	for i := range configMaps.Items {
+		clusters = append(clusters, &configMaps.Items[i])
+	}
+	return clusters, nil
+}
+
+func (p *configMapProvider) GetCluster(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName string) (client.Object, error) {
+
+	configMap := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: clusterNamespace, Name: clusterName}, configMap)
+	return configMap, err
+}
+
+func (p *configMapProvider) IsClusterReadyToBeConfigured(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName string, logger logr.Logger) (bool, error) {
+
+	return p.getBool(ctx, c, clusterNamespace, clusterName, "ready")
+}
+
+func (p *configMapProvider) IsClusterPaused(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName string) (bool, error) {
+
+	return p.getBool(ctx, c, clusterNamespace, clusterName, "paused")
+}
+
+func (p *configMapProvider) GetKubeconfigSource(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName string, logger logr.Logger) (*clusterproxy.KubeconfigSource, error) {
+
+	configMap := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: clusterNamespace, Name: clusterName}, configMap)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterproxy.KubeconfigSource{Kubeconfig: []byte(configMap.Data["kubeconfig"])}, nil
+}
+
+func (p *configMapProvider) getBool(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName, key string) (bool, error) {
+
+	configMap := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: clusterNamespace, Name: clusterName}, configMap)
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(configMap.Data[key])
+}
+
+var _ = Describe("ClusterProvider", func() {
+	var namespace string
+	var configMap *corev1.ConfigMap
+
+	BeforeEach(func() {
+		namespace = "cluster-provider" + randomString()
+
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      randomString(),
+				Labels: map[string]string{
+					configMapClusterKey: "true",
+					"env":               "production",
+				},
+			},
+			Data: map[string]string{
+				"kubeconfig": fmt.Sprintf(multiContextKubeconfig, randomString()),
+				"ready":      "true",
+				"paused":     "false",
+			},
+		}
+
+		Expect(clusterproxy.RegisterClusterProvider(&configMapProvider{})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		clusterproxy.UnregisterClusterProvider(configMapClusterType)
+	})
+
+	It("RegisterClusterProvider fails for an already registered cluster type", func() {
+		Expect(clusterproxy.RegisterClusterProvider(&configMapProvider{})).ToNot(Succeed())
+
+		provider, err := clusterproxy.GetClusterProvider(libsveltosv1alpha1.ClusterTypeSveltos)
+		Expect(err).To(BeNil())
+		Expect(clusterproxy.RegisterClusterProvider(provider)).ToNot(Succeed())
+	})
+
+	It("GetClusterProvider returns an error for an unregistered cluster type", func() {
+		provider, err := clusterproxy.GetClusterProvider(configMapClusterType)
+		Expect(err).To(BeNil())
+		Expect(provider.ClusterType()).To(Equal(configMapClusterType))
+
+		clusterproxy.UnregisterClusterProvider(configMapClusterType)
+		_, err = clusterproxy.GetClusterProvider(configMapClusterType)
+		Expect(err).ToNot(BeNil())
+		Expect(errors.Is(err, clusterproxy.ErrUnknownClusterType)).To(BeTrue())
+	})
+
+	It("clusters from a registered ClusterProvider are listed and matched", func() {
+		cluster := &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      randomString(),
+				Labels:    map[string]string{"env": "production"},
+			},
+		}
+
+		notACluster := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      randomString(),
+				Labels:    map[string]string{"env": "production"},
+			},
+		}
+
+		clusterCRD := external.TestClusterCRD.DeepCopy()
+
+		initObjects := []client.Object{clusterCRD, configMap, cluster, notACluster}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		clusterRef := corev1.ObjectReference{
+			Namespace: configMap.Namespace, Name: configMap.Name,
+			APIVersion: "v1", Kind: "ConfigMap",
+		}
+
+		clusters, err := clusterproxy.GetListOfClusters(context.TODO(), c, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(clusters).To(ContainElement(clusterRef))
+		Expect(clusters).To(ContainElement(HaveField("Name", cluster.Name)))
+		Expect(clusters).ToNot(ContainElement(HaveField("Name", notACluster.Name)))
+
+		selector, err := labels.Parse("env=production")
+		Expect(err).To(BeNil())
+		matching, err := clusterproxy.GetMatchingClusters(context.TODO(), c, selector, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(matching).To(ContainElement(clusterRef))
+
+		clusterType, err := clusterproxy.GetClusterType(&clusterRef)
+		Expect(err).To(BeNil())
+		Expect(clusterType).To(Equal(configMapClusterType))
+
+		ready, err := clusterproxy.IsClusterReadyToBeConfigured(context.TODO(), c, &clusterRef, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(ready).To(BeTrue())
+
+		paused, err := clusterproxy.IsClusterPaused(context.TODO(), c, configMap.Namespace, configMap.Name,
+			configMapClusterType)
+		Expect(err).To(BeNil())
+		Expect(paused).To(BeFalse())
+
+		config, err := clusterproxy.GetKubernetesRestConfig(context.TODO(), c, configMap.Namespace, configMap.Name,
+			"", "", configMapClusterType, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(config.Host).To(Equal("https://cluster-a:6443"))
+	})
+
+	It("ClientCache caches clusters whose kubeconfig is not stored in a Secret", func() {
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(configMap).Build()
+
+		clientCache := clusterproxy.NewClientCache(0)
+		config, err := clientCache.GetKubernetesRestConfig(context.TODO(), c, configMap.Namespace, configMap.Name,
+			"", "", configMapClusterType, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(config.Host).To(Equal("https://cluster-a:6443"))
+		Expect(clientCache.Len()).To(Equal(1))
+
+		clientCache.Invalidate(configMap.Namespace, configMap.Name, configMapClusterType)
+		Expect(clientCache.Len()).To(Equal(0))
+	})
+
+	It("GetCluster returns an error for an unregistered cluster type", func() {
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(configMap).Build()
+
+		clusterproxy.UnregisterClusterProvider(configMapClusterType)
+		_, err := clusterproxy.GetCluster(context.TODO(), c, configMap.Namespace, configMap.Name,
+			configMapClusterType)
+		Expect(err).ToNot(BeNil())
+	})
+})
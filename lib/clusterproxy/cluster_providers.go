@@ -0,0 +1,175 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+)
+
+// capiProvider is the ClusterProvider for ClusterAPI Clusters
+type capiProvider struct{}
+
+func (p *capiProvider) ClusterType() libsveltosv1alpha1.ClusterType {
+	return libsveltosv1alpha1.ClusterTypeCapi
+}
+
+func (p *capiProvider) GroupVersionKind() schema.GroupVersionKind {
+	return clusterv1.GroupVersion.WithKind("Cluster")
+}
+
+func (p *capiProvider) ListClusters(ctx context.Context, c client.Client, logger logr.Logger,
+) ([]client.Object, error) {
+
+	present, err := GetCAPIDetector().IsPresent(ctx, c, logger)
+	if err != nil {
+		logger.Error(err, "failed to verify if ClusterAPI Cluster CRD is installed")
+		return nil, err
+	}
+
+	if !present {
+		return nil, nil
+	}
+
+	clusterList := &clusterv1.ClusterList{}
+	if err := c.List(ctx, clusterList); err != nil {
+		logger.Error(err, "failed to list all Cluster")
+		return nil, err
+	}
+
+	clusters := make([]client.Object, 0, len(clusterList.Items))
+	for i := range clusterList.Items {
+		cluster := &clusterList.Items[i]
+		if !cluster.DeletionTimestamp.IsZero() {
+			// Only existing cluster can match
+			continue
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters, nil
+}
+
+func (p *capiProvider) GetCluster(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName string) (client.Object, error) {
+
+	return getCAPICluster(ctx, c, clusterNamespace, clusterName)
+}
+
+func (p *capiProvider) IsClusterReadyToBeConfigured(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName string, logger logr.Logger) (bool, error) {
+
+	return isCAPIClusterReadyToBeConfigured(ctx, c,
+		&corev1.ObjectReference{Namespace: clusterNamespace, Name: clusterName}, logger)
+}
+
+func (p *capiProvider) IsClusterPaused(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName string) (bool, error) {
+
+	return isCAPIClusterPaused(ctx, c, clusterNamespace, clusterName)
+}
+
+func (p *capiProvider) GetKubeconfigSource(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName string, logger logr.Logger) (*KubeconfigSource, error) {
+
+	secret, err := getCAPIKubeconfigSecret(ctx, logger, c, clusterNamespace, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeconfig, err := getKubeconfigFromSecret(logger, secret, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &KubeconfigSource{Secret: secret, Kubeconfig: kubeconfig}, nil
+}
+
+// sveltosProvider is the ClusterProvider for SveltosClusters
+type sveltosProvider struct{}
+
+func (p *sveltosProvider) ClusterType() libsveltosv1alpha1.ClusterType {
+	return libsveltosv1alpha1.ClusterTypeSveltos
+}
+
+func (p *sveltosProvider) GroupVersionKind() schema.GroupVersionKind {
+	return libsveltosv1alpha1.GroupVersion.WithKind(libsveltosv1alpha1.SveltosClusterKind)
+}
+
+func (p *sveltosProvider) ListClusters(ctx context.Context, c client.Client, logger logr.Logger,
+) ([]client.Object, error) {
+
+	clusterList := &libsveltosv1alpha1.SveltosClusterList{}
+	if err := c.List(ctx, clusterList); err != nil {
+		logger.Error(err, "failed to list all Cluster")
+		return nil, err
+	}
+
+	clusters := make([]client.Object, 0, len(clusterList.Items))
+	for i := range clusterList.Items {
+		cluster := &clusterList.Items[i]
+		if !cluster.DeletionTimestamp.IsZero() {
+			// Only existing cluster can match
+			continue
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters, nil
+}
+
+func (p *sveltosProvider) GetCluster(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName string) (client.Object, error) {
+
+	return getSveltosCluster(ctx, c, clusterNamespace, clusterName)
+}
+
+func (p *sveltosProvider) IsClusterReadyToBeConfigured(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName string, logger logr.Logger) (bool, error) {
+
+	return isSveltosClusterReadyToBeConfigured(ctx, c,
+		&corev1.ObjectReference{Namespace: clusterNamespace, Name: clusterName}, logger)
+}
+
+func (p *sveltosProvider) IsClusterPaused(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName string) (bool, error) {
+
+	return isSveltosClusterPaused(ctx, c, clusterNamespace, clusterName)
+}
+
+func (p *sveltosProvider) GetKubeconfigSource(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName string, logger logr.Logger) (*KubeconfigSource, error) {
+
+	cluster, secret, err := getSveltosKubeconfigSecret(ctx, logger, c, clusterNamespace, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeconfig, err := getKubeconfigFromSecret(logger, secret, cluster.Spec.KubeconfigKeyName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KubeconfigSource{Secret: secret, Kubeconfig: kubeconfig, Context: cluster.Spec.KubeconfigContext}, nil
+}
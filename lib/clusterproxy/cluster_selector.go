@@ -0,0 +1,216 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+)
+
+// ParseClusterSelector converts a Selector (label selector in string form) to
+// the equivalent ClusterSelector, preserving Selector semantics:
+// - a Selector supported by metav1.LabelSelector is converted to LabelSelector;
+// - an empty Selector (which selects all clusters) and Selectors using operators not
+// supported by metav1.LabelSelector (such as gt and lt) are kept in ClusterSelector Selector.
+func ParseClusterSelector(selector libsveltosv1alpha1.Selector) (*libsveltosv1alpha1.ClusterSelector, error) {
+	if selector != "" {
+		labelSelector, err := metav1.ParseToLabelSelector(string(selector))
+		if err == nil {
+			return &libsveltosv1alpha1.ClusterSelector{LabelSelector: *labelSelector}, nil
+		}
+	}
+
+	if _, err := labels.Parse(string(selector)); err != nil {
+		return nil, fmt.Errorf("failed to parse selector %q: %w", selector, err)
+	}
+
+	return &libsveltosv1alpha1.ClusterSelector{Selector: &selector}, nil
+}
+
+// clusterSelectorMatcher evaluates a ClusterSelector
+type clusterSelectorMatcher struct {
+	clusterSelector *libsveltosv1alpha1.ClusterSelector
+
+	// labelSelector is nil if ClusterSelector has no label nor annotation criteria
+	labelSelector labels.Selector
+
+	// selector is nil if ClusterSelector Selector is not set
+	selector labels.Selector
+}
+
+func newClusterSelectorMatcher(clusterSelector *libsveltosv1alpha1.ClusterSelector,
+) (*clusterSelectorMatcher, error) {
+
+	m := &clusterSelectorMatcher{clusterSelector: clusterSelector}
+
+	if len(clusterSelector.MatchLabels) != 0 || len(clusterSelector.MatchExpressions) != 0 ||
+		len(clusterSelector.MatchAnnotations) != 0 {
+
+		labelSelector, err := metav1.LabelSelectorAsSelector(&clusterSelector.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector: %w", err)
+		}
+		m.labelSelector = labelSelector
+	}
+
+	if clusterSelector.Selector != nil {
+		selector, err := labels.Parse(string(*clusterSelector.Selector))
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector: %w", err)
+		}
+		m.selector = selector
+	}
+
+	return m, nil
+}
+
+// isClusterTypeSelected returns true if clusters of clusterType can be selected
+func (m *clusterSelectorMatcher) isClusterTypeSelected(clusterType libsveltosv1alpha1.ClusterType) bool {
+	if len(m.clusterSelector.ClusterTypes) == 0 {
+		return true
+	}
+
+	for i := range m.clusterSelector.ClusterTypes {
+		if m.clusterSelector.ClusterTypes[i] == clusterType {
+			return true
+		}
+	}
+	return false
+}
+
+// isSelected returns true if cluster, served by provider, is selected.
+func (m *clusterSelectorMatcher) isSelected(ctx context.Context, c client.Client,
+	provider ClusterProvider, cluster client.Object, logger logr.Logger) (bool, error) {
+
+	if !m.isClusterTypeSelected(provider.ClusterType()) || !m.isNamespaceSelected(cluster.GetNamespace()) {
+		return false, nil
+	}
+
+	if !m.isReferenced(provider, cluster) && !m.isLabelMatch(cluster) {
+		return false, nil
+	}
+
+	if m.clusterSelector.RequireReady {
+		return provider.IsClusterReadyToBeConfigured(ctx, c, cluster.GetNamespace(), cluster.GetName(), logger)
+	}
+
+	return true, nil
+}
+
+func (m *clusterSelectorMatcher) isNamespaceSelected(namespace string) bool {
+	if len(m.clusterSelector.Namespaces) == 0 {
+		return true
+	}
+
+	for i := range m.clusterSelector.Namespaces {
+		if m.clusterSelector.Namespaces[i] == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *clusterSelectorMatcher) isReferenced(provider ClusterProvider, cluster client.Object) bool {
+	for i := range m.clusterSelector.ClusterRefs {
+		ref := &m.clusterSelector.ClusterRefs[i]
+		if ref.Namespace != cluster.GetNamespace() || ref.Name != cluster.GetName() {
+			continue
+		}
+		if refProvider := getClusterProviderForReference(ref); refProvider != nil &&
+			refProvider.ClusterType() == provider.ClusterType() {
+
+			return true
+		}
+	}
+	return false
+}
+
+// isLabelMatch returns true if ClusterSelector has label (or annotation) criteria
+// and cluster matches all of them
+func (m *clusterSelectorMatcher) isLabelMatch(cluster client.Object) bool {
+	if m.labelSelector == nil && m.selector == nil {
+		return false
+	}
+
+	if m.selector != nil && !m.selector.Matches(labels.Set(cluster.GetLabels())) {
+		return false
+	}
+
+	if m.labelSelector == nil {
+		return true
+	}
+
+	if !m.labelSelector.Matches(labels.Set(cluster.GetLabels())) {
+		return false
+	}
+
+	annotations := cluster.GetAnnotations()
+	for k, v := range m.clusterSelector.MatchAnnotations {
+		if current, ok := annotations[k]; !ok || current != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GetMatchingClustersWithSelector returns all clusters currently selected by clusterSelector.
+// Contrary to GetMatchingClusters, a ClusterSelector with no criteria selects no cluster
+// (a ClusterSelector whose Selector is set to an empty string selects all clusters).
+// Every call lists all clusters. Components evaluating label selectors frequently should use a ClusterIndex.
+func GetMatchingClustersWithSelector(ctx context.Context, c client.Client,
+	clusterSelector *libsveltosv1alpha1.ClusterSelector, logger logr.Logger) ([]corev1.ObjectReference, error) {
+
+	matcher, err := newClusterSelectorMatcher(clusterSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	matching := make([]corev1.ObjectReference, 0)
+
+	for _, provider := range getClusterProviders() {
+		if !matcher.isClusterTypeSelected(provider.ClusterType()) {
+			continue
+		}
+
+		providerClusters, err := provider.ListClusters(ctx, c, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range providerClusters {
+			cluster := providerClusters[i]
+			selected, err := matcher.isSelected(ctx, c, provider, cluster, logger)
+			if err != nil {
+				return nil, err
+			}
+			if selected {
+				matching = append(matching, getObjectReference(provider, cluster))
+			}
+		}
+	}
+
+	return matching, nil
+}
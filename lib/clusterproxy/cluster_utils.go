@@ -18,25 +18,18 @@ package clusterproxy
 
 import (
 	"context"
-	"os"
-	"sync/atomic"
+	"fmt"
 
 	corev1 "k8s.io/api/core/v1"
-	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/go-logr/logr"
-	"github.com/pkg/errors"
 
 	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
-	"github.com/projectsveltos/libsveltos/lib/logsettings"
 	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
 	"github.com/projectsveltos/libsveltos/lib/roles"
 	"github.com/projectsveltos/libsveltos/lib/sharding"
@@ -46,11 +39,6 @@ const (
 	kubernetesAdmin = "kubernetes-admin"
 )
 
-var (
-	checkedCAPIPresence int32
-	capiPresent         int32
-)
-
 // getSveltosCluster returns SveltosCluster
 func getSveltosCluster(ctx context.Context, c client.Client,
 	clusterNamespace, clusterName string) (*libsveltosv1alpha1.SveltosCluster, error) {
@@ -83,14 +71,15 @@ func getCAPICluster(ctx context.Context, c client.Client,
 	return cluster, nil
 }
 
-// getCluster returns the cluster object
+// GetCluster returns the cluster object
 func GetCluster(ctx context.Context, c client.Client,
 	clusterNamespace, clusterName string, clusterType libsveltosv1alpha1.ClusterType) (client.Object, error) {
 
-	if clusterType == libsveltosv1alpha1.ClusterTypeSveltos {
-		return getSveltosCluster(ctx, c, clusterNamespace, clusterName)
+	provider, err := GetClusterProvider(clusterType)
+	if err != nil {
+		return nil, err
 	}
-	return getCAPICluster(ctx, c, clusterNamespace, clusterName)
+	return provider.GetCluster(ctx, c, clusterNamespace, clusterName)
 }
 
 // isCAPIClusterPaused returns true if CAPI Cluster is paused
@@ -121,35 +110,24 @@ func isSveltosClusterPaused(ctx context.Context, c client.Client,
 func IsClusterPaused(ctx context.Context, c client.Client,
 	clusterNamespace, clusterName string, clusterType libsveltosv1alpha1.ClusterType) (bool, error) {
 
-	if clusterType == libsveltosv1alpha1.ClusterTypeSveltos {
-		return isSveltosClusterPaused(ctx, c, clusterNamespace, clusterName)
+	provider, err := GetClusterProvider(clusterType)
+	if err != nil {
+		return false, err
 	}
-	return isCAPIClusterPaused(ctx, c, clusterNamespace, clusterName)
+	return provider.IsClusterPaused(ctx, c, clusterNamespace, clusterName)
 }
 
 func getKubernetesRestConfigForAdmin(ctx context.Context, c client.Client,
 	clusterNamespace, clusterName, adminNamespace, adminName string,
 	clusterType libsveltosv1alpha1.ClusterType, logger logr.Logger) (*rest.Config, error) {
 
-	kubeconfigContent, err := roles.GetKubeconfig(ctx, c, clusterNamespace, clusterName,
-		adminNamespace, adminName, clusterType)
-	if err != nil {
-		return nil, err
-	}
-
-	kubeconfig, err := CreateKubeconfig(logger, kubeconfigContent)
+	kubeconfigContent, err := GetSecretData(ctx, c, clusterNamespace, clusterName,
+		adminNamespace, adminName, clusterType, logger)
 	if err != nil {
 		return nil, err
 	}
-	defer os.Remove(kubeconfig)
-
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-	if err != nil {
-		logger.Error(err, "BuildConfigFromFlags")
-		return nil, errors.Wrap(err, "BuildConfigFromFlags")
-	}
 
-	return config, nil
+	return GetRestConfigFromKubeconfig(logger, kubeconfigContent, "")
 }
 
 func getKubernetesClientForAdmin(ctx context.Context, c client.Client,
@@ -171,14 +149,24 @@ func GetSecretData(ctx context.Context, c client.Client,
 	clusterType libsveltosv1alpha1.ClusterType, logger logr.Logger) ([]byte, error) {
 
 	if adminName != "" && adminName != kubernetesAdmin {
+		if GetTenantAccessMode() == TenantAccessImpersonation {
+			source, err := getImpersonatingKubeconfigSource(ctx, c, clusterNamespace, clusterName,
+				adminNamespace, adminName, clusterType, logger)
+			if err != nil {
+				return nil, err
+			}
+			return source.Kubeconfig, nil
+		}
+
 		return roles.GetKubeconfig(ctx, c, clusterNamespace, clusterName,
 			adminNamespace, adminName, clusterType)
 	}
 
-	if clusterType == libsveltosv1alpha1.ClusterTypeSveltos {
-		return GetSveltosSecretData(ctx, logger, c, clusterNamespace, clusterName)
+	source, err := getKubeconfigSource(ctx, c, clusterNamespace, clusterName, clusterType, logger)
+	if err != nil {
+		return nil, err
 	}
-	return GetCAPISecretData(ctx, logger, c, clusterNamespace, clusterName)
+	return source.Kubeconfig, nil
 }
 
 // GetKubernetesRestConfig returns restConfig for a cluster
@@ -191,10 +179,11 @@ func GetKubernetesRestConfig(ctx context.Context, c client.Client,
 			adminNamespace, adminName, clusterType, logger)
 	}
 
-	if clusterType == libsveltosv1alpha1.ClusterTypeSveltos {
-		return GetSveltosKubernetesRestConfig(ctx, logger, c, clusterNamespace, clusterName)
+	source, err := getKubeconfigSource(ctx, c, clusterNamespace, clusterName, clusterType, logger)
+	if err != nil {
+		return nil, err
 	}
-	return GetCAPIKubernetesRestConfig(ctx, logger, c, clusterNamespace, clusterName)
+	return GetRestConfigFromKubeconfig(logger, source.Kubeconfig, source.Context)
 }
 
 // GetKubernetesClient returns client to access cluster
@@ -207,292 +196,104 @@ func GetKubernetesClient(ctx context.Context, c client.Client,
 			adminNamespace, adminName, clusterType, logger)
 	}
 
-	if clusterType == libsveltosv1alpha1.ClusterTypeSveltos {
-		return GetSveltosKubernetesClient(ctx, logger, c, c.Scheme(), clusterNamespace, clusterName)
-	}
-	return GetCAPIKubernetesClient(ctx, logger, c, c.Scheme(), clusterNamespace, clusterName)
-}
-
-// GetClusterType returns clustertype for a given cluster
-func GetClusterType(cluster *corev1.ObjectReference) libsveltosv1alpha1.ClusterType {
-	// TODO: remove this
-	if cluster.APIVersion != libsveltosv1alpha1.GroupVersion.String() &&
-		cluster.APIVersion != clusterv1.GroupVersion.String() {
-
-		panic(1)
-	}
-
-	clusterType := libsveltosv1alpha1.ClusterTypeCapi
-	if cluster.APIVersion == libsveltosv1alpha1.GroupVersion.String() {
-		clusterType = libsveltosv1alpha1.ClusterTypeSveltos
-	}
-	return clusterType
-}
-
-func addTypeInformationToObject(scheme *runtime.Scheme, obj client.Object) {
-	gvks, _, err := scheme.ObjectKinds(obj)
+	config, err := GetKubernetesRestConfig(ctx, c, clusterNamespace, clusterName, adminNamespace, adminName,
+		clusterType, logger)
 	if err != nil {
-		panic(1)
-	}
-
-	for _, gvk := range gvks {
-		if gvk.Kind == "" {
-			continue
-		}
-		if gvk.Version == "" || gvk.Version == runtime.APIVersionInternal {
-			continue
-		}
-		obj.GetObjectKind().SetGroupVersionKind(gvk)
-		break
+		return nil, err
 	}
+	logger.V(logs.LogVerbose).Info("return new client")
+	return client.New(config, client.Options{Scheme: c.Scheme()})
 }
 
-// getListOfCAPIClusters returns all CAPI Clusters where Classifier needs to be deployed.
-// Currently a Classifier instance needs to be deployed in every existing CAPI cluster.
-func getListOfCAPICluster(ctx context.Context, c client.Client, shard *string, logger logr.Logger,
-) ([]corev1.ObjectReference, error) {
+// getKubeconfigSource returns, using the ClusterProvider registered for clusterType,
+// the kubeconfig to access cluster as cluster-admin
+func getKubeconfigSource(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName string, clusterType libsveltosv1alpha1.ClusterType,
+	logger logr.Logger) (*KubeconfigSource, error) {
 
-	present, err := isCAPIPresent(ctx, c, logger)
+	provider, err := GetClusterProvider(clusterType)
 	if err != nil {
-		logger.Error(err, "failed to verify if ClusterAPI Cluster CRD is installed")
-		return nil, err
-	}
-
-	if !present {
-		return nil, nil
-	}
-
-	clusterList := &clusterv1.ClusterList{}
-	if err := c.List(ctx, clusterList); err != nil {
-		logger.Error(err, "failed to list all Cluster")
 		return nil, err
 	}
+	return provider.GetKubeconfigSource(ctx, c, clusterNamespace, clusterName, logger)
+}
 
-	clusters := make([]corev1.ObjectReference, 0)
-
-	for i := range clusterList.Items {
-		cluster := &clusterList.Items[i]
-
-		if !cluster.DeletionTimestamp.IsZero() {
-			// Only existing cluster can match
-			continue
-		}
-
-		if shard != nil && !sharding.IsShardAMatch(*shard, cluster) {
-			continue
+// GetClusterType returns clustertype for a given cluster.
+// Returns an error wrapping ErrUnknownClusterType if no registered ClusterProvider
+// serves cluster API group and Kind (any version).
+func GetClusterType(cluster *corev1.ObjectReference) (libsveltosv1alpha1.ClusterType, error) {
+	if cluster.APIVersion != "" {
+		for _, provider := range getClusterProviders() {
+			if referenceMatchesGroupKind(cluster, provider.GroupVersionKind().GroupKind()) {
+				return provider.ClusterType(), nil
+			}
 		}
-
-		addTypeInformationToObject(c.Scheme(), cluster)
-
-		clusters = append(clusters, corev1.ObjectReference{
-			Namespace:  cluster.Namespace,
-			Name:       cluster.Name,
-			APIVersion: cluster.APIVersion,
-			Kind:       cluster.Kind,
-		})
 	}
 
-	return clusters, nil
+	return "", fmt.Errorf("%w: apiVersion %q kind %q", ErrUnknownClusterType,
+		cluster.APIVersion, cluster.Kind)
 }
 
-// getListOfSveltosClusters returns all Sveltos Clusters where Classifier needs to be deployed.
-// Currently a Classifier instance needs to be deployed in every existing sveltosCluster.
-func getListOfSveltosCluster(ctx context.Context, c client.Client, shard *string, logger logr.Logger,
+// getListOfClusters returns all existing clusters, across all registered ClusterProviders.
+// If shard is set, only clusters matching shard are returned.
+func getListOfClusters(ctx context.Context, c client.Client, shard *string, logger logr.Logger,
 ) ([]corev1.ObjectReference, error) {
 
-	clusterList := &libsveltosv1alpha1.SveltosClusterList{}
-	if err := c.List(ctx, clusterList); err != nil {
-		logger.Error(err, "failed to list all Cluster")
-		return nil, err
-	}
-
 	clusters := make([]corev1.ObjectReference, 0)
 
-	for i := range clusterList.Items {
-		cluster := &clusterList.Items[i]
-
-		if !cluster.DeletionTimestamp.IsZero() {
-			// Only existing cluster can match
-			continue
+	for _, provider := range getClusterProviders() {
+		providerClusters, err := provider.ListClusters(ctx, c, logger)
+		if err != nil {
+			return nil, err
 		}
 
-		if shard != nil && !sharding.IsShardAMatch(*shard, cluster) {
-			continue
+		for i := range providerClusters {
+			cluster := providerClusters[i]
+			if shard != nil && !sharding.IsShardAMatch(*shard, cluster) {
+				continue
+			}
+			clusters = append(clusters, getObjectReference(provider, cluster))
 		}
-
-		addTypeInformationToObject(c.Scheme(), cluster)
-
-		clusters = append(clusters, corev1.ObjectReference{
-			Namespace:  cluster.Namespace,
-			Name:       cluster.Name,
-			APIVersion: cluster.APIVersion,
-			Kind:       cluster.Kind,
-		})
 	}
 
 	return clusters, nil
 }
 
-// GetListOfClusters returns all existing Sveltos/CAPI Clusters.
+// GetListOfClusters returns all existing clusters.
 func GetListOfClusters(ctx context.Context, c client.Client, logger logr.Logger,
 ) ([]corev1.ObjectReference, error) {
 
-	clusters, err := getListOfCAPICluster(ctx, c, nil, logger)
-	if err != nil {
-		return nil, err
-	}
-
-	var tmpClusters []corev1.ObjectReference
-	tmpClusters, err = getListOfSveltosCluster(ctx, c, nil, logger)
-	if err != nil {
-		return nil, err
-	}
-
-	clusters = append(clusters, tmpClusters...)
-	return clusters, nil
+	return getListOfClusters(ctx, c, nil, logger)
 }
 
-// GetListOfClustersForShardKey returns all existing Sveltos/CAPI Clusters for a given shard
+// GetListOfClustersForShardKey returns all existing clusters for a given shard
 func GetListOfClustersForShardKey(ctx context.Context, c client.Client, shard string,
 	logger logr.Logger) ([]corev1.ObjectReference, error) {
 
-	clusters, err := getListOfCAPICluster(ctx, c, &shard, logger)
-	if err != nil {
-		return nil, err
-	}
-
-	var tmpClusters []corev1.ObjectReference
-	tmpClusters, err = getListOfSveltosCluster(ctx, c, &shard, logger)
-	if err != nil {
-		return nil, err
-	}
-
-	clusters = append(clusters, tmpClusters...)
-	return clusters, nil
-}
-
-func getMatchingCAPIClusters(ctx context.Context, c client.Client, selector labels.Selector,
-	logger logr.Logger) ([]corev1.ObjectReference, error) {
-
-	present, err := isCAPIPresent(ctx, c, logger)
-	if err != nil {
-		logger.Error(err, "failed to verify if ClusterAPI Cluster CRD is installed")
-		return nil, err
-	}
-
-	if !present {
-		return nil, nil
-	}
-
-	clusterList := &clusterv1.ClusterList{}
-	if err := c.List(ctx, clusterList); err != nil {
-		logger.Error(err, "failed to list all Cluster")
-		return nil, err
-	}
-
-	matching := make([]corev1.ObjectReference, 0)
-
-	for i := range clusterList.Items {
-		cluster := &clusterList.Items[i]
-
-		if !cluster.DeletionTimestamp.IsZero() {
-			// Only existing cluster can match
-			continue
-		}
-
-		addTypeInformationToObject(c.Scheme(), cluster)
-		if selector.Matches(labels.Set(cluster.Labels)) {
-			matching = append(matching, corev1.ObjectReference{
-				Kind:       cluster.Kind,
-				Namespace:  cluster.Namespace,
-				Name:       cluster.Name,
-				APIVersion: cluster.APIVersion,
-			})
-		}
-	}
-
-	return matching, nil
-}
-
-func getMatchingSveltosClusters(ctx context.Context, c client.Client, selector labels.Selector,
-	logger logr.Logger) ([]corev1.ObjectReference, error) {
-
-	clusterList := &libsveltosv1alpha1.SveltosClusterList{}
-	if err := c.List(ctx, clusterList); err != nil {
-		logger.Error(err, "failed to list all Cluster")
-		return nil, err
-	}
-
-	matching := make([]corev1.ObjectReference, 0)
-
-	for i := range clusterList.Items {
-		cluster := &clusterList.Items[i]
-
-		if !cluster.DeletionTimestamp.IsZero() {
-			// Only existing cluster can match
-			continue
-		}
-
-		addTypeInformationToObject(c.Scheme(), cluster)
-		if selector.Matches(labels.Set(cluster.Labels)) {
-			matching = append(matching, corev1.ObjectReference{
-				Kind:       cluster.Kind,
-				Namespace:  cluster.Namespace,
-				Name:       cluster.Name,
-				APIVersion: cluster.APIVersion,
-			})
-		}
-	}
-
-	return matching, nil
+	return getListOfClusters(ctx, c, &shard, logger)
 }
 
-// GetMatchingClusters returns all Sveltos/CAPI Clusters currently matching selector
+// GetMatchingClusters returns all clusters currently matching selector.
+// Every call lists all clusters. Components evaluating selectors frequently should use a ClusterIndex.
+// Use GetMatchingClustersWithSelector to evaluate a ClusterSelector.
 func GetMatchingClusters(ctx context.Context, c client.Client, selector labels.Selector,
 	logger logr.Logger) ([]corev1.ObjectReference, error) {
 
 	matching := make([]corev1.ObjectReference, 0)
 
-	tmpMatching, err := getMatchingCAPIClusters(ctx, c, selector, logger)
-	if err != nil {
-		return nil, err
-	}
-
-	matching = append(matching, tmpMatching...)
-
-	tmpMatching, err = getMatchingSveltosClusters(ctx, c, selector, logger)
-	if err != nil {
-		return nil, err
-	}
-
-	matching = append(matching, tmpMatching...)
-
-	return matching, nil
-}
-
-// isCAPIPresent returns whether clusterAPI CRDs are present.
-// This is checked only once. In a situation where clusterAPI is not originally
-// present and installed later on, all sveltos components that depend on that
-// are restarted.
-func isCAPIPresent(ctx context.Context, c client.Client, logger logr.Logger) (bool, error) {
-	checked := atomic.LoadInt32(&checkedCAPIPresence)
-	if checked == 0 {
-		clusterCRD := &apiextensionsv1.CustomResourceDefinition{}
-		err := c.Get(ctx,
-			types.NamespacedName{Name: "clusters.cluster.x-k8s.io"}, clusterCRD)
+	for _, provider := range getClusterProviders() {
+		providerClusters, err := provider.ListClusters(ctx, c, logger)
 		if err != nil {
-			if apierrors.IsNotFound(err) {
-				logger.V(logsettings.LogDebug).Info("clusterCRD CRD not present")
-				atomic.StoreInt32(&checkedCAPIPresence, 1)
-				atomic.StoreInt32(&capiPresent, 0)
-				return false, nil
+			return nil, err
+		}
+
+		for i := range providerClusters {
+			cluster := providerClusters[i]
+			if selector.Matches(labels.Set(cluster.GetLabels())) {
+				matching = append(matching, getObjectReference(provider, cluster))
 			}
-			return false, err
 		}
-		atomic.StoreInt32(&checkedCAPIPresence, 1)
-		atomic.StoreInt32(&capiPresent, 1)
 	}
 
-	present := atomic.LoadInt32(&capiPresent)
-	return present != 0, nil
+	return matching, nil
 }
@@ -18,6 +18,7 @@ package clusterproxy_test
 
 import (
 	"context"
+	"errors"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -258,7 +259,10 @@ var _ = Describe("Cluster utils", func() {
 
 		cluster.Labels = currentLabels
 
+		clusterCRD := external.TestClusterCRD.DeepCopy()
+
 		initObjects := []client.Object{
+			clusterCRD,
 			cluster,
 			sveltosCluster,
 			nonMatchingSveltosCluster,
@@ -278,4 +282,177 @@ var _ = Describe("Cluster utils", func() {
 			corev1.ObjectReference{Namespace: sveltosCluster.Namespace, Name: sveltosCluster.Name,
 				Kind: libsveltosv1alpha1.SveltosClusterKind, APIVersion: libsveltosv1alpha1.GroupVersion.String()}))
 	})
+
+	It("GetMatchingClusters supports gt and lt operators", func() {
+		cluster.Labels = map[string]string{"version": "3"}
+		sveltosCluster.Labels = map[string]string{"version": "1"}
+
+		clusterCRD := external.TestClusterCRD.DeepCopy()
+		initObjects := []client.Object{clusterCRD, cluster, sveltosCluster}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		parsedSelector, err := labels.Parse("version>2")
+		Expect(err).To(BeNil())
+
+		matches, err := clusterproxy.GetMatchingClusters(context.TODO(), c, parsedSelector, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(matches).To(ConsistOf(
+			corev1.ObjectReference{Namespace: cluster.Namespace, Name: cluster.Name,
+				Kind: "Cluster", APIVersion: clusterv1.GroupVersion.String()}))
+
+		// Converted ClusterSelector keeps gt semantics
+		clusterSelector, err := clusterproxy.ParseClusterSelector("version>2")
+		Expect(err).To(BeNil())
+		matches, err = clusterproxy.GetMatchingClustersWithSelector(context.TODO(), c, clusterSelector, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(matches).To(ConsistOf(
+			corev1.ObjectReference{Namespace: cluster.Namespace, Name: cluster.Name,
+				Kind: "Cluster", APIVersion: clusterv1.GroupVersion.String()}))
+
+		// Combined with other criteria, all must match
+		clusterSelector.MatchLabels = map[string]string{"env": "prod"}
+		matches, err = clusterproxy.GetMatchingClustersWithSelector(context.TODO(), c, clusterSelector, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(matches).To(BeEmpty())
+
+		_, err = clusterproxy.ParseClusterSelector("version>")
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("ParseClusterSelector keeps empty selector selecting all clusters", func() {
+		clusterCRD := external.TestClusterCRD.DeepCopy()
+		initObjects := []client.Object{clusterCRD, cluster, sveltosCluster}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		clusterSelector, err := clusterproxy.ParseClusterSelector("")
+		Expect(err).To(BeNil())
+		matches, err := clusterproxy.GetMatchingClustersWithSelector(context.TODO(), c, clusterSelector, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(matches).To(ConsistOf(
+			corev1.ObjectReference{Namespace: cluster.Namespace, Name: cluster.Name,
+				Kind: "Cluster", APIVersion: clusterv1.GroupVersion.String()},
+			corev1.ObjectReference{Namespace: sveltosCluster.Namespace, Name: sveltosCluster.Name,
+				Kind: libsveltosv1alpha1.SveltosClusterKind, APIVersion: libsveltosv1alpha1.GroupVersion.String()}))
+
+		// Selector supported by LabelSelector is converted
+		clusterSelector, err = clusterproxy.ParseClusterSelector("env=qa")
+		Expect(err).To(BeNil())
+		Expect(clusterSelector.Selector).To(BeNil())
+		Expect(clusterSelector.MatchLabels).To(Equal(map[string]string{"env": "qa"}))
+	})
+
+	It("GetMatchingClustersWithSelector returns clusters selected by ClusterSelector", func() {
+		cluster.Labels = map[string]string{"env": "qa"}
+		cluster.Annotations = map[string]string{"team": "a"}
+		sveltosCluster.Labels = map[string]string{"env": "qa"}
+
+		otherNamespaceCluster := &libsveltosv1alpha1.SveltosCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      randomString(),
+				Namespace: randomString(),
+				Labels:    map[string]string{"env": "qa"},
+			},
+		}
+
+		clusterCRD := external.TestClusterCRD.DeepCopy()
+		initObjects := []client.Object{clusterCRD, cluster, sveltosCluster, otherNamespaceCluster}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		clusterRef := corev1.ObjectReference{Namespace: cluster.Namespace, Name: cluster.Name,
+			Kind: "Cluster", APIVersion: clusterv1.GroupVersion.String()}
+		sveltosClusterRef := corev1.ObjectReference{Namespace: sveltosCluster.Namespace, Name: sveltosCluster.Name,
+			Kind: libsveltosv1alpha1.SveltosClusterKind, APIVersion: libsveltosv1alpha1.GroupVersion.String()}
+		otherNamespaceClusterRef := corev1.ObjectReference{Namespace: otherNamespaceCluster.Namespace,
+			Name: otherNamespaceCluster.Name, Kind: libsveltosv1alpha1.SveltosClusterKind,
+			APIVersion: libsveltosv1alpha1.GroupVersion.String()}
+
+		clusterSelector := &libsveltosv1alpha1.ClusterSelector{
+			LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{"env": "qa"}},
+		}
+		matches, err := clusterproxy.GetMatchingClustersWithSelector(context.TODO(), c, clusterSelector, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(matches).To(ConsistOf(clusterRef, sveltosClusterRef, otherNamespaceClusterRef))
+
+		clusterSelector.Namespaces = []string{namespace}
+		matches, err = clusterproxy.GetMatchingClustersWithSelector(context.TODO(), c, clusterSelector, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(matches).To(ConsistOf(clusterRef, sveltosClusterRef))
+
+		clusterSelector.ClusterTypes = []libsveltosv1alpha1.ClusterType{libsveltosv1alpha1.ClusterTypeSveltos}
+		matches, err = clusterproxy.GetMatchingClustersWithSelector(context.TODO(), c, clusterSelector, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(matches).To(ConsistOf(sveltosClusterRef))
+
+		clusterSelector = &libsveltosv1alpha1.ClusterSelector{
+			MatchAnnotations: map[string]string{"team": "a"},
+		}
+		matches, err = clusterproxy.GetMatchingClustersWithSelector(context.TODO(), c, clusterSelector, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(matches).To(ConsistOf(clusterRef))
+
+		// ClusterSelector with no criteria only selects clusters listed in ClusterRefs
+		clusterSelector = &libsveltosv1alpha1.ClusterSelector{}
+		matches, err = clusterproxy.GetMatchingClustersWithSelector(context.TODO(), c, clusterSelector, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(matches).To(BeEmpty())
+
+		clusterSelector.ClusterRefs = []corev1.ObjectReference{otherNamespaceClusterRef}
+		matches, err = clusterproxy.GetMatchingClustersWithSelector(context.TODO(), c, clusterSelector, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(matches).To(ConsistOf(otherNamespaceClusterRef))
+	})
+	It("GetClusterType returns ErrUnknownClusterType for unknown clusters", func() {
+		clusterType, err := clusterproxy.GetClusterType(&corev1.ObjectReference{
+			Namespace: cluster.Namespace, Name: cluster.Name,
+			Kind: "Cluster", APIVersion: clusterv1.GroupVersion.String()})
+		Expect(err).To(BeNil())
+		Expect(clusterType).To(Equal(libsveltosv1alpha1.ClusterTypeCapi))
+
+		clusterType, err = clusterproxy.GetClusterType(&corev1.ObjectReference{
+			Namespace: sveltosCluster.Namespace, Name: sveltosCluster.Name,
+			Kind: libsveltosv1alpha1.SveltosClusterKind, APIVersion: libsveltosv1alpha1.GroupVersion.String()})
+		Expect(err).To(BeNil())
+		Expect(clusterType).To(Equal(libsveltosv1alpha1.ClusterTypeSveltos))
+
+		// Any version of ClusterAPI Cluster is a CAPI cluster
+		clusterType, err = clusterproxy.GetClusterType(&corev1.ObjectReference{
+			Namespace: cluster.Namespace, Name: cluster.Name,
+			Kind: "Cluster", APIVersion: "cluster.x-k8s.io/v1alpha4"})
+		Expect(err).To(BeNil())
+		Expect(clusterType).To(Equal(libsveltosv1alpha1.ClusterTypeCapi))
+
+		malformedRefs := []corev1.ObjectReference{
+			{Namespace: cluster.Namespace, Name: cluster.Name},
+			{Namespace: cluster.Namespace, Name: cluster.Name, Kind: "Cluster", APIVersion: "cluster.x-k8s.io"},
+			{Namespace: cluster.Namespace, Name: cluster.Name, Kind: "Cluster", APIVersion: randomString()},
+			{Namespace: cluster.Namespace, Name: cluster.Name, Kind: randomString(), APIVersion: "cluster.x-k8s.io/v1beta1"},
+		}
+
+		for i := range malformedRefs {
+			_, err = clusterproxy.GetClusterType(&malformedRefs[i])
+			Expect(err).ToNot(BeNil())
+			Expect(errors.Is(err, clusterproxy.ErrUnknownClusterType)).To(BeTrue())
+		}
+	})
+
+	It("unknown cluster types return ErrUnknownClusterType", func() {
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cluster).Build()
+
+		unknownType := libsveltosv1alpha1.ClusterType(randomString())
+
+		_, err := clusterproxy.GetCluster(context.TODO(), c, cluster.Namespace, cluster.Name, unknownType)
+		Expect(errors.Is(err, clusterproxy.ErrUnknownClusterType)).To(BeTrue())
+
+		_, err = clusterproxy.IsClusterPaused(context.TODO(), c, cluster.Namespace, cluster.Name, unknownType)
+		Expect(errors.Is(err, clusterproxy.ErrUnknownClusterType)).To(BeTrue())
+
+		_, err = clusterproxy.GetKubernetesRestConfig(context.TODO(), c, cluster.Namespace, cluster.Name,
+			"", "", unknownType, klogr.New())
+		Expect(errors.Is(err, clusterproxy.ErrUnknownClusterType)).To(BeTrue())
+
+		_, err = clusterproxy.IsClusterReadyToBeConfigured(context.TODO(), c,
+			&corev1.ObjectReference{Namespace: cluster.Namespace, Name: cluster.Name, Kind: randomString()},
+			klogr.New())
+		Expect(errors.Is(err, clusterproxy.ErrUnknownClusterType)).To(BeTrue())
+	})
 })
@@ -44,6 +44,12 @@ const (
 	sveltosKubeconfigSecretNamePostfix = "-sveltos-kubeconfig"
 )
 
+var (
+	// kubeconfigSecretKeys contains, in order of preference, the keys a Secret
+	// can use to store a kubeconfig
+	kubeconfigSecretKeys = []string{"value", "kubeconfig"}
+)
+
 // GetCAPIKubernetesRestConfig returns rest.Config for a CAPI Cluster clusterNamespace/clusterName
 // c is the client to access management cluster
 func GetCAPIKubernetesRestConfig(ctx context.Context, logger logr.Logger, c client.Client,
@@ -54,19 +60,7 @@ func GetCAPIKubernetesRestConfig(ctx context.Context, logger logr.Logger, c clie
 		return nil, err
 	}
 
-	kubeconfig, err := CreateKubeconfig(logger, kubeconfigContent)
-	if err != nil {
-		return nil, err
-	}
-	defer os.Remove(kubeconfig)
-
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-	if err != nil {
-		logger.Error(err, "BuildConfigFromFlags")
-		return nil, errors.Wrap(err, "BuildConfigFromFlags")
-	}
-
-	return config, nil
+	return GetRestConfigFromKubeconfig(logger, kubeconfigContent, "")
 }
 
 // GetCAPIKubernetesClient returns a client to access CAPI Cluster clusterNamespace/clusterName
@@ -87,6 +81,19 @@ func GetCAPIKubernetesClient(ctx context.Context, logger logr.Logger, c client.C
 func GetCAPISecretData(ctx context.Context, logger logr.Logger, c client.Client,
 	clusterNamespace, clusterName string) ([]byte, error) {
 
+	secret, err := getCAPIKubeconfigSecret(ctx, logger, c, clusterNamespace, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	return getKubeconfigFromSecret(logger, secret, "")
+}
+
+// getCAPIKubeconfigSecret verifies Cluster exists and returns the secret containing
+// the kubeconfig for CAPI cluster
+func getCAPIKubeconfigSecret(ctx context.Context, logger logr.Logger, c client.Client,
+	clusterNamespace, clusterName string) (*corev1.Secret, error) {
+
 	logger.WithValues("namespace", clusterNamespace, "cluster", clusterName)
 	logger.V(logs.LogVerbose).Info("Get secret")
 	key := client.ObjectKey{
@@ -109,7 +116,7 @@ func GetCAPISecretData(ctx context.Context, logger logr.Logger, c client.Client,
 
 	secretName := fmt.Sprintf("%s%s", cluster.Name, capiKubeconfigSecretNamePostfix)
 
-	return getSecretData(ctx, logger, c, clusterNamespace, secretName)
+	return getSecret(ctx, logger, c, clusterNamespace, secretName)
 }
 
 // GetSveltosKubernetesRestConfig returns rest.Config for a Sveltos Cluster clusterNamespace/clusterName
@@ -117,24 +124,12 @@ func GetCAPISecretData(ctx context.Context, logger logr.Logger, c client.Client,
 func GetSveltosKubernetesRestConfig(ctx context.Context, logger logr.Logger, c client.Client,
 	clusterNamespace, clusterName string) (*rest.Config, error) {
 
-	kubeconfigContent, err := GetSveltosSecretData(ctx, logger, c, clusterNamespace, clusterName)
+	cluster, kubeconfigContent, err := getSveltosSecretData(ctx, logger, c, clusterNamespace, clusterName)
 	if err != nil {
 		return nil, err
 	}
 
-	kubeconfig, err := CreateKubeconfig(logger, kubeconfigContent)
-	if err != nil {
-		return nil, err
-	}
-	defer os.Remove(kubeconfig)
-
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-	if err != nil {
-		logger.Error(err, "BuildConfigFromFlags")
-		return nil, errors.Wrap(err, "BuildConfigFromFlags")
-	}
-
-	return config, nil
+	return GetRestConfigFromKubeconfig(logger, kubeconfigContent, cluster.Spec.KubeconfigContext)
 }
 
 // GetSveltosKubernetesClient returns a client to access Sveltos Cluster clusterNamespace/clusterName
@@ -155,6 +150,29 @@ func GetSveltosKubernetesClient(ctx context.Context, logger logr.Logger, c clien
 func GetSveltosSecretData(ctx context.Context, logger logr.Logger, c client.Client,
 	clusterNamespace, clusterName string) ([]byte, error) {
 
+	_, data, err := getSveltosSecretData(ctx, logger, c, clusterNamespace, clusterName)
+	return data, err
+}
+
+// getSveltosSecretData verifies Cluster exists and returns it along with the content of
+// secret containing the kubeconfig for Sveltos cluster
+func getSveltosSecretData(ctx context.Context, logger logr.Logger, c client.Client,
+	clusterNamespace, clusterName string) (*libsveltosv1alpha1.SveltosCluster, []byte, error) {
+
+	cluster, secret, err := getSveltosKubeconfigSecret(ctx, logger, c, clusterNamespace, clusterName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := getKubeconfigFromSecret(logger, secret, cluster.Spec.KubeconfigKeyName)
+	return cluster, data, err
+}
+
+// getSveltosKubeconfigSecret verifies Cluster exists and returns it along with the
+// secret containing the kubeconfig for Sveltos cluster
+func getSveltosKubeconfigSecret(ctx context.Context, logger logr.Logger, c client.Client,
+	clusterNamespace, clusterName string) (*libsveltosv1alpha1.SveltosCluster, *corev1.Secret, error) {
+
 	logger.WithValues("namespace", clusterNamespace, "cluster", clusterName)
 	logger.V(logs.LogVerbose).Info("Get secret")
 	key := client.ObjectKey{
@@ -162,17 +180,17 @@ func GetSveltosSecretData(ctx context.Context, logger logr.Logger, c client.Clie
 		Name:      clusterName,
 	}
 
-	cluster := libsveltosv1alpha1.SveltosCluster{}
-	if err := c.Get(ctx, key, &cluster); err != nil {
+	cluster := &libsveltosv1alpha1.SveltosCluster{}
+	if err := c.Get(ctx, key, cluster); err != nil {
 		if apierrors.IsNotFound(err) {
 			logger.Info("SveltosCluster does not exist")
-			return nil, errors.Wrap(err,
+			return nil, nil, errors.Wrap(err,
 				fmt.Sprintf("SveltosCluster %s/%s does not exist",
 					clusterNamespace,
 					clusterName,
 				))
 		}
-		return nil, err
+		return nil, nil, err
 	}
 
 	secretName := cluster.Spec.KubeconfigName
@@ -180,7 +198,8 @@ func GetSveltosSecretData(ctx context.Context, logger logr.Logger, c client.Clie
 		secretName = fmt.Sprintf("%s%s", cluster.Name, sveltosKubeconfigSecretNamePostfix)
 	}
 
-	return getSecretData(ctx, logger, c, clusterNamespace, secretName)
+	secret, err := getSecret(ctx, logger, c, clusterNamespace, secretName)
+	return cluster, secret, err
 }
 
 // IsClusterReadyToBeConfigured returns true if cluster is ready to be configured
@@ -189,11 +208,13 @@ func IsClusterReadyToBeConfigured(
 	cluster *corev1.ObjectReference, logger logr.Logger,
 ) (bool, error) {
 
-	if cluster.Kind == libsveltosv1alpha1.SveltosClusterKind {
-		return isSveltosClusterReadyToBeConfigured(ctx, c, cluster, logger)
+	provider := getClusterProviderForReference(cluster)
+	if provider == nil {
+		return false, fmt.Errorf("%w: apiVersion %q kind %q", ErrUnknownClusterType,
+			cluster.APIVersion, cluster.Kind)
 	}
 
-	return isCAPIClusterReadyToBeConfigured(ctx, c, cluster, logger)
+	return provider.IsClusterReadyToBeConfigured(ctx, c, cluster.Namespace, cluster.Name, logger)
 }
 
 // isSveltosClusterReadyToBeConfigured  returns true if SveltosCluster
@@ -213,31 +234,24 @@ func isSveltosClusterReadyToBeConfigured(
 	return sveltosCluster.Status.Ready, nil
 }
 
-// isCAPIClusterReadyToBeConfigured checks whether Cluster:
-// - ControlPlaneInitialized condition is set to true on Cluster object or
-// - Status.ControlPlaneReady is set to true
+// isCAPIClusterReadyToBeConfigured checks whether Cluster is ready according to
+// current CAPIReadinessPolicy
 func isCAPIClusterReadyToBeConfigured(
 	ctx context.Context, c client.Client,
 	cluster *corev1.ObjectReference, logger logr.Logger,
 ) (bool, error) {
 
-	capiCluster := &clusterv1.Cluster{}
-	err := c.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: cluster.Name}, capiCluster)
+	policy := GetCAPIReadinessPolicy()
+	readiness, err := EvaluateCAPIClusterReadiness(ctx, c, cluster, &policy, logger)
 	if err != nil {
-		logger.Info(fmt.Sprintf("Failed to get Cluster %v", err))
 		return false, err
 	}
 
-	for i := range capiCluster.Status.Conditions {
-		c := capiCluster.Status.Conditions[i]
-		if c.Type == clusterv1.ControlPlaneInitializedCondition &&
-			c.Status == corev1.ConditionTrue {
-
-			return true, nil
-		}
+	if !readiness.Ready {
+		logger.V(logs.LogDebug).Info(fmt.Sprintf("cluster %s/%s not ready: %s (%s)",
+			cluster.Namespace, cluster.Name, readiness.Reason, readiness.Message))
 	}
-
-	return capiCluster.Status.ControlPlaneReady, nil
+	return readiness.Ready, nil
 }
 
 // GetMachinesForCluster find all Machines for a given CAPI Cluster.
@@ -261,6 +275,42 @@ func GetMachinesForCluster(
 	return &machineList, nil
 }
 
+// GetRestConfigFromKubeconfig returns rest.Config built from kubeconfigContent.
+// No file is written on disk.
+// kubeconfigContext is the name of the context to use. If empty, kubeconfig current-context
+// is used.
+func GetRestConfigFromKubeconfig(logger logr.Logger, kubeconfigContent []byte,
+	kubeconfigContext string) (*rest.Config, error) {
+
+	if kubeconfigContext == "" {
+		config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfigContent)
+		if err != nil {
+			logger.Error(err, "RESTConfigFromKubeConfig")
+			return nil, errors.Wrap(err, "RESTConfigFromKubeConfig")
+		}
+		return config, nil
+	}
+
+	apiConfig, err := clientcmd.Load(kubeconfigContent)
+	if err != nil {
+		logger.Error(err, "failed to load kubeconfig")
+		return nil, errors.Wrap(err, "failed to load kubeconfig")
+	}
+
+	if _, ok := apiConfig.Contexts[kubeconfigContext]; !ok {
+		return nil, fmt.Errorf("context %s not found in kubeconfig", kubeconfigContext)
+	}
+
+	config, err := clientcmd.NewNonInteractiveClientConfig(*apiConfig, kubeconfigContext,
+		&clientcmd.ConfigOverrides{}, nil).ClientConfig()
+	if err != nil {
+		logger.Error(err, "failed to get rest.Config", "context", kubeconfigContext)
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to get rest.Config for context %s", kubeconfigContext))
+	}
+
+	return config, nil
+}
+
 // CreateKubeconfig creates a temporary file with the Kubeconfig to access CAPI cluster
 func CreateKubeconfig(logger logr.Logger, kubeconfigContent []byte) (string, error) {
 	tmpfile, err := os.CreateTemp("", "kubeconfig")
@@ -278,8 +328,8 @@ func CreateKubeconfig(logger logr.Logger, kubeconfigContent []byte) (string, err
 	return tmpfile.Name(), nil
 }
 
-func getSecretData(ctx context.Context, logger logr.Logger, c client.Client,
-	clusterNamespace, secretName string) ([]byte, error) {
+func getSecret(ctx context.Context, logger logr.Logger, c client.Client,
+	clusterNamespace, secretName string) (*corev1.Secret, error) {
 
 	logger = logger.WithValues("secret", secretName)
 
@@ -296,10 +346,65 @@ func getSecretData(ctx context.Context, logger logr.Logger, c client.Client,
 				clusterNamespace, secretName))
 	}
 
-	for k, contents := range secret.Data {
-		logger.V(logs.LogVerbose).Info("Reading secret", "key", k)
-		return contents, nil
+	return secret, nil
+}
+
+// getKubeconfigFromSecret returns the kubeconfig contained in secret.
+// Keys in kubeconfigSecretKeys are looked up first, then keyName (if set).
+// See getKubeconfigSecretKey.
+func getKubeconfigFromSecret(logger logr.Logger, secret *corev1.Secret, keyName string) ([]byte, error) {
+	key, err := getKubeconfigSecretKey(secret, keyName)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	logger.V(logs.LogVerbose).Info("Reading secret", "key", key)
+	return secret.Data[key], nil
+}
+
+// getKubeconfigSecretKey returns the key, within secret, containing the kubeconfig.
+// Lookup order is:
+// - keyName, if set. An error is returned if secret does not contain it;
+// - keys in kubeconfigSecretKeys. An error is returned if more than one is present;
+// - the only key in secret.
+// Returns an empty key if secret has no data.
+func getKubeconfigSecretKey(secret *corev1.Secret, keyName string) (string, error) {
+	if keyName != "" {
+		if _, ok := secret.Data[keyName]; !ok {
+			return "", fmt.Errorf("secret %s/%s does not contain key %s",
+				secret.Namespace, secret.Name, keyName)
+		}
+		return keyName, nil
+	}
+
+	found := make([]string, 0, len(kubeconfigSecretKeys))
+	for _, k := range kubeconfigSecretKeys {
+		if _, ok := secret.Data[k]; ok {
+			found = append(found, k)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("secret %s/%s contains more than one of %v keys. Cannot determine which one contains the kubeconfig",
+			secret.Namespace, secret.Name, found)
+	}
+
+	switch len(secret.Data) {
+	case 0:
+		return "", nil
+	case 1:
+		for k := range secret.Data {
+			return k, nil
+		}
 	}
 
-	return nil, nil
+	return "", fmt.Errorf("secret %s/%s contains %d keys and none of %v. Cannot determine which one contains the kubeconfig",
+		secret.Namespace, secret.Name, len(secret.Data), kubeconfigSecretKeys)
 }
@@ -42,6 +42,32 @@ import (
 
 const (
 	upstreamClusterNamePrefix = "upstream-cluster"
+
+	multiContextKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: cluster-a
+  cluster:
+    server: https://cluster-a:6443
+    insecure-skip-tls-verify: true
+- name: cluster-b
+  cluster:
+    server: https://cluster-b:6443
+    insecure-skip-tls-verify: true
+users:
+- name: admin
+  user:
+    token: %s
+contexts:
+- name: context-a
+  context:
+    cluster: cluster-a
+    user: admin
+- name: context-b
+  context:
+    cluster: cluster-b
+    user: admin
+current-context: context-a`
 )
 
 func setupScheme() (*runtime.Scheme, error) {
@@ -361,4 +387,143 @@ var _ = Describe("clusterproxy ", func() {
 		Expect(err).To(BeNil())
 		Expect(ready).To(Equal(false))
 	})
+
+	It("GetRestConfigFromKubeconfig returns rest.Config for requested context", func() {
+		kubeconfig := []byte(fmt.Sprintf(multiContextKubeconfig, randomString()))
+
+		config, err := clusterproxy.GetRestConfigFromKubeconfig(logger, kubeconfig, "")
+		Expect(err).To(BeNil())
+		Expect(config.Host).To(Equal("https://cluster-a:6443"))
+
+		config, err = clusterproxy.GetRestConfigFromKubeconfig(logger, kubeconfig, "context-b")
+		Expect(err).To(BeNil())
+		Expect(config.Host).To(Equal("https://cluster-b:6443"))
+
+		_, err = clusterproxy.GetRestConfigFromKubeconfig(logger, kubeconfig, randomString())
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("GetSveltosKubernetesRestConfig uses SveltosCluster KubeconfigContext", func() {
+		sveltosCluster.Spec.KubeconfigContext = "context-b"
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: sveltosCluster.Namespace,
+				Name:      sveltosCluster.Name + clusterproxy.SveltosKubeconfigSecretNamePostfix,
+			},
+			Data: map[string][]byte{
+				"value": []byte(fmt.Sprintf(multiContextKubeconfig, randomString())),
+			},
+		}
+
+		initObjects := []client.Object{
+			sveltosCluster,
+			secret,
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		config, err := clusterproxy.GetSveltosKubernetesRestConfig(context.TODO(), logger, c,
+			sveltosCluster.Namespace, sveltosCluster.Name)
+		Expect(err).To(BeNil())
+		Expect(config.Host).To(Equal("https://cluster-b:6443"))
+	})
+
+	It("GetCAPISecretData returns an error when Secret contains both value and kubeconfig keys", func() {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: cluster.Namespace,
+				Name:      cluster.Name + clusterproxy.CapiKubeconfigSecretNamePostfix,
+			},
+			Data: map[string][]byte{
+				"kubeconfig": []byte(randomString()),
+				"value":      []byte(randomString()),
+			},
+		}
+
+		initObjects := []client.Object{
+			cluster,
+			secret,
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		_, err := clusterproxy.GetCAPISecretData(context.TODO(), logger, c, cluster.Namespace, cluster.Name)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("Cannot determine which one contains the kubeconfig"))
+	})
+
+	It("GetSveltosSecretData returns an error when Secret has more than one candidate key", func() {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: sveltosCluster.Namespace,
+				Name:      sveltosCluster.Name + clusterproxy.SveltosKubeconfigSecretNamePostfix,
+			},
+			Data: map[string][]byte{
+				randomString(): []byte(randomString()),
+				randomString(): []byte(randomString()),
+			},
+		}
+
+		initObjects := []client.Object{
+			sveltosCluster,
+			secret,
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		_, err := clusterproxy.GetSveltosSecretData(context.TODO(), logger, c,
+			sveltosCluster.Namespace, sveltosCluster.Name)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("Cannot determine which one contains the kubeconfig"))
+	})
+
+	It("GetSveltosSecretData reads SveltosCluster KubeconfigKeyName key", func() {
+		keyName := randomString()
+		data := []byte(randomString())
+		sveltosCluster.Spec.KubeconfigKeyName = keyName
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: sveltosCluster.Namespace,
+				Name:      sveltosCluster.Name + clusterproxy.SveltosKubeconfigSecretNamePostfix,
+			},
+			Data: map[string][]byte{
+				randomString(): []byte(randomString()),
+				keyName:        data,
+			},
+		}
+
+		initObjects := []client.Object{
+			sveltosCluster,
+			secret,
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		currentData, err := clusterproxy.GetSveltosSecretData(context.TODO(), logger, c,
+			sveltosCluster.Namespace, sveltosCluster.Name)
+		Expect(err).To(BeNil())
+		Expect(currentData).To(Equal(data))
+
+		// KubeconfigKeyName takes precedence over value and kubeconfig keys
+		currentSecret := &corev1.Secret{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name},
+			currentSecret)).To(Succeed())
+		currentSecret.Data["value"] = []byte(randomString())
+		currentSecret.Data["kubeconfig"] = []byte(randomString())
+		Expect(c.Update(context.TODO(), currentSecret)).To(Succeed())
+
+		currentData, err = clusterproxy.GetSveltosSecretData(context.TODO(), logger, c,
+			sveltosCluster.Namespace, sveltosCluster.Name)
+		Expect(err).To(BeNil())
+		Expect(currentData).To(Equal(data))
+
+		// Key does not exist
+		sveltosCluster.Spec.KubeconfigKeyName = randomString()
+		Expect(c.Update(context.TODO(), sveltosCluster)).To(Succeed())
+		_, err = clusterproxy.GetSveltosSecretData(context.TODO(), logger, c,
+			sveltosCluster.Namespace, sveltosCluster.Name)
+		Expect(err).ToNot(BeNil())
+	})
 })
@@ -0,0 +1,123 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+	"github.com/projectsveltos/libsveltos/lib/utils"
+)
+
+const (
+	readyzPath = "/readyz"
+)
+
+// ProbeResult is the result of a connectivity probe
+type ProbeResult struct {
+	// Time is when the probe was run
+	Time time.Time
+
+	// Version is the cluster Kubernetes version. Set only if probe succeeded.
+	Version string
+
+	// Latency is the time it took for the API server /readyz endpoint to answer.
+	// Set only if probe succeeded.
+	Latency time.Duration
+
+	// Err is set if probe failed
+	Err error
+}
+
+// ProbeCluster verifies the cluster accessed via config is reachable: API server /readyz
+// endpoint must report ready and server version must be retrievable.
+func ProbeCluster(ctx context.Context, config *rest.Config, logger logr.Logger) *ProbeResult {
+	result := &ProbeResult{Time: time.Now()}
+
+	discClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	start := time.Now()
+	body, err := discClient.RESTClient().Get().AbsPath(readyzPath).DoRaw(ctx)
+	if err != nil {
+		logger.V(logs.LogDebug).Info(fmt.Sprintf("%s failed: %v (%s)", readyzPath, err, string(body)))
+		result.Err = fmt.Errorf("%s failed: %w", readyzPath, err)
+		return result
+	}
+	latency := time.Since(start)
+
+	version, err := utils.GetKubernetesVersion(ctx, config, logger)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to get server version: %w", err)
+		return result
+	}
+
+	result.Version = version
+	result.Latency = latency
+	return result
+}
+
+// ProbeSveltosCluster verifies SveltosCluster is reachable using its kubeconfig.
+// Failing to get the kubeconfig is reported as a failed probe.
+func ProbeSveltosCluster(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
+	logger logr.Logger) *ProbeResult {
+
+	config, err := GetSveltosKubernetesRestConfig(ctx, logger, c, clusterNamespace, clusterName)
+	if err != nil {
+		return &ProbeResult{Time: time.Now(), Err: fmt.Errorf("failed to get kubeconfig: %w", err)}
+	}
+
+	return ProbeCluster(ctx, config, logger)
+}
+
+// UpdateSveltosClusterStatus updates status with the outcome of a probe.
+// On success, cluster is marked ready and version, last successful connection and latency
+// are updated. On failure, consecutive failures are incremented and failure message is set;
+// cluster is marked not ready once consecutive failures reach failureThreshold
+// (a failureThreshold of zero or one marks it not ready at first failure).
+func UpdateSveltosClusterStatus(status *libsveltosv1alpha1.SveltosClusterStatus, result *ProbeResult,
+	failureThreshold int32) {
+
+	if result.Err == nil {
+		status.Ready = true
+		status.Version = result.Version
+		status.FailureMessage = nil
+		status.ConsecutiveFailures = 0
+		status.LastSuccessfulConnection = &metav1.Time{Time: result.Time}
+		status.ConnectionLatency = &metav1.Duration{Duration: result.Latency}
+		return
+	}
+
+	status.ConsecutiveFailures++
+	failureMessage := result.Err.Error()
+	status.FailureMessage = &failureMessage
+	if status.ConsecutiveFailures >= failureThreshold {
+		status.Ready = false
+	}
+}
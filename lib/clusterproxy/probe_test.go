@@ -0,0 +1,116 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+)
+
+var _ = Describe("Probe", func() {
+	It("ProbeCluster succeeds for a reachable cluster and fails otherwise", func() {
+		result := clusterproxy.ProbeCluster(context.TODO(), testEnv.Config, klogr.New())
+		Expect(result.Err).To(BeNil())
+		Expect(result.Version).ToNot(BeEmpty())
+		Expect(result.Latency).To(BeNumerically(">", 0))
+
+		unreachable := rest.CopyConfig(testEnv.Config)
+		unreachable.Host = "https://unreachable.invalid:6443"
+		unreachable.Timeout = time.Second
+		result = clusterproxy.ProbeCluster(context.TODO(), unreachable, klogr.New())
+		Expect(result.Err).ToNot(BeNil())
+		Expect(result.Version).To(BeEmpty())
+	})
+
+	It("ProbeSveltosCluster uses SveltosCluster kubeconfig", func() {
+		sveltosCluster := &libsveltosv1alpha1.SveltosCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+			},
+		}
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: sveltosCluster.Namespace,
+				Name:      sveltosCluster.Name + clusterproxy.SveltosKubeconfigSecretNamePostfix,
+			},
+			Data: map[string][]byte{
+				"value": testEnv.Kubeconfig,
+			},
+		}
+
+		initObjects := []client.Object{sveltosCluster, secret}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		result := clusterproxy.ProbeSveltosCluster(context.TODO(), c, sveltosCluster.Namespace,
+			sveltosCluster.Name, klogr.New())
+		Expect(result.Err).To(BeNil())
+		Expect(result.Version).ToNot(BeEmpty())
+
+		result = clusterproxy.ProbeSveltosCluster(context.TODO(), c, sveltosCluster.Namespace,
+			randomString(), klogr.New())
+		Expect(result.Err).ToNot(BeNil())
+	})
+
+	It("UpdateSveltosClusterStatus tracks successes and consecutive failures", func() {
+		status := &libsveltosv1alpha1.SveltosClusterStatus{}
+
+		now := time.Now()
+		clusterproxy.UpdateSveltosClusterStatus(status,
+			&clusterproxy.ProbeResult{Time: now, Version: "v1.28.4", Latency: time.Millisecond}, 2)
+		Expect(status.Ready).To(BeTrue())
+		Expect(status.Version).To(Equal("v1.28.4"))
+		Expect(status.LastSuccessfulConnection.Time).To(Equal(now))
+		Expect(status.ConnectionLatency.Duration).To(Equal(time.Millisecond))
+		Expect(status.ConsecutiveFailures).To(BeZero())
+
+		probeErr := errors.New("connection refused")
+		clusterproxy.UpdateSveltosClusterStatus(status,
+			&clusterproxy.ProbeResult{Time: now.Add(time.Minute), Err: probeErr}, 2)
+		Expect(status.ConsecutiveFailures).To(Equal(int32(1)))
+		Expect(*status.FailureMessage).To(Equal(probeErr.Error()))
+		// failure threshold not reached yet
+		Expect(status.Ready).To(BeTrue())
+		Expect(status.LastSuccessfulConnection.Time).To(Equal(now))
+
+		clusterproxy.UpdateSveltosClusterStatus(status,
+			&clusterproxy.ProbeResult{Time: now.Add(2 * time.Minute), Err: probeErr}, 2)
+		Expect(status.ConsecutiveFailures).To(Equal(int32(2)))
+		Expect(status.Ready).To(BeFalse())
+
+		clusterproxy.UpdateSveltosClusterStatus(status,
+			&clusterproxy.ProbeResult{Time: now.Add(3 * time.Minute), Version: "v1.28.4"}, 2)
+		Expect(status.Ready).To(BeTrue())
+		Expect(status.ConsecutiveFailures).To(BeZero())
+		Expect(status.FailureMessage).To(BeNil())
+	})
+})
@@ -0,0 +1,199 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+)
+
+// ClusterProfiles, RoleRequests, ClusterHealthChecks, AddonCompliances (and so on)
+// all select clusters via a Selector. SelectorIndex keeps track, per cluster, of the
+// owners (the resources defining the Selector) currently matching it.
+// Every time a cluster starts or stops matching an owner Selector (for instance because
+// cluster labels changed) an event is emitted, so that controllers can reconcile only the
+// affected owners.
+
+// SelectorEventType is the type of a SelectorEvent
+type SelectorEventType string
+
+const (
+	// SelectorEventAdded indicates a cluster started matching an owner Selector
+	SelectorEventAdded = SelectorEventType("Added")
+
+	// SelectorEventRemoved indicates a cluster stopped matching an owner Selector
+	SelectorEventRemoved = SelectorEventType("Removed")
+)
+
+// SelectorEvent is emitted when a cluster starts or stops matching an owner Selector
+type SelectorEvent struct {
+	Type    SelectorEventType
+	Cluster corev1.ObjectReference
+	Owner   corev1.ObjectReference
+}
+
+// SelectorEventHandler is invoked for every SelectorEvent
+type SelectorEventHandler func(event *SelectorEvent)
+
+// SelectorIndex indexes clusters by matching owner
+type SelectorIndex struct {
+	index *ClusterIndex
+
+	mu *sync.RWMutex
+
+	// owners contains, per ClusterIndex selector key, the corresponding owner
+	owners map[string]corev1.ObjectReference
+
+	// selectors contains, per ClusterIndex selector key, the owner selector
+	selectors map[string]labels.Selector
+
+	handlers []SelectorEventHandler
+}
+
+// NewSelectorIndex returns a SelectorIndex using index to evaluate Selectors.
+func NewSelectorIndex(index *ClusterIndex) *SelectorIndex {
+	si := &SelectorIndex{
+		index:     index,
+		mu:        &sync.RWMutex{},
+		owners:    make(map[string]corev1.ObjectReference),
+		selectors: make(map[string]labels.Selector),
+	}
+
+	index.AddMatchChangeHandler(si.onMatchChange)
+	return si
+}
+
+// AddEventHandler registers a handler invoked for every SelectorEvent
+func (si *SelectorIndex) AddEventHandler(handler SelectorEventHandler) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	si.handlers = append(si.handlers, handler)
+}
+
+// RegisterSelector registers (or updates) owner Selector. An empty Selector matches no cluster.
+// Returns an error if selector cannot be parsed.
+func (si *SelectorIndex) RegisterSelector(owner *corev1.ObjectReference,
+	selector libsveltosv1alpha1.Selector) error {
+
+	parsedSelector := labels.Nothing()
+	if selector != "" {
+		var err error
+		parsedSelector, err = labels.Parse(string(selector))
+		if err != nil {
+			return fmt.Errorf("failed to parse selector %q: %w", selector, err)
+		}
+	}
+
+	key := getReferenceKey(owner)
+
+	si.mu.Lock()
+	si.owners[key] = *owner
+	si.selectors[key] = parsedSelector
+	si.mu.Unlock()
+
+	si.index.SetSelector(key, parsedSelector)
+	return nil
+}
+
+// UnregisterSelector removes owner Selector. A SelectorEventRemoved is emitted
+// for each cluster currently matching it.
+func (si *SelectorIndex) UnregisterSelector(owner *corev1.ObjectReference) {
+	key := getReferenceKey(owner)
+
+	si.index.RemoveSelector(key)
+
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	delete(si.owners, key)
+	delete(si.selectors, key)
+}
+
+// GetMatchingOwners returns all owners whose Selector currently matches cluster
+func (si *SelectorIndex) GetMatchingOwners(cluster *corev1.ObjectReference) []corev1.ObjectReference {
+	keys := si.index.GetMatchingSelectors(cluster)
+
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+
+	owners := make([]corev1.ObjectReference, 0, len(keys))
+	for _, key := range keys {
+		if owner, ok := si.owners[key]; ok {
+			owners = append(owners, owner)
+		}
+	}
+	return owners
+}
+
+// GetMatchingClusters returns all clusters currently matching owner Selector
+func (si *SelectorIndex) GetMatchingClusters(owner *corev1.ObjectReference) []corev1.ObjectReference {
+	si.mu.RLock()
+	selector, ok := si.selectors[getReferenceKey(owner)]
+	si.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	clusters := si.index.GetMatchingClusters(selector)
+	sort.Slice(clusters, func(i, j int) bool {
+		return getReferenceKey(&clusters[i]) < getReferenceKey(&clusters[j])
+	})
+	return clusters
+}
+
+func (si *SelectorIndex) onMatchChange(cluster *corev1.ObjectReference, added, removed []string) {
+	si.mu.RLock()
+	events := make([]SelectorEvent, 0, len(added)+len(removed))
+	events = si.appendEvents(events, SelectorEventAdded, cluster, added)
+	events = si.appendEvents(events, SelectorEventRemoved, cluster, removed)
+	handlers := si.handlers
+	si.mu.RUnlock()
+
+	for i := range events {
+		for _, handler := range handlers {
+			handler(&events[i])
+		}
+	}
+}
+
+// appendEvents must be called with lock held. Keys not registered by this
+// SelectorIndex are ignored.
+func (si *SelectorIndex) appendEvents(events []SelectorEvent, eventType SelectorEventType,
+	cluster *corev1.ObjectReference, keys []string) []SelectorEvent {
+
+	for _, key := range keys {
+		owner, ok := si.owners[key]
+		if !ok {
+			continue
+		}
+		events = append(events, SelectorEvent{Type: eventType, Cluster: *cluster, Owner: owner})
+	}
+	return events
+}
+
+// getReferenceKey returns a unique key for ref. Used as ClusterIndex selector key for owners.
+func getReferenceKey(ref *corev1.ObjectReference) string {
+	return strings.Join([]string{ref.APIVersion, ref.Kind, ref.Namespace, ref.Name}, "/")
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+)
+
+var _ = Describe("SelectorIndex", func() {
+	var namespace string
+
+	BeforeEach(func() {
+		namespace = "selector-index" + randomString()
+
+		ns := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: namespace,
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), ns)).To(Succeed())
+	})
+
+	It("emits events when clusters start and stop matching owner selectors", func() {
+		indexCtx, indexCancel := context.WithCancel(context.TODO())
+		defer indexCancel()
+
+		env := randomString()
+
+		mu := &sync.Mutex{}
+		events := make([]clusterproxy.SelectorEvent, 0)
+
+		index := clusterproxy.NewClusterIndex(klogr.New())
+		selectorIndex := clusterproxy.NewSelectorIndex(index)
+		selectorIndex.AddEventHandler(func(event *clusterproxy.SelectorEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, *event)
+		})
+		Expect(index.Start(indexCtx, testEnv.Config)).To(Succeed())
+
+		healthCheck := corev1.ObjectReference{Name: randomString(),
+			Kind: libsveltosv1alpha1.ClusterHealthCheckKind, APIVersion: libsveltosv1alpha1.GroupVersion.String()}
+		Expect(selectorIndex.RegisterSelector(&healthCheck,
+			libsveltosv1alpha1.Selector("env="+env))).To(Succeed())
+
+		roleRequest := corev1.ObjectReference{Name: randomString(),
+			Kind: libsveltosv1alpha1.RoleRequestKind, APIVersion: libsveltosv1alpha1.GroupVersion.String()}
+		Expect(selectorIndex.RegisterSelector(&roleRequest,
+			libsveltosv1alpha1.Selector("env="+env+",tier=gold"))).To(Succeed())
+
+		Expect(selectorIndex.RegisterSelector(&roleRequest, libsveltosv1alpha1.Selector("env in ("))).ToNot(Succeed())
+
+		sveltosCluster := &libsveltosv1alpha1.SveltosCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      randomString(),
+				Labels:    map[string]string{"env": env},
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), sveltosCluster)).To(Succeed())
+
+		clusterRef := corev1.ObjectReference{Namespace: sveltosCluster.Namespace, Name: sveltosCluster.Name,
+			Kind: libsveltosv1alpha1.SveltosClusterKind, APIVersion: libsveltosv1alpha1.GroupVersion.String()}
+
+		Eventually(func() []corev1.ObjectReference {
+			return selectorIndex.GetMatchingOwners(&clusterRef)
+		}, time.Minute, time.Second).Should(ConsistOf(healthCheck))
+		Expect(selectorIndex.GetMatchingClusters(&healthCheck)).To(ConsistOf(clusterRef))
+		Expect(selectorIndex.GetMatchingClusters(&roleRequest)).To(BeEmpty())
+
+		// Labels change: cluster now matches RoleRequest as well
+		sveltosCluster.Labels["tier"] = "gold"
+		Expect(testEnv.Update(context.TODO(), sveltosCluster)).To(Succeed())
+
+		Eventually(func() []corev1.ObjectReference {
+			return selectorIndex.GetMatchingOwners(&clusterRef)
+		}, time.Minute, time.Second).Should(ConsistOf(healthCheck, roleRequest))
+
+		// Owner is unregistered
+		selectorIndex.UnregisterSelector(&healthCheck)
+		Expect(selectorIndex.GetMatchingOwners(&clusterRef)).To(ConsistOf(roleRequest))
+		Expect(selectorIndex.GetMatchingClusters(&healthCheck)).To(BeNil())
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(events).To(Equal([]clusterproxy.SelectorEvent{
+			{Type: clusterproxy.SelectorEventAdded, Cluster: clusterRef, Owner: healthCheck},
+			{Type: clusterproxy.SelectorEventAdded, Cluster: clusterRef, Owner: roleRequest},
+			{Type: clusterproxy.SelectorEventRemoved, Cluster: clusterRef, Owner: healthCheck},
+		}))
+	})
+})
@@ -0,0 +1,116 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/go-logr/logr"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/roles"
+)
+
+// TenantAccessMode defines how clusterproxy accesses managed clusters on behalf of
+// a tenant admin (adminNamespace/adminName)
+type TenantAccessMode int32
+
+const (
+	// TenantAccessKubeconfig uses the kubeconfig stored, per tenant admin and cluster,
+	// in the Secret created by roles.CreateSecret. This is the default.
+	TenantAccessKubeconfig TenantAccessMode = iota
+
+	// TenantAccessImpersonation uses the cluster-admin kubeconfig, impersonating
+	// the ServiceAccount created for the tenant admin in the managed cluster.
+	// No per tenant kubeconfig is needed. Cluster-admin kubeconfig must be allowed to
+	// impersonate users and groups.
+	TenantAccessImpersonation
+)
+
+const (
+	serviceAccountsGroup = "system:serviceaccounts"
+)
+
+var (
+	tenantAccessMode int32
+)
+
+// SetTenantAccessMode sets how managed clusters are accessed on behalf of tenant admins.
+// ClientCache entries built before the change are not affected; use ClientCache.InvalidateAll.
+func SetTenantAccessMode(mode TenantAccessMode) {
+	atomic.StoreInt32(&tenantAccessMode, int32(mode))
+}
+
+// GetTenantAccessMode returns how managed clusters are accessed on behalf of tenant admins
+func GetTenantAccessMode() TenantAccessMode {
+	return TenantAccessMode(atomic.LoadInt32(&tenantAccessMode))
+}
+
+// getImpersonatingKubeconfigSource returns the cluster-admin kubeconfig modified to impersonate
+// the ServiceAccount created in the managed cluster for tenant admin adminNamespace/adminName.
+// Returned kubeconfig has its current-context set to the context to use.
+func getImpersonatingKubeconfigSource(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName, adminNamespace, adminName string,
+	clusterType libsveltosv1alpha1.ClusterType, logger logr.Logger) (*KubeconfigSource, error) {
+
+	source, err := getKubeconfigSource(ctx, c, clusterNamespace, clusterName, clusterType, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeconfig, err := getImpersonatingKubeconfig(source.Kubeconfig, source.Context, adminNamespace, adminName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KubeconfigSource{Secret: source.Secret, Kubeconfig: kubeconfig}, nil
+}
+
+func getImpersonatingKubeconfig(kubeconfigContent []byte, kubeconfigContext,
+	adminNamespace, adminName string) ([]byte, error) {
+
+	kubeconfig, err := clientcmd.Load(kubeconfigContent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+
+	if kubeconfigContext == "" {
+		kubeconfigContext = kubeconfig.CurrentContext
+	}
+	kubeContext, ok := kubeconfig.Contexts[kubeconfigContext]
+	if !ok {
+		return nil, fmt.Errorf("context %q not found in kubeconfig", kubeconfigContext)
+	}
+	authInfo, ok := kubeconfig.AuthInfos[kubeContext.AuthInfo]
+	if !ok {
+		return nil, fmt.Errorf("user %q not found in kubeconfig", kubeContext.AuthInfo)
+	}
+
+	// Groups are not added by the API server when impersonating. Set the groups any
+	// ServiceAccount belongs to, so same RBAC applies.
+	namespace := roles.ServiceAccountNamespaceInManagedCluster
+	authInfo.Impersonate = fmt.Sprintf("%s%s:%s", serviceAccountSubjectPrefix, namespace,
+		roles.GetServiceAccountNameInManagedCluster(adminNamespace, adminName))
+	authInfo.ImpersonateGroups = []string{serviceAccountsGroup, fmt.Sprintf("%s:%s", serviceAccountsGroup, namespace)}
+	kubeconfig.CurrentContext = kubeconfigContext
+
+	return clientcmd.Write(*kubeconfig)
+}
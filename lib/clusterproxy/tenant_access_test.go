@@ -0,0 +1,123 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy_test
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+	"github.com/projectsveltos/libsveltos/lib/roles"
+)
+
+var _ = Describe("Tenant access", func() {
+	var c client.Client
+	var sveltosCluster *libsveltosv1alpha1.SveltosCluster
+
+	BeforeEach(func() {
+		sveltosCluster = &libsveltosv1alpha1.SveltosCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+			},
+		}
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: sveltosCluster.Namespace,
+				Name:      sveltosCluster.Name + clusterproxy.SveltosKubeconfigSecretNamePostfix,
+			},
+			Data: map[string][]byte{
+				"value": testEnv.Kubeconfig,
+			},
+		}
+
+		initObjects := []client.Object{sveltosCluster, secret}
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+	})
+
+	AfterEach(func() {
+		clusterproxy.SetTenantAccessMode(clusterproxy.TenantAccessKubeconfig)
+	})
+
+	It("TenantAccessImpersonation impersonates tenant ServiceAccount using cluster-admin kubeconfig", func() {
+		adminNamespace := randomString()
+		adminName := randomString()
+
+		// No tenant kubeconfig exists
+		_, err := clusterproxy.GetKubernetesRestConfig(context.TODO(), c, sveltosCluster.Namespace,
+			sveltosCluster.Name, adminNamespace, adminName, libsveltosv1alpha1.ClusterTypeSveltos, klogr.New())
+		Expect(err).ToNot(BeNil())
+
+		clusterproxy.SetTenantAccessMode(clusterproxy.TenantAccessImpersonation)
+		Expect(clusterproxy.GetTenantAccessMode()).To(Equal(clusterproxy.TenantAccessImpersonation))
+
+		config, err := clusterproxy.GetKubernetesRestConfig(context.TODO(), c, sveltosCluster.Namespace,
+			sveltosCluster.Name, adminNamespace, adminName, libsveltosv1alpha1.ClusterTypeSveltos, klogr.New())
+		Expect(err).To(BeNil())
+
+		expectedUser := fmt.Sprintf("system:serviceaccount:%s:%s", roles.ServiceAccountNamespaceInManagedCluster,
+			roles.GetServiceAccountNameInManagedCluster(adminNamespace, adminName))
+		Expect(config.Impersonate.UserName).To(Equal(expectedUser))
+
+		clientset, err := kubernetes.NewForConfig(config)
+		Expect(err).To(BeNil())
+		review, err := clientset.AuthenticationV1().SelfSubjectReviews().Create(context.TODO(),
+			&authenticationv1.SelfSubjectReview{}, metav1.CreateOptions{})
+		Expect(err).To(BeNil())
+		Expect(review.Status.UserInfo.Username).To(Equal(expectedUser))
+		Expect(review.Status.UserInfo.Groups).To(ContainElements("system:serviceaccounts",
+			"system:serviceaccounts:"+roles.ServiceAccountNamespaceInManagedCluster))
+
+		// ServiceAccount has no permission
+		_, err = clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+		Expect(err).ToNot(BeNil())
+
+		// cluster-admin access is not affected
+		config, err = clusterproxy.GetKubernetesRestConfig(context.TODO(), c, sveltosCluster.Namespace,
+			sveltosCluster.Name, "", "", libsveltosv1alpha1.ClusterTypeSveltos, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(config.Impersonate.UserName).To(BeEmpty())
+	})
+
+	It("ClientCache honors TenantAccessImpersonation", func() {
+		clusterproxy.SetTenantAccessMode(clusterproxy.TenantAccessImpersonation)
+
+		adminNamespace := randomString()
+		adminName := randomString()
+
+		clientCache := clusterproxy.NewClientCache(10)
+		config, err := clientCache.GetKubernetesRestConfig(context.TODO(), c, sveltosCluster.Namespace,
+			sveltosCluster.Name, adminNamespace, adminName, libsveltosv1alpha1.ClusterTypeSveltos, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(config.Impersonate.UserName).To(Equal(
+			fmt.Sprintf("system:serviceaccount:%s:%s", roles.ServiceAccountNamespaceInManagedCluster,
+				roles.GetServiceAccountNameInManagedCluster(adminNamespace, adminName))))
+	})
+})
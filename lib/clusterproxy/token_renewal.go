@@ -0,0 +1,254 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+	"github.com/projectsveltos/libsveltos/lib/utils"
+)
+
+const (
+	// minTokenExpiration is the minimum token validity accepted by the TokenRequest API
+	minTokenExpiration = 10 * time.Minute
+
+	serviceAccountSubjectPrefix = "system:serviceaccount:"
+
+	// TokenIssuedAtAnnotation is the annotation, on the SveltosCluster kubeconfig Secret,
+	// containing the time (RFC3339) the token was issued by RenewSveltosClusterToken
+	TokenIssuedAtAnnotation = "projectsveltos.io/token-issued-at"
+
+	// TokenExpiresAtAnnotation is the annotation, on the SveltosCluster kubeconfig Secret,
+	// containing the time (RFC3339) the token issued by RenewSveltosClusterToken expires
+	TokenExpiresAtAnnotation = "projectsveltos.io/token-expires-at"
+)
+
+// IsTokenRenewalDue returns true if SveltosCluster has TokenRequestRenewalOption set
+// and its kubeconfig token needs to be renewed. Issue and expiration time of the current
+// token are read from the kubeconfig Secret (see TokenIssuedAtAnnotation and TokenExpiresAtAnnotation).
+// Token needs to be renewed when:
+// - it was never renewed;
+// - RenewTokenRequestInterval has elapsed since it was issued;
+// - half of its validity has elapsed (API server might have issued a token valid for less than requested).
+// An error is returned if RenewTokenRequestInterval is not positive.
+func IsTokenRenewalDue(ctx context.Context, c client.Client, cluster *libsveltosv1alpha1.SveltosCluster,
+	now time.Time, logger logr.Logger) (bool, error) {
+
+	if cluster.Spec.TokenRequestRenewalOption == nil {
+		return false, nil
+	}
+
+	interval, err := getRenewTokenRequestInterval(cluster)
+	if err != nil {
+		return false, err
+	}
+
+	_, secret, err := getSveltosKubeconfigSecret(ctx, logger, c, cluster.Namespace, cluster.Name)
+	if err != nil {
+		return false, err
+	}
+
+	issuedAt, expiresAt := getTokenTimes(secret)
+	if issuedAt == nil || expiresAt == nil {
+		return true, nil
+	}
+
+	renewAt := issuedAt.Add(interval)
+	if halfLife := issuedAt.Add(expiresAt.Sub(*issuedAt) / 2); halfLife.Before(renewAt) {
+		renewAt = halfLife
+	}
+	return !now.Before(renewAt), nil
+}
+
+// RenewSveltosClusterToken uses SveltosCluster current kubeconfig to request, via TokenRequest,
+// a new token for the ServiceAccount the kubeconfig authenticates as. Kubeconfig Secret is then
+// updated with the new token.
+// On success cluster Status.TokenExpiration is set to the new token expiration time. Persisting
+// SveltosCluster Status is left to the caller.
+func RenewSveltosClusterToken(ctx context.Context, c client.Client,
+	cluster *libsveltosv1alpha1.SveltosCluster, logger logr.Logger) error {
+
+	if cluster.Spec.TokenRequestRenewalOption == nil {
+		return fmt.Errorf("SveltosCluster %s/%s has no TokenRequestRenewalOption", cluster.Namespace, cluster.Name)
+	}
+
+	interval, err := getRenewTokenRequestInterval(cluster)
+	if err != nil {
+		return err
+	}
+
+	logger = logger.WithValues("sveltoscluster", fmt.Sprintf("%s/%s", cluster.Namespace, cluster.Name))
+
+	_, secret, err := getSveltosKubeconfigSecret(ctx, logger, c, cluster.Namespace, cluster.Name)
+	if err != nil {
+		return err
+	}
+
+	key, err := getKubeconfigSecretKey(secret, cluster.Spec.KubeconfigKeyName)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return fmt.Errorf("secret %s/%s contains no kubeconfig", secret.Namespace, secret.Name)
+	}
+
+	kubeconfig, err := clientcmd.Load(secret.Data[key])
+	if err != nil {
+		return fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+
+	contextName := cluster.Spec.KubeconfigContext
+	if contextName == "" {
+		contextName = kubeconfig.CurrentContext
+	}
+	kubeContext, ok := kubeconfig.Contexts[contextName]
+	if !ok {
+		return fmt.Errorf("context %q not found in kubeconfig", contextName)
+	}
+	authInfo, ok := kubeconfig.AuthInfos[kubeContext.AuthInfo]
+	if !ok || authInfo.Token == "" {
+		return fmt.Errorf("context %q does not use token authentication", contextName)
+	}
+
+	saNamespace, saName, err := getServiceAccountFromToken(authInfo.Token)
+	if err != nil {
+		return err
+	}
+
+	config, err := GetRestConfigFromKubeconfig(logger, secret.Data[key], cluster.Spec.KubeconfigContext)
+	if err != nil {
+		return err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	expirationSeconds := int64(getTokenExpiration(interval).Seconds())
+	tokenRequest, err := clientset.CoreV1().ServiceAccounts(saNamespace).CreateToken(ctx, saName,
+		&authenticationv1.TokenRequest{
+			Spec: authenticationv1.TokenRequestSpec{
+				ExpirationSeconds: &expirationSeconds,
+			},
+		}, metav1.CreateOptions{})
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to request token for %s/%s: %v", saNamespace, saName, err))
+		return err
+	}
+
+	authInfo.Token = tokenRequest.Status.Token
+	secret.Data[key], err = clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	// API server might issue a token valid for less than requested. Record actual values.
+	issuedAt := time.Now()
+	if claims, err := utils.GetTokenClaims(tokenRequest.Status.Token); err == nil && claims.IssuedAt != nil {
+		issuedAt = *claims.IssuedAt
+	}
+	expiration := tokenRequest.Status.ExpirationTimestamp
+	setTokenTimes(secret, issuedAt, expiration.Time)
+
+	if err := c.Update(ctx, secret); err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to update secret %s/%s: %v", secret.Namespace, secret.Name, err))
+		return err
+	}
+
+	cluster.Status.TokenExpiration = &expiration
+	logger.V(logs.LogDebug).Info(fmt.Sprintf("renewed token. New token expires at %s", expiration.String()))
+	return nil
+}
+
+// getTokenExpiration returns the validity of tokens renewed every interval
+func getTokenExpiration(interval time.Duration) time.Duration {
+	expiration := 2 * interval
+	if expiration < minTokenExpiration {
+		expiration = minTokenExpiration
+	}
+	return expiration
+}
+
+// getRenewTokenRequestInterval returns SveltosCluster RenewTokenRequestInterval.
+// Returns an error if it is not positive.
+func getRenewTokenRequestInterval(cluster *libsveltosv1alpha1.SveltosCluster) (time.Duration, error) {
+	interval := cluster.Spec.TokenRequestRenewalOption.RenewTokenRequestInterval.Duration
+	if interval <= 0 {
+		return 0, fmt.Errorf("SveltosCluster %s/%s RenewTokenRequestInterval must be positive. Current value: %s",
+			cluster.Namespace, cluster.Name, interval)
+	}
+	return interval, nil
+}
+
+// getTokenTimes returns the issue and expiration time recorded on secret by
+// RenewSveltosClusterToken. Nil is returned for any missing or invalid value.
+func getTokenTimes(secret *corev1.Secret) (issuedAt, expiresAt *time.Time) {
+	annotations := secret.GetAnnotations()
+	parse := func(key string) *time.Time {
+		t, err := time.Parse(time.RFC3339, annotations[key])
+		if err != nil {
+			return nil
+		}
+		return &t
+	}
+
+	return parse(TokenIssuedAtAnnotation), parse(TokenExpiresAtAnnotation)
+}
+
+func setTokenTimes(secret *corev1.Secret, issuedAt, expiresAt time.Time) {
+	annotations := secret.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[TokenIssuedAtAnnotation] = issuedAt.UTC().Format(time.RFC3339)
+	annotations[TokenExpiresAtAnnotation] = expiresAt.UTC().Format(time.RFC3339)
+	secret.SetAnnotations(annotations)
+}
+
+// getServiceAccountFromToken returns namespace and name of the ServiceAccount a token
+// was issued for. Token signature is not verified.
+func getServiceAccountFromToken(token string) (namespace, name string, err error) {
+	claims, err := utils.GetTokenClaims(token)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !strings.HasPrefix(claims.Subject, serviceAccountSubjectPrefix) {
+		return "", "", fmt.Errorf("token subject %q is not a ServiceAccount", claims.Subject)
+	}
+
+	subject := strings.Split(strings.TrimPrefix(claims.Subject, serviceAccountSubjectPrefix), ":")
+	if len(subject) != 2 || subject[0] == "" || subject[1] == "" {
+		return "", "", fmt.Errorf("malformed ServiceAccount subject %q", claims.Subject)
+	}
+
+	return subject[0], subject[1], nil
+}
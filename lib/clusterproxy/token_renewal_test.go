@@ -0,0 +1,266 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterproxy_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+)
+
+var _ = Describe("Token renewal", func() {
+	var namespace string
+	var serviceAccountName string
+
+	BeforeEach(func() {
+		namespace = "token-renewal" + randomString()
+		serviceAccountName = randomString()
+
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+		Expect(testEnv.Create(context.TODO(), ns)).To(Succeed())
+
+		serviceAccount := &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: serviceAccountName},
+		}
+		Expect(testEnv.Create(context.TODO(), serviceAccount)).To(Succeed())
+
+		// ServiceAccount is allowed to request tokens for itself
+		role := &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: serviceAccountName},
+			Rules: []rbacv1.PolicyRule{
+				{
+					APIGroups:     []string{""},
+					Resources:     []string{"serviceaccounts/token"},
+					ResourceNames: []string{serviceAccountName},
+					Verbs:         []string{"create"},
+				},
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), role)).To(Succeed())
+
+		roleBinding := &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: serviceAccountName},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: serviceAccountName},
+			Subjects: []rbacv1.Subject{
+				{Kind: rbacv1.ServiceAccountKind, Namespace: namespace, Name: serviceAccountName},
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), roleBinding)).To(Succeed())
+	})
+
+	getKubeconfig := func(token string) []byte {
+		kubeconfig := clientcmdapi.NewConfig()
+		kubeconfig.Clusters["sveltos"] = &clientcmdapi.Cluster{
+			Server:                   testEnv.Config.Host,
+			CertificateAuthorityData: testEnv.Config.CAData,
+		}
+		kubeconfig.AuthInfos["sveltos"] = &clientcmdapi.AuthInfo{Token: token}
+		kubeconfig.Contexts["sveltos"] = &clientcmdapi.Context{Cluster: "sveltos", AuthInfo: "sveltos"}
+		kubeconfig.CurrentContext = "sveltos"
+
+		data, err := clientcmd.Write(*kubeconfig)
+		Expect(err).To(BeNil())
+		return data
+	}
+
+	It("RenewSveltosClusterToken requests a new token and updates kubeconfig Secret", func() {
+		clientset, err := kubernetes.NewForConfig(testEnv.Config)
+		Expect(err).To(BeNil())
+
+		expirationSeconds := int64(600)
+		tokenRequest, err := clientset.CoreV1().ServiceAccounts(namespace).CreateToken(context.TODO(),
+			serviceAccountName, &authenticationv1.TokenRequest{
+				Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &expirationSeconds},
+			}, metav1.CreateOptions{})
+		Expect(err).To(BeNil())
+
+		sveltosCluster := &libsveltosv1alpha1.SveltosCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+			},
+			Spec: libsveltosv1alpha1.SveltosClusterSpec{
+				KubeconfigName: randomString(),
+				TokenRequestRenewalOption: &libsveltosv1alpha1.TokenRequestRenewalOption{
+					RenewTokenRequestInterval: metav1.Duration{Duration: time.Hour},
+				},
+			},
+		}
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: sveltosCluster.Namespace,
+				Name:      sveltosCluster.Spec.KubeconfigName,
+			},
+			Data: map[string][]byte{
+				"kubeconfig": getKubeconfig(tokenRequest.Status.Token),
+			},
+		}
+
+		initObjects := []client.Object{sveltosCluster, secret}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		isDue := func(now time.Time) bool {
+			due, err := clusterproxy.IsTokenRenewalDue(context.TODO(), c, sveltosCluster, now, klogr.New())
+			Expect(err).To(BeNil())
+			return due
+		}
+
+		// Never renewed
+		Expect(isDue(time.Now())).To(BeTrue())
+
+		Expect(clusterproxy.RenewSveltosClusterToken(context.TODO(), c, sveltosCluster, klogr.New())).To(Succeed())
+		Expect(sveltosCluster.Status.TokenExpiration).ToNot(BeNil())
+		Expect(sveltosCluster.Status.TokenExpiration.Time).To(BeTemporally("~", time.Now().Add(2*time.Hour), time.Minute))
+
+		Expect(isDue(time.Now())).To(BeFalse())
+		Expect(isDue(time.Now().Add(time.Hour + time.Minute))).To(BeTrue())
+
+		currentSecret := &corev1.Secret{}
+		Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(secret), currentSecret)).To(Succeed())
+		Expect(currentSecret.Data).To(HaveKey("kubeconfig"))
+		Expect(currentSecret.Data["kubeconfig"]).ToNot(Equal(secret.Data["kubeconfig"]))
+		Expect(currentSecret.Annotations).To(HaveKey(clusterproxy.TokenIssuedAtAnnotation))
+		Expect(currentSecret.Annotations).To(HaveKey(clusterproxy.TokenExpiresAtAnnotation))
+
+		// Renewal is computed from the time token was issued. Token is renewed no later
+		// than half of its validity even if interval is changed to a longer one.
+		sveltosCluster.Spec.TokenRequestRenewalOption.RenewTokenRequestInterval = metav1.Duration{Duration: 5 * time.Hour}
+		Expect(isDue(time.Now().Add(30 * time.Minute))).To(BeFalse())
+		Expect(isDue(time.Now().Add(time.Hour + time.Minute))).To(BeTrue())
+
+		sveltosCluster.Spec.TokenRequestRenewalOption.RenewTokenRequestInterval = metav1.Duration{Duration: 10 * time.Minute}
+		Expect(isDue(time.Now())).To(BeFalse())
+		Expect(isDue(time.Now().Add(11 * time.Minute))).To(BeTrue())
+
+		// New kubeconfig authenticates as the ServiceAccount
+		config, err := clusterproxy.GetSveltosKubernetesRestConfig(context.TODO(), klogr.New(), c,
+			sveltosCluster.Namespace, sveltosCluster.Name)
+		Expect(err).To(BeNil())
+		saClientset, err := kubernetes.NewForConfig(config)
+		Expect(err).To(BeNil())
+		review, err := saClientset.AuthenticationV1().SelfSubjectReviews().Create(context.TODO(),
+			&authenticationv1.SelfSubjectReview{}, metav1.CreateOptions{})
+		Expect(err).To(BeNil())
+		Expect(review.Status.UserInfo.Username).To(Equal(
+			fmt.Sprintf("system:serviceaccount:%s:%s", namespace, serviceAccountName)))
+	})
+
+	It("RenewSveltosClusterToken fails if kubeconfig does not authenticate as a ServiceAccount", func() {
+		sveltosCluster := &libsveltosv1alpha1.SveltosCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+			},
+			Spec: libsveltosv1alpha1.SveltosClusterSpec{
+				TokenRequestRenewalOption: &libsveltosv1alpha1.TokenRequestRenewalOption{
+					RenewTokenRequestInterval: metav1.Duration{Duration: time.Hour},
+				},
+			},
+		}
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: sveltosCluster.Namespace,
+				Name:      sveltosCluster.Name + clusterproxy.SveltosKubeconfigSecretNamePostfix,
+			},
+			Data: map[string][]byte{
+				"value": testEnv.Kubeconfig,
+			},
+		}
+
+		initObjects := []client.Object{sveltosCluster, secret}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		Expect(clusterproxy.RenewSveltosClusterToken(context.TODO(), c, sveltosCluster, klogr.New())).ToNot(Succeed())
+
+		secret.Data["value"] = getKubeconfig("not-a-jwt")
+		Expect(c.Update(context.TODO(), secret)).To(Succeed())
+		Expect(clusterproxy.RenewSveltosClusterToken(context.TODO(), c, sveltosCluster, klogr.New())).ToNot(Succeed())
+		Expect(sveltosCluster.Status.TokenExpiration).To(BeNil())
+
+		sveltosCluster.Spec.TokenRequestRenewalOption = nil
+		due, err := clusterproxy.IsTokenRenewalDue(context.TODO(), c, sveltosCluster, time.Now(), klogr.New())
+		Expect(err).To(BeNil())
+		Expect(due).To(BeFalse())
+	})
+
+	It("IsTokenRenewalDue renews once half of token validity has elapsed", func() {
+		sveltosCluster := &libsveltosv1alpha1.SveltosCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+			},
+			Spec: libsveltosv1alpha1.SveltosClusterSpec{
+				TokenRequestRenewalOption: &libsveltosv1alpha1.TokenRequestRenewalOption{
+					RenewTokenRequestInterval: metav1.Duration{Duration: 24 * time.Hour},
+				},
+			},
+		}
+
+		// API server capped token validity to one hour
+		issuedAt := time.Now().Add(-20 * time.Minute)
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: sveltosCluster.Namespace,
+				Name:      sveltosCluster.Name + clusterproxy.SveltosKubeconfigSecretNamePostfix,
+				Annotations: map[string]string{
+					clusterproxy.TokenIssuedAtAnnotation:  issuedAt.UTC().Format(time.RFC3339),
+					clusterproxy.TokenExpiresAtAnnotation: issuedAt.Add(time.Hour).UTC().Format(time.RFC3339),
+				},
+			},
+			Data: map[string][]byte{
+				"value": testEnv.Kubeconfig,
+			},
+		}
+
+		initObjects := []client.Object{sveltosCluster, secret}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		due, err := clusterproxy.IsTokenRenewalDue(context.TODO(), c, sveltosCluster, time.Now(), klogr.New())
+		Expect(err).To(BeNil())
+		Expect(due).To(BeFalse())
+
+		due, err = clusterproxy.IsTokenRenewalDue(context.TODO(), c, sveltosCluster,
+			time.Now().Add(11*time.Minute), klogr.New())
+		Expect(err).To(BeNil())
+		Expect(due).To(BeTrue())
+
+		// Non positive interval is rejected
+		sveltosCluster.Spec.TokenRequestRenewalOption.RenewTokenRequestInterval = metav1.Duration{}
+		_, err = clusterproxy.IsTokenRenewalDue(context.TODO(), c, sveltosCluster, time.Now(), klogr.New())
+		Expect(err).ToNot(BeNil())
+		Expect(clusterproxy.RenewSveltosClusterToken(context.TODO(), c, sveltosCluster, klogr.New())).ToNot(Succeed())
+	})
+})
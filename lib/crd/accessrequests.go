@@ -53,6 +53,50 @@ spec:
           spec:
             description: AccessRequestSpec defines the desired state of AccessRequest
             properties:
+              caBundleRef:
+                description: CABundleRef references the CA bundle used to verify the
+                  management cluster controlplane endpoint certificate. If not set,
+                  the CA bundle published in the kube-root-ca.crt ConfigMap of the
+                  AccessRequest namespace is used.
+                properties:
+                  key:
+                    description: Key within the resource data containing the CA bundle.
+                      Defaults to ca.crt
+                    type: string
+                  kind:
+                    description: Kind of the resource containing the CA bundle.
+                    enum:
+                    - Secret
+                    - ConfigMap
+                    type: string
+                  name:
+                    description: Name of the resource containing the CA bundle.
+                    type: string
+                  namespace:
+                    description: Namespace of the resource containing the CA bundle.
+                      If not set, the AccessRequest namespace is used.
+                    type: string
+                required:
+                - kind
+                - name
+                type: object
+              certificateTTL:
+                description: CertificateTTL is the requested duration of validity
+                  of the client certificate issued for this AccessRequest. It is required
+                  to issue a client certificate. Minimum is 10 minutes, maximum is
+                  24 hours. Issued certificate cannot be revoked and remains valid
+                  till it expires.
+                type: string
+              clusterRoleRef:
+                description: ClusterRoleRef references the ClusterRole granted to
+                  the service account. Required, and only used, when Type is Generic.
+                properties:
+                  name:
+                    description: 'Name of the referent. More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/names/#names
+                      TODO: Add other useful fields. apiVersion, kind, uid?'
+                    type: string
+                type: object
+                x-kubernetes-map-type: atomic
               controlPlaneEndpoint:
                 description: ControlPlaneEndpoint represents the endpoint used to
                   communicate with the management cluster controlplane endpoint. It
@@ -77,11 +121,17 @@ spec:
                 description: Namespace is the namespace of the service account created
                   for this AccessRequest
                 type: string
+              tlsServerName:
+                description: TLSServerName is passed to the server for SNI and is
+                  used in the client to check server certificates against. If not
+                  set, the hostname of ControlPlaneEndpoint is used.
+                type: string
               type:
                 description: Type represent the type of the request
                 enum:
                 - SveltosAgent
-                - Different
+                - DriftDetection
+                - Generic
                 type: string
             required:
             - controlPlaneEndpoint
@@ -92,6 +142,11 @@ spec:
           status:
             description: AccessRequestStatus defines the status of AccessRequest
             properties:
+              certificateExpiration:
+                description: CertificateExpiration is the time the client certificate
+                  contained in the Kubeconfig expires.
+                format: date-time
+                type: string
               failureMessage:
                 description: FailureMessage provides more information if an error
                   occurs.
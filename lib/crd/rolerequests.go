@@ -245,6 +245,35 @@ spec:
                   type: object
                   x-kubernetes-map-type: atomic
                 type: array
+              permissionSummary:
+                description: PermissionSummary summarizes the permissions granted
+                  to the tenant by the Roles/ClusterRoles referenced in RoleRefs
+                properties:
+                  clusterRules:
+                    description: ClusterRules is the number of (aggregated) rules
+                      granted cluster wide
+                    type: integer
+                  escalationRisks:
+                    description: EscalationRisks lists the granted permissions that
+                      allow a tenant to escalate privileges (wildcards, secrets access,
+                      bind/escalate/impersonate)
+                    items:
+                      type: string
+                    type: array
+                  namespacedRules:
+                    description: NamespacedRules is the number of (aggregated) rules
+                      granted in specific namespaces
+                    type: integer
+                  namespaces:
+                    description: Namespaces lists the namespaces where permissions
+                      are granted by Roles
+                    items:
+                      type: string
+                    type: array
+                required:
+                - clusterRules
+                - namespacedRules
+                type: object
             type: object
         type: object
     served: true
@@ -62,6 +62,19 @@ spec:
           spec:
             description: SveltosClusterSpec defines the desired state of SveltosCluster
             properties:
+              kubeconfigContext:
+                description: KubeconfigContext is the name of the context, within
+                  the kubeconfig, to use to connect to the Kubernetes cluster. Needed
+                  only when kubeconfig contains more than one context. If not set,
+                  kubeconfig current-context is used.
+                type: string
+              kubeconfigKeyName:
+                description: KubeconfigKeyName is the key, within the Secret, containing
+                  the kubeconfig. If set, the Secret must contain it and it is always
+                  used. If not set, key "value" or "kubeconfig" is used (Secret containing
+                  both is considered an error). Otherwise Secret must contain exactly
+                  one key.
+                type: string
               kubeconfigName:
                 description: "KubeconfigName allows overriding the default Sveltos
                   convention which expected a valid kubeconfig to be hosted in a secret
@@ -73,17 +86,52 @@ spec:
                 description: Paused can be used to prevent controllers from processing
                   the SveltosCluster and all its associated objects.
                 type: boolean
+              tokenRequestRenewalOption:
+                description: TokenRequestRenewalOption, when set, makes Sveltos periodically
+                  renew the token contained in the kubeconfig. Kubeconfig must authenticate
+                  as a ServiceAccount of the managed cluster, which must be allowed
+                  to create tokens for itself.
+                properties:
+                  renewTokenRequestInterval:
+                    description: RenewTokenRequestInterval is the interval at which
+                      the token is renewed. Must be positive. Each new token is requested
+                      to be valid for twice this interval (and no less than 10 minutes).
+                      If API server issues a token valid for less, token is renewed
+                      once half of its validity has elapsed.
+                    type: string
+                required:
+                - renewTokenRequestInterval
+                type: object
             type: object
           status:
             description: SveltosClusterStatus defines the status of SveltosCluster
             properties:
+              connectionLatency:
+                description: ConnectionLatency is the time it took, during last successful
+                  attempt, for the cluster API server to answer
+                type: string
+              consecutiveFailures:
+                description: ConsecutiveFailures is the number of consecutive failed
+                  attempts to contact the cluster API server
+                format: int32
+                type: integer
               failureMessage:
                 description: FailureMessage is a human consumable message explaining
                   the misconfiguration
                 type: string
+              lastSuccessfulConnection:
+                description: LastSuccessfulConnection is the last time the cluster
+                  API server was successfully contacted
+                format: date-time
+                type: string
               ready:
                 description: Ready is the state of the cluster.
                 type: boolean
+              tokenExpiration:
+                description: TokenExpiration is the expiration time of the token last
+                  requested because of TokenRequestRenewalOption
+                format: date-time
+                type: string
               version:
                 description: The Kubernetes version of the cluster.
                 type: string
@@ -18,6 +18,8 @@ package crd
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
@@ -36,24 +38,65 @@ import (
 
 type handler func(gvk *schema.GroupVersionKind)
 
-// WatchCustomResourceDefinition starts a watcher for CustomResourceDefinition.
-// When new CRD is added/deleted/modified, invokes the passed handler
-// Called must have RBAC to watch CustomResourceDefinition
-func WatchCustomResourceDefinition(ctx context.Context, config *rest.Config,
-	h handler, logger logr.Logger) {
-	gvk := schema.GroupVersionKind{
+const (
+	// watcherSyncTimeout is how long StartCustomResourceDefinitionWatcher waits
+	// for the watcher cache to be synced
+	watcherSyncTimeout = time.Minute
+)
+
+var (
+	crdGVK = schema.GroupVersionKind{
 		Group:   "apiextensions.k8s.io",
 		Version: "v1",
 		Kind:    "CustomResourceDefinition",
 	}
+)
 
-	dcinformer, err := getDynamicInformer(&gvk, config)
+// WatchCustomResourceDefinition starts a watcher for CustomResourceDefinition.
+// When new CRD is added/deleted/modified, invokes the passed handler
+// Called must have RBAC to watch CustomResourceDefinition
+// It blocks till ctx is cancelled. Returns an error if the watcher cannot be started.
+func WatchCustomResourceDefinition(ctx context.Context, config *rest.Config,
+	h handler, logger logr.Logger) error {
+
+	dcinformer, err := getDynamicInformer(&crdGVK, config)
 	if err != nil {
 		logger.Error(err, "Failed to get informer")
-		panic(1)
+		return fmt.Errorf("failed to get informer for CustomResourceDefinition: %w", err)
 	}
 
 	runCRDInformer(ctx.Done(), dcinformer.Informer(), h, logger)
+	return nil
+}
+
+// StartCustomResourceDefinitionWatcher starts a watcher for CustomResourceDefinition, like
+// WatchCustomResourceDefinition, but it does not block. It returns once watcher cache is synced.
+// Returns an error if the watcher cannot be started or its cache is not synced in time
+// (for instance, caller has no RBAC to list CustomResourceDefinitions).
+// Watcher stops when ctx is cancelled.
+func StartCustomResourceDefinitionWatcher(ctx context.Context, config *rest.Config,
+	h handler, logger logr.Logger) error {
+
+	dcinformer, err := getDynamicInformer(&crdGVK, config)
+	if err != nil {
+		logger.Error(err, "Failed to get informer")
+		return fmt.Errorf("failed to get informer for CustomResourceDefinition: %w", err)
+	}
+
+	informerCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer cancel()
+		runCRDInformer(informerCtx.Done(), dcinformer.Informer(), h, logger)
+	}()
+
+	syncCtx, syncCancel := context.WithTimeout(informerCtx, watcherSyncTimeout)
+	defer syncCancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), dcinformer.Informer().HasSynced) {
+		cancel()
+		return fmt.Errorf("failed to sync CustomResourceDefinition watcher cache")
+	}
+
+	return nil
 }
 
 func getDynamicInformer(gvk *schema.GroupVersionKind, config *rest.Config) (informers.GenericInformer, error) {
@@ -70,7 +113,10 @@ func getDynamicInformer(gvk *schema.GroupVersionKind, config *rest.Config) (info
 		nil,
 	)
 
-	dc := discovery.NewDiscoveryClientForConfigOrDie(config)
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
 	groupResources, err := restmapper.GetAPIGroupResources(dc)
 	if err != nil {
 		return nil, err
@@ -24,6 +24,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2/klogr"
 
 	"github.com/projectsveltos/libsveltos/lib/crd"
@@ -89,7 +90,10 @@ var _ = Describe("WatchCustomResourceDefinition", func() {
 
 		watcherCtx, cancel := context.WithCancel(context.Background())
 		defer cancel()
-		go crd.WatchCustomResourceDefinition(watcherCtx, testEnv.Config, handler, logger)
+		go func() {
+			defer GinkgoRecover()
+			Expect(crd.WatchCustomResourceDefinition(watcherCtx, testEnv.Config, handler, logger)).To(Succeed())
+		}()
 
 		crd, err := utils.GetUnstructured([]byte(crdYAML))
 		Expect(err).To(BeNil())
@@ -100,4 +104,19 @@ var _ = Describe("WatchCustomResourceDefinition", func() {
 			return handlerCalled
 		}, time.Minute, time.Second).Should(BeTrue())
 	})
+	It("WatchCustomResourceDefinition returns an error when watcher cannot be started", func() {
+		logger := klogr.New()
+
+		invalidConfig := rest.CopyConfig(testEnv.Config)
+		invalidConfig.Host = "https://unreachable.invalid:6443"
+		invalidConfig.Timeout = time.Second
+
+		watcherCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		Expect(crd.WatchCustomResourceDefinition(watcherCtx, invalidConfig, handler, logger)).ToNot(Succeed())
+
+		malformedConfig := rest.CopyConfig(testEnv.Config)
+		malformedConfig.Host = "://malformed"
+		Expect(crd.WatchCustomResourceDefinition(watcherCtx, malformedConfig, handler, logger)).ToNot(Succeed())
+	})
 })
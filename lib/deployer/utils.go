@@ -19,12 +19,19 @@ package deployer
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
 )
 
 const (
@@ -149,6 +156,121 @@ func GetOwnerMessage(ctx context.Context, dr dynamic.ResourceInterface,
 	return message, nil
 }
 
+// OwnerReferenceMatchMode defines how an OwnerReference is compared against
+// a Sveltos resource.
+type OwnerReferenceMatchMode int32
+
+const (
+	// OwnerReferenceStrictMatch considers an OwnerReference a match only if
+	// API group, Kind, Name and UID are all equal.
+	// Owners with no UID (for instance, objects not fetched from the API server)
+	// cannot be compared by UID: for those, API group, Kind and Name must match.
+	OwnerReferenceStrictMatch OwnerReferenceMatchMode = iota
+
+	// OwnerReferenceMigrationMatch behaves like OwnerReferenceStrictMatch but
+	// also recognises legacy OwnerReferences, which are matched on Kind and Name only.
+	// A legacy OwnerReference must have either no API group or owner API group.
+	// Legacy OwnerReferences found by AddOwnerReference are replaced with up-to-date ones.
+	OwnerReferenceMigrationMatch
+)
+
+var (
+	ownerReferenceMatchMode int32
+
+	// ownerScheme is used to find the GroupVersionKind of owners with empty TypeMeta
+	ownerScheme = newOwnerScheme()
+)
+
+func newOwnerScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = libsveltosv1alpha1.AddToScheme(s)
+	return s
+}
+
+// SetOwnerReferenceMatchMode sets how AddOwnerReference, RemoveOwnerReference,
+// IsOwnerReference and IsOnlyOwnerReference compare OwnerReferences.
+// Default is OwnerReferenceStrictMatch.
+func SetOwnerReferenceMatchMode(mode OwnerReferenceMatchMode) {
+	atomic.StoreInt32(&ownerReferenceMatchMode, int32(mode))
+}
+
+// GetOwnerReferenceMatchMode returns the mode currently used to compare OwnerReferences.
+func GetOwnerReferenceMatchMode() OwnerReferenceMatchMode {
+	return OwnerReferenceMatchMode(atomic.LoadInt32(&ownerReferenceMatchMode))
+}
+
+// getOwnerGroupVersionKind returns owner GroupVersionKind. If owner TypeMeta is not set
+// (typed objects fetched via client usually have it empty), GroupVersionKind is
+// found using Kubernetes and Sveltos schemes.
+func getOwnerGroupVersionKind(owner client.Object) schema.GroupVersionKind {
+	gvk := owner.GetObjectKind().GroupVersionKind()
+	if gvk.Kind != "" {
+		return gvk
+	}
+
+	if schemeGVK, err := apiutil.GVKForObject(owner, ownerScheme); err == nil {
+		return schemeGVK
+	}
+	return gvk
+}
+
+// isOwnerReferenceAMatch returns true if ref points to owner.
+// API group, Kind, Name and UID must match. When OwnerReferenceMigrationMatch
+// is set, a ref with matching Kind and Name is considered a match as well.
+func isOwnerReferenceAMatch(ref *metav1.OwnerReference, owner client.Object) bool {
+	if isStrictOwnerReferenceMatch(ref, owner) {
+		return true
+	}
+
+	if GetOwnerReferenceMatchMode() == OwnerReferenceMigrationMatch {
+		return isLegacyOwnerReferenceMatch(ref, owner)
+	}
+
+	return false
+}
+
+// isStrictOwnerReferenceMatch returns true if ref points to owner: API group, Kind, Name
+// and UID must match. If owner has no UID, UID cannot be compared and only API group,
+// Kind and Name must match.
+func isStrictOwnerReferenceMatch(ref *metav1.OwnerReference, owner client.Object) bool {
+	if !isSameKindAndName(ref, owner) {
+		return false
+	}
+
+	return owner.GetUID() == "" || ref.UID == owner.GetUID()
+}
+
+// isSameKindAndName returns true if ref has owner API group, Kind and Name.
+// UID is not considered.
+func isSameKindAndName(ref *metav1.OwnerReference, owner client.Object) bool {
+	gvk := getOwnerGroupVersionKind(owner)
+
+	refGV, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return false
+	}
+
+	return refGV.Group == gvk.Group &&
+		ref.Kind == gvk.Kind &&
+		ref.Name == owner.GetName()
+}
+
+// isLegacyOwnerReferenceMatch returns true if ref has owner Kind and Name, and either
+// no API group or owner API group. OwnerReferences from a different API group never match.
+func isLegacyOwnerReferenceMatch(ref *metav1.OwnerReference, owner client.Object) bool {
+	gvk := getOwnerGroupVersionKind(owner)
+
+	refGV, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return false
+	}
+
+	return (refGV.Group == "" || refGV.Group == gvk.Group) &&
+		ref.Kind == gvk.Kind &&
+		ref.Name == owner.GetName()
+}
+
 // AddOwnerReference adds Sveltos resource owning a resource as an object's OwnerReference.
 // OwnerReferences are used as ref count. Different Sveltos resources might match same cluster and
 // reference same ConfigMap. This means a policy contained in a ConfigMap is deployed in a Cluster
@@ -160,25 +282,29 @@ func AddOwnerReference(object, owner client.Object) {
 		onwerReferences = make([]metav1.OwnerReference, 0)
 	}
 
+	apiVersion, kind := getOwnerGroupVersionKind(owner).ToAPIVersionAndKind()
+	ownerRef := metav1.OwnerReference{
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Name:       owner.GetName(),
+		UID:        owner.GetUID(),
+	}
+
 	for i := range onwerReferences {
 		ref := &onwerReferences[i]
-		if ref.Kind == owner.GetObjectKind().GroupVersionKind().Kind &&
-			ref.Name == owner.GetName() {
-
+		if isStrictOwnerReferenceMatch(ref, owner) {
+			return
+		}
+		// Either a stale OwnerReference (owner was recreated, so UID is different)
+		// or a legacy OwnerReference. Replace it with an up-to-date one.
+		if isSameKindAndName(ref, owner) || isOwnerReferenceAMatch(ref, owner) {
+			onwerReferences[i] = ownerRef
+			object.SetOwnerReferences(onwerReferences)
 			return
 		}
 	}
 
-	apiVersion, kind := owner.GetObjectKind().GroupVersionKind().ToAPIVersionAndKind()
-
-	onwerReferences = append(onwerReferences,
-		metav1.OwnerReference{
-			APIVersion: apiVersion,
-			Kind:       kind,
-			Name:       owner.GetName(),
-			UID:        owner.GetUID(),
-		},
-	)
+	onwerReferences = append(onwerReferences, ownerRef)
 
 	object.SetOwnerReferences(onwerReferences)
 }
@@ -194,20 +320,16 @@ func RemoveOwnerReference(object, owner client.Object) {
 		return
 	}
 
-	_, kind := owner.GetObjectKind().GroupVersionKind().ToAPIVersionAndKind()
-
+	remaining := make([]metav1.OwnerReference, 0, len(onwerReferences))
 	for i := range onwerReferences {
 		ref := &onwerReferences[i]
-		if ref.Kind == kind &&
-			ref.Name == owner.GetName() {
-
-			onwerReferences[i] = onwerReferences[len(onwerReferences)-1]
-			onwerReferences = onwerReferences[:len(onwerReferences)-1]
-			break
+		if isOwnerReferenceAMatch(ref, owner) {
+			continue
 		}
+		remaining = append(remaining, *ref)
 	}
 
-	object.SetOwnerReferences(onwerReferences)
+	object.SetOwnerReferences(remaining)
 }
 
 // IsOnlyOwnerReference returns true if clusterprofile is the only ownerreference for object
@@ -221,11 +343,7 @@ func IsOnlyOwnerReference(object, owner client.Object) bool {
 		return false
 	}
 
-	kind := owner.GetObjectKind().GroupVersionKind().Kind
-
-	ref := &onwerReferences[0]
-	return ref.Kind == kind &&
-		ref.Name == owner.GetName()
+	return isOwnerReferenceAMatch(&onwerReferences[0], owner)
 }
 
 // IsOwnerReference returns true is owner is one of the OwnerReferences
@@ -236,13 +354,8 @@ func IsOwnerReference(object, owner client.Object) bool {
 		return false
 	}
 
-	kind := owner.GetObjectKind().GroupVersionKind().Kind
-
 	for i := range onwerReferences {
-		ref := &onwerReferences[i]
-		if ref.Kind == kind &&
-			ref.Name == owner.GetName() {
-
+		if isOwnerReferenceAMatch(&onwerReferences[i], owner) {
 			return true
 		}
 	}
@@ -26,6 +26,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
 
 	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
 	"github.com/projectsveltos/libsveltos/lib/deployer"
@@ -182,4 +183,143 @@ var _ = Describe("Client", func() {
 		deployer.AddOwnerReference(policy, roleRequest2)
 		Expect(deployer.IsOwnerReference(policy, roleRequest)).To(BeTrue())
 	})
+
+	It("IsOwnerReference matches on API group and UID", func() {
+		roleRequest := &libsveltosv1alpha1.RoleRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+				UID:  types.UID(randomString()),
+			},
+		}
+		Expect(addTypeInformationToObject(testEnv.Scheme(), roleRequest)).To(Succeed())
+
+		policy, err := utils.GetUnstructured([]byte(fmt.Sprintf(viewClusterRole, randomString())))
+		Expect(err).To(BeNil())
+
+		deployer.AddOwnerReference(policy, roleRequest)
+		Expect(deployer.IsOwnerReference(policy, roleRequest)).To(BeTrue())
+
+		// Same Kind and Name, different API group
+		otherGroupOwner := &unstructured.Unstructured{}
+		otherGroupOwner.SetAPIVersion("example.com/v1")
+		otherGroupOwner.SetKind(libsveltosv1alpha1.RoleRequestKind)
+		otherGroupOwner.SetName(roleRequest.Name)
+		otherGroupOwner.SetUID(roleRequest.UID)
+		Expect(deployer.IsOwnerReference(policy, otherGroupOwner)).To(BeFalse())
+
+		// Same Kind and Name, different UID (owner was recreated)
+		recreated := roleRequest.DeepCopy()
+		recreated.UID = types.UID(randomString())
+		Expect(deployer.IsOwnerReference(policy, recreated)).To(BeFalse())
+		Expect(deployer.IsOnlyOwnerReference(policy, recreated)).To(BeFalse())
+
+		deployer.RemoveOwnerReference(policy, recreated)
+		Expect(len(policy.GetOwnerReferences())).To(Equal(1))
+
+		// Stale OwnerReference is replaced
+		deployer.AddOwnerReference(policy, recreated)
+		Expect(len(policy.GetOwnerReferences())).To(Equal(1))
+		Expect(policy.GetOwnerReferences()[0].UID).To(Equal(recreated.UID))
+		Expect(deployer.IsOwnerReference(policy, recreated)).To(BeTrue())
+		Expect(deployer.IsOwnerReference(policy, roleRequest)).To(BeFalse())
+	})
+
+	It("OwnerReferences helpers work with owners with no TypeMeta or UID", func() {
+		// No TypeMeta. GroupVersionKind is found from scheme.
+		roleRequest := &libsveltosv1alpha1.RoleRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+				UID:  types.UID(randomString()),
+			},
+		}
+
+		policy, err := utils.GetUnstructured([]byte(fmt.Sprintf(viewClusterRole, randomString())))
+		Expect(err).To(BeNil())
+
+		deployer.AddOwnerReference(policy, roleRequest)
+		Expect(len(policy.GetOwnerReferences())).To(Equal(1))
+		Expect(policy.GetOwnerReferences()[0].APIVersion).To(Equal(libsveltosv1alpha1.GroupVersion.String()))
+		Expect(policy.GetOwnerReferences()[0].Kind).To(Equal(libsveltosv1alpha1.RoleRequestKind))
+		Expect(deployer.IsOwnerReference(policy, roleRequest)).To(BeTrue())
+		Expect(deployer.IsOnlyOwnerReference(policy, roleRequest)).To(BeTrue())
+
+		// No UID. UID is not compared.
+		noUID := roleRequest.DeepCopy()
+		noUID.UID = ""
+		Expect(deployer.IsOwnerReference(policy, noUID)).To(BeTrue())
+
+		deployer.AddOwnerReference(policy, noUID)
+		Expect(len(policy.GetOwnerReferences())).To(Equal(1))
+
+		deployer.RemoveOwnerReference(policy, noUID)
+		Expect(len(policy.GetOwnerReferences())).To(Equal(0))
+	})
+
+	It("OwnerReferenceMigrationMatch recognises legacy OwnerReferences", func() {
+		defer deployer.SetOwnerReferenceMatchMode(deployer.OwnerReferenceStrictMatch)
+
+		roleRequest := &libsveltosv1alpha1.RoleRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+				UID:  types.UID(randomString()),
+			},
+		}
+		Expect(addTypeInformationToObject(testEnv.Scheme(), roleRequest)).To(Succeed())
+
+		policy, err := utils.GetUnstructured([]byte(fmt.Sprintf(viewClusterRole, randomString())))
+		Expect(err).To(BeNil())
+
+		// Legacy OwnerReference: no API group and no UID
+		policy.SetOwnerReferences([]metav1.OwnerReference{
+			{APIVersion: "v1alpha1", Kind: libsveltosv1alpha1.RoleRequestKind, Name: roleRequest.Name},
+		})
+
+		deployer.SetOwnerReferenceMatchMode(deployer.OwnerReferenceStrictMatch)
+		Expect(deployer.IsOwnerReference(policy, roleRequest)).To(BeFalse())
+
+		deployer.SetOwnerReferenceMatchMode(deployer.OwnerReferenceMigrationMatch)
+		Expect(deployer.IsOwnerReference(policy, roleRequest)).To(BeTrue())
+		Expect(deployer.IsOnlyOwnerReference(policy, roleRequest)).To(BeTrue())
+
+		// AddOwnerReference replaces legacy OwnerReference
+		deployer.AddOwnerReference(policy, roleRequest)
+		Expect(len(policy.GetOwnerReferences())).To(Equal(1))
+		Expect(policy.GetOwnerReferences()[0].APIVersion).To(Equal(libsveltosv1alpha1.GroupVersion.String()))
+		Expect(policy.GetOwnerReferences()[0].UID).To(Equal(roleRequest.UID))
+
+		deployer.SetOwnerReferenceMatchMode(deployer.OwnerReferenceStrictMatch)
+		Expect(deployer.IsOwnerReference(policy, roleRequest)).To(BeTrue())
+	})
+
+	It("OwnerReferenceMigrationMatch does not match OwnerReferences from a different API group", func() {
+		defer deployer.SetOwnerReferenceMatchMode(deployer.OwnerReferenceStrictMatch)
+		deployer.SetOwnerReferenceMatchMode(deployer.OwnerReferenceMigrationMatch)
+
+		roleRequest := &libsveltosv1alpha1.RoleRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+				UID:  types.UID(randomString()),
+			},
+		}
+		Expect(addTypeInformationToObject(testEnv.Scheme(), roleRequest)).To(Succeed())
+
+		policy, err := utils.GetUnstructured([]byte(fmt.Sprintf(viewClusterRole, randomString())))
+		Expect(err).To(BeNil())
+
+		otherGroupRef := metav1.OwnerReference{
+			APIVersion: "example.com/v1", Kind: libsveltosv1alpha1.RoleRequestKind,
+			Name: roleRequest.Name, UID: types.UID(randomString()),
+		}
+		policy.SetOwnerReferences([]metav1.OwnerReference{otherGroupRef})
+
+		Expect(deployer.IsOwnerReference(policy, roleRequest)).To(BeFalse())
+
+		// OwnerReference from a different API group is preserved
+		deployer.AddOwnerReference(policy, roleRequest)
+		Expect(policy.GetOwnerReferences()).To(HaveLen(2))
+		Expect(policy.GetOwnerReferences()).To(ContainElement(otherGroupRef))
+
+		deployer.RemoveOwnerReference(policy, roleRequest)
+		Expect(policy.GetOwnerReferences()).To(ConsistOf(otherGroupRef))
+	})
 })
@@ -0,0 +1,427 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driftdetection
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+	memory "k8s.io/client-go/discovery/cached"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+// A ResourceSummary lists all resources deployed in a managed cluster because of
+// referenced ConfigMaps/Secrets, Kustomize and Helm charts.
+// Detector watches all those resources and, when any of them is modified or deleted
+// (configuration drift), sets corresponding ResourceSummary Status *Changed flag.
+// ResourceSummary Status hashes contain the hash of each resource as last seen by the Detector.
+// First time a resource is seen, only its hash is recorded. Every following change in the
+// resource hash is reported as a drift.
+// Consumers of ResourceSummary are expected to reset *Changed flags once drift is handled.
+
+// section identifies the ResourceSummary list a resource belongs to
+type section int
+
+const (
+	resourcesSection section = iota
+	kustomizeResourcesSection
+	helmResourcesSection
+)
+
+// trackingEntry identifies a ResourceSummary (and the section within it)
+// tracking a resource
+type trackingEntry struct {
+	resourceSummary types.NamespacedName
+	section         section
+}
+
+const (
+	// informerSyncTimeout is how long Track waits for an informer cache to be synced
+	informerSyncTimeout = time.Minute
+)
+
+// informerKey identifies an informer. Informers for namespaced resources only
+// watch the namespace of tracked resources. Namespace is empty for cluster wide resources.
+type informerKey struct {
+	gvk       schema.GroupVersionKind
+	namespace string
+}
+
+// informerEntry is an informer running for an informerKey
+type informerEntry struct {
+	informer cache.SharedIndexInformer
+
+	// cancel stops the informer
+	cancel context.CancelFunc
+
+	// references is the number of tracked ResourceSummaries using the informer.
+	// Informer is stopped when this drops to zero.
+	references int
+}
+
+// Detector detects configuration drift for resources listed in ResourceSummaries.
+type Detector struct {
+	log logr.Logger
+
+	// Client is used to access ResourceSummary instances
+	client.Client
+
+	dynamicClient dynamic.Interface
+	mapper        *restmapper.DeferredDiscoveryRESTMapper
+
+	ctx context.Context
+
+	mu *sync.Mutex
+
+	// informersMu serializes informers lookup, creation and removal, so only one
+	// informer is ever started per informerKey
+	informersMu *sync.Mutex
+
+	// informers contains, per GroupVersionKind and namespace, the informer
+	// started for the corresponding GroupVersionResource
+	informers map[informerKey]*informerEntry
+
+	// resources contains, per resource, all the ResourceSummaries tracking it
+	resources map[libsveltosv1alpha1.Resource]map[trackingEntry]bool
+
+	// resourceSummaries contains, per ResourceSummary, all the resources it is tracking
+	resourceSummaries map[types.NamespacedName][]libsveltosv1alpha1.Resource
+
+	// queue contains resources that need to be evaluated
+	queue workqueue.RateLimitingInterface
+}
+
+// New returns a Detector.
+// config is the rest.Config to access the managed cluster where resources are deployed.
+// c is the client used to access ResourceSummary instances.
+// Detector stops when ctx is canceled.
+func New(ctx context.Context, config *rest.Config, c client.Client, logger logr.Logger) (*Detector, error) {
+	if config == nil {
+		return nil, fmt.Errorf("rest.Config is nil")
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Detector{
+		log:               logger,
+		Client:            c,
+		dynamicClient:     dynamicClient,
+		mapper:            restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc)),
+		ctx:               ctx,
+		mu:                &sync.Mutex{},
+		informersMu:       &sync.Mutex{},
+		informers:         make(map[informerKey]*informerEntry),
+		resources:         make(map[libsveltosv1alpha1.Resource]map[trackingEntry]bool),
+		resourceSummaries: make(map[types.NamespacedName][]libsveltosv1alpha1.Resource),
+		queue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	go wait.UntilWithContext(ctx, d.runWorker, time.Second)
+	go func() {
+		<-ctx.Done()
+		d.queue.ShutDown()
+	}()
+
+	return d, nil
+}
+
+// Track starts tracking all resources listed in resourceSummary.
+// If resourceSummary was already tracked, list of tracked resources is updated.
+// Track returns an error if any informer cache is not synced within informerSyncTimeout.
+func (d *Detector) Track(ctx context.Context, resourceSummary *libsveltosv1alpha1.ResourceSummary) error {
+	summaryName := types.NamespacedName{Namespace: resourceSummary.Namespace, Name: resourceSummary.Name}
+	logger := d.log.WithValues("resourcesummary", summaryName.String())
+
+	resources := getResources(resourceSummary)
+
+	// Make sure an informer exists for each resource GroupVersionKind
+	keys := make(map[informerKey]bool)
+	for i := range resources {
+		keys[getInformerKey(&resources[i].resource)] = true
+	}
+	acquired := make([]informerKey, 0, len(keys))
+	for key := range keys {
+		if err := d.startInformer(ctx, key); err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to start informer: %v", err))
+			d.releaseInformers(acquired)
+			return err
+		}
+		acquired = append(acquired, key)
+	}
+
+	d.mu.Lock()
+	d.untrack(summaryName)
+	tracked := make([]libsveltosv1alpha1.Resource, len(resources))
+	for i := range resources {
+		resource := resources[i].resource
+		if _, ok := d.resources[resource]; !ok {
+			d.resources[resource] = make(map[trackingEntry]bool)
+		}
+		d.resources[resource][trackingEntry{resourceSummary: summaryName, section: resources[i].section}] = true
+		tracked[i] = resource
+	}
+	d.resourceSummaries[summaryName] = tracked
+	d.mu.Unlock()
+
+	// Evaluate all resources. First evaluation records resource hashes.
+	for i := range tracked {
+		d.queue.Add(tracked[i])
+	}
+
+	logger.V(logs.LogDebug).Info(fmt.Sprintf("tracking %d resources", len(tracked)))
+	return nil
+}
+
+// Untrack stops tracking resources for ResourceSummary namespace/name
+func (d *Detector) Untrack(namespace, name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.untrack(types.NamespacedName{Namespace: namespace, Name: name})
+}
+
+// untrack must be called with lock held. Informers used by summaryName are released.
+func (d *Detector) untrack(summaryName types.NamespacedName) {
+	tracked, ok := d.resourceSummaries[summaryName]
+	if !ok {
+		return
+	}
+
+	keys := make(map[informerKey]bool)
+	for _, resource := range tracked {
+		keys[getInformerKey(&resource)] = true
+		entries := d.resources[resource]
+		for entry := range entries {
+			if entry.resourceSummary == summaryName {
+				delete(entries, entry)
+			}
+		}
+		if len(entries) == 0 {
+			delete(d.resources, resource)
+		}
+	}
+	delete(d.resourceSummaries, summaryName)
+
+	released := make([]informerKey, 0, len(keys))
+	for key := range keys {
+		released = append(released, key)
+	}
+	d.releaseInformers(released)
+}
+
+// startInformer starts, if not started already, an informer for key and waits for its
+// cache to be synced. On success, caller holds a reference to the informer and must
+// release it with releaseInformers.
+func (d *Detector) startInformer(ctx context.Context, key informerKey) error {
+	informer, err := d.acquireInformer(key)
+	if err != nil {
+		return err
+	}
+
+	syncCtx, cancel := context.WithTimeout(ctx, informerSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), informer.HasSynced) {
+		d.releaseInformers([]informerKey{key})
+		return fmt.Errorf("failed to sync informer for %s (namespace %q)", key.gvk.String(), key.namespace)
+	}
+
+	return nil
+}
+
+// acquireInformer returns the informer for key, creating and starting it if it
+// does not exist yet, and increments its references. Lookup and creation happen with
+// informersMu held so that concurrent calls never start more than one informer
+// (and event handler) per key.
+func (d *Detector) acquireInformer(key informerKey) (cache.SharedIndexInformer, error) {
+	d.informersMu.Lock()
+	defer d.informersMu.Unlock()
+
+	if entry, ok := d.informers[key]; ok {
+		entry.references++
+		return entry.informer, nil
+	}
+
+	mapping, err := d.getRESTMapping(key.gvk)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := key.namespace
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		namespace = metav1.NamespaceAll
+	}
+
+	informer := dynamicinformer.NewFilteredDynamicInformer(d.dynamicClient, mapping.Resource, namespace, 0,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, nil).Informer()
+	_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    d.enqueue,
+		UpdateFunc: func(_, newObj interface{}) { d.enqueue(newObj) },
+		DeleteFunc: d.enqueue,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	informerCtx, cancel := context.WithCancel(d.ctx)
+	d.informers[key] = &informerEntry{informer: informer, cancel: cancel, references: 1}
+	go informer.Run(informerCtx.Done())
+
+	return informer, nil
+}
+
+// releaseInformers decrements the references of the informers for keys. Informers
+// with no references left are stopped and removed.
+func (d *Detector) releaseInformers(keys []informerKey) {
+	d.informersMu.Lock()
+	defer d.informersMu.Unlock()
+
+	for i := range keys {
+		entry, ok := d.informers[keys[i]]
+		if !ok {
+			continue
+		}
+		entry.references--
+		if entry.references <= 0 {
+			entry.cancel()
+			delete(d.informers, keys[i])
+		}
+	}
+}
+
+func (d *Detector) getInformer(resource *libsveltosv1alpha1.Resource) cache.SharedIndexInformer {
+	d.informersMu.Lock()
+	defer d.informersMu.Unlock()
+
+	entry, ok := d.informers[getInformerKey(resource)]
+	if !ok {
+		return nil
+	}
+	return entry.informer
+}
+
+func getInformerKey(resource *libsveltosv1alpha1.Resource) informerKey {
+	return informerKey{
+		gvk:       schema.GroupVersionKind{Group: resource.Group, Version: resource.Version, Kind: resource.Kind},
+		namespace: resource.Namespace,
+	}
+}
+
+func (d *Detector) getRESTMapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	mapping, err := d.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil && meta.IsNoMatchError(err) {
+		// Resource might have been installed after discovery information was cached
+		d.mapper.Reset()
+		mapping, err = d.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	return mapping, err
+}
+
+// enqueue adds resource to the queue if resource is tracked
+func (d *Detector) enqueue(obj interface{}) {
+	resource, err := getResourceFromObject(obj)
+	if err != nil {
+		d.log.V(logs.LogInfo).Info(fmt.Sprintf("failed to process event: %v", err))
+		return
+	}
+
+	d.mu.Lock()
+	_, tracked := d.resources[*resource]
+	d.mu.Unlock()
+
+	if tracked {
+		d.queue.Add(*resource)
+	}
+}
+
+func (d *Detector) runWorker(ctx context.Context) {
+	for d.processNextItem(ctx) {
+	}
+}
+
+func (d *Detector) processNextItem(ctx context.Context) bool {
+	item, shutdown := d.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer d.queue.Done(item)
+
+	resource := item.(libsveltosv1alpha1.Resource)
+	if err := d.evaluate(ctx, &resource); err != nil {
+		d.log.V(logs.LogInfo).Info(fmt.Sprintf("failed to evaluate resource %s %s/%s: %v",
+			resource.Kind, resource.Namespace, resource.Name, err))
+		d.queue.AddRateLimited(item)
+		return true
+	}
+
+	d.queue.Forget(item)
+	return true
+}
+
+// evaluate computes resource current hash and updates all ResourceSummaries tracking it
+func (d *Detector) evaluate(ctx context.Context, resource *libsveltosv1alpha1.Resource) error {
+	informer := d.getInformer(resource)
+
+	d.mu.Lock()
+	entries := make([]trackingEntry, 0, len(d.resources[*resource]))
+	for entry := range d.resources[*resource] {
+		entries = append(entries, entry)
+	}
+	d.mu.Unlock()
+
+	if informer == nil || len(entries) == 0 {
+		return nil
+	}
+
+	currentHash, err := getCurrentHash(informer, resource)
+	if err != nil {
+		return err
+	}
+
+	for i := range entries {
+		if err := d.updateResourceSummary(ctx, &entries[i], resource, currentHash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
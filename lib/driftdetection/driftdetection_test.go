@@ -0,0 +1,270 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driftdetection_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/klogr"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/driftdetection"
+)
+
+const (
+	timeout         = 20 * time.Second
+	pollingInterval = time.Second
+)
+
+var _ = Describe("Drift detection", func() {
+	var namespace string
+	var detector *driftdetection.Detector
+
+	BeforeEach(func() {
+		namespace = "drift" + randomString()
+
+		ns := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: namespace,
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), ns)).To(Succeed())
+
+		var err error
+		detector, err = driftdetection.New(ctx, testEnv.Config, testEnv.Client, klogr.New())
+		Expect(err).To(BeNil())
+	})
+
+	It("Track records resource hashes and detects a modified resource", func() {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      randomString(),
+			},
+			Data: map[string]string{
+				"key": randomString(),
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), configMap)).To(Succeed())
+
+		resourceSummary := getResourceSummary(namespace)
+		resourceSummary.Spec.Resources = []libsveltosv1alpha1.Resource{
+			{Group: "", Version: "v1", Kind: "ConfigMap", Namespace: configMap.Namespace, Name: configMap.Name},
+		}
+		Expect(testEnv.Create(context.TODO(), resourceSummary)).To(Succeed())
+		// Eventual loop so testEnv Cache is synced
+		Eventually(func() bool {
+			return getCurrentResourceSummary(resourceSummary) != nil
+		}, timeout, pollingInterval).Should(BeTrue())
+
+		Expect(detector.Track(context.TODO(), resourceSummary)).To(Succeed())
+
+		// Hash is recorded, no drift is reported
+		Eventually(func() bool {
+			current := getCurrentResourceSummary(resourceSummary)
+			return current != nil && len(current.Status.ResourceHashes) == 1 &&
+				current.Status.ResourceHashes[0].Hash != ""
+		}, timeout, pollingInterval).Should(BeTrue())
+		Expect(getCurrentResourceSummary(resourceSummary).Status.ResourcesChanged).To(BeFalse())
+
+		currentConfigMap := &corev1.ConfigMap{}
+		Expect(testEnv.Get(context.TODO(), types.NamespacedName{Namespace: configMap.Namespace, Name: configMap.Name},
+			currentConfigMap)).To(Succeed())
+		currentConfigMap.Data["key"] = randomString()
+		Expect(testEnv.Update(context.TODO(), currentConfigMap)).To(Succeed())
+
+		Eventually(func() bool {
+			current := getCurrentResourceSummary(resourceSummary)
+			return current != nil && current.Status.ResourcesChanged
+		}, timeout, pollingInterval).Should(BeTrue())
+
+		current := getCurrentResourceSummary(resourceSummary)
+		Expect(current.Status.KustomizeResourcesChanged).To(BeFalse())
+		Expect(current.Status.HelmResourcesChanged).To(BeFalse())
+	})
+
+	It("Track detects a deleted resource and Untrack stops detection", func() {
+		kustomizeConfigMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      randomString(),
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), kustomizeConfigMap)).To(Succeed())
+
+		helmConfigMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      randomString(),
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), helmConfigMap)).To(Succeed())
+
+		resourceSummary := getResourceSummary(namespace)
+		resourceSummary.Spec.KustomizeResources = []libsveltosv1alpha1.Resource{
+			{Group: "", Version: "v1", Kind: "ConfigMap", Namespace: kustomizeConfigMap.Namespace, Name: kustomizeConfigMap.Name},
+		}
+		resourceSummary.Spec.ChartResources = []libsveltosv1alpha1.HelmResources{
+			{
+				ChartName: randomString(), ReleaseName: randomString(), ReleaseNamespace: namespace,
+				Resources: []libsveltosv1alpha1.Resource{
+					{Group: "", Version: "v1", Kind: "ConfigMap", Namespace: helmConfigMap.Namespace, Name: helmConfigMap.Name},
+				},
+			},
+		}
+		Expect(testEnv.Create(context.TODO(), resourceSummary)).To(Succeed())
+		// Eventual loop so testEnv Cache is synced
+		Eventually(func() bool {
+			return getCurrentResourceSummary(resourceSummary) != nil
+		}, timeout, pollingInterval).Should(BeTrue())
+
+		Expect(detector.Track(context.TODO(), resourceSummary)).To(Succeed())
+
+		Eventually(func() bool {
+			current := getCurrentResourceSummary(resourceSummary)
+			return current != nil && len(current.Status.KustomizeResourceHashes) == 1 &&
+				len(current.Status.HelmResourceHashes) == 1
+		}, timeout, pollingInterval).Should(BeTrue())
+
+		Expect(testEnv.Delete(context.TODO(), kustomizeConfigMap)).To(Succeed())
+
+		Eventually(func() bool {
+			current := getCurrentResourceSummary(resourceSummary)
+			return current != nil && current.Status.KustomizeResourcesChanged
+		}, timeout, pollingInterval).Should(BeTrue())
+		Expect(getCurrentResourceSummary(resourceSummary).Status.HelmResourcesChanged).To(BeFalse())
+
+		detector.Untrack(resourceSummary.Namespace, resourceSummary.Name)
+
+		Expect(testEnv.Delete(context.TODO(), helmConfigMap)).To(Succeed())
+		Consistently(func() bool {
+			current := getCurrentResourceSummary(resourceSummary)
+			return current != nil && current.Status.HelmResourcesChanged
+		}, timeout/4, pollingInterval).Should(BeFalse())
+	})
+
+	It("Track starts one informer per GroupVersionKind and namespace", func() {
+		const summaries = 5
+		resourceSummaries := make([]*libsveltosv1alpha1.ResourceSummary, summaries)
+		for i := 0; i < summaries; i++ {
+			resourceSummaries[i] = getResourceSummary(namespace)
+			resourceSummaries[i].Spec.Resources = []libsveltosv1alpha1.Resource{
+				{Group: "", Version: "v1", Kind: "ConfigMap", Namespace: namespace, Name: randomString()},
+			}
+		}
+
+		// Concurrent Track calls for the same GroupVersionKind and namespace
+		var wg sync.WaitGroup
+		errs := make(chan error, summaries)
+		for i := range resourceSummaries {
+			wg.Add(1)
+			go func(resourceSummary *libsveltosv1alpha1.ResourceSummary) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				errs <- detector.Track(context.TODO(), resourceSummary)
+			}(resourceSummaries[i])
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			Expect(err).To(BeNil())
+		}
+		Expect(detector.GetInformersNumber()).To(Equal(1))
+
+		// Same GroupVersionKind, different namespace
+		otherNamespace := getResourceSummary(namespace)
+		otherNamespace.Spec.Resources = []libsveltosv1alpha1.Resource{
+			{Group: "", Version: "v1", Kind: "ConfigMap", Namespace: "default", Name: randomString()},
+		}
+		Expect(detector.Track(context.TODO(), otherNamespace)).To(Succeed())
+		Expect(detector.GetInformersNumber()).To(Equal(2))
+	})
+
+	It("Untrack stops informers not used by any tracked ResourceSummary", func() {
+		first := getResourceSummary(namespace)
+		first.Spec.Resources = []libsveltosv1alpha1.Resource{
+			{Group: "", Version: "v1", Kind: "ConfigMap", Namespace: namespace, Name: randomString()},
+		}
+		second := getResourceSummary(namespace)
+		second.Spec.Resources = []libsveltosv1alpha1.Resource{
+			{Group: "", Version: "v1", Kind: "ConfigMap", Namespace: namespace, Name: randomString()},
+			{Group: "", Version: "v1", Kind: "Secret", Namespace: namespace, Name: randomString()},
+		}
+
+		Expect(detector.Track(context.TODO(), first)).To(Succeed())
+		Expect(detector.Track(context.TODO(), second)).To(Succeed())
+		Expect(detector.GetInformersNumber()).To(Equal(2))
+
+		// Secret informer is not used anymore
+		second.Spec.Resources = second.Spec.Resources[:1]
+		Expect(detector.Track(context.TODO(), second)).To(Succeed())
+		Expect(detector.GetInformersNumber()).To(Equal(1))
+
+		// ConfigMap informer is still used by first
+		detector.Untrack(second.Namespace, second.Name)
+		Expect(detector.GetInformersNumber()).To(Equal(1))
+
+		detector.Untrack(first.Namespace, first.Name)
+		Expect(detector.GetInformersNumber()).To(BeZero())
+
+		// Untracking twice is a no-op
+		detector.Untrack(first.Namespace, first.Name)
+		Expect(detector.GetInformersNumber()).To(BeZero())
+	})
+
+	It("Track returns an error and stops informer when cache is not synced", func() {
+		resourceSummary := getResourceSummary(namespace)
+		resourceSummary.Spec.Resources = []libsveltosv1alpha1.Resource{
+			{Group: "", Version: "v1", Kind: "ServiceAccount", Namespace: namespace, Name: randomString()},
+		}
+
+		canceledCtx, cancel := context.WithCancel(context.TODO())
+		cancel()
+
+		Expect(detector.Track(canceledCtx, resourceSummary)).ToNot(Succeed())
+		Expect(detector.GetInformersNumber()).To(BeZero())
+	})
+})
+
+func getResourceSummary(namespace string) *libsveltosv1alpha1.ResourceSummary {
+	return &libsveltosv1alpha1.ResourceSummary{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      randomString(),
+		},
+	}
+}
+
+func getCurrentResourceSummary(resourceSummary *libsveltosv1alpha1.ResourceSummary,
+) *libsveltosv1alpha1.ResourceSummary {
+
+	current := &libsveltosv1alpha1.ResourceSummary{}
+	err := testEnv.Get(context.TODO(),
+		types.NamespacedName{Namespace: resourceSummary.Namespace, Name: resourceSummary.Name}, current)
+	if err != nil {
+		return nil
+	}
+	return current
+}
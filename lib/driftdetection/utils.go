@@ -0,0 +1,180 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driftdetection
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+	"github.com/projectsveltos/libsveltos/lib/resourcehash"
+)
+
+type sectionResource struct {
+	resource libsveltosv1alpha1.Resource
+	section  section
+}
+
+// getResources returns all resources listed in a ResourceSummary, along with
+// the section each one belongs to
+func getResources(resourceSummary *libsveltosv1alpha1.ResourceSummary) []sectionResource {
+	resources := make([]sectionResource, 0)
+
+	for i := range resourceSummary.Spec.Resources {
+		resources = append(resources,
+			sectionResource{resource: resourceSummary.Spec.Resources[i], section: resourcesSection})
+	}
+
+	for i := range resourceSummary.Spec.KustomizeResources {
+		resources = append(resources,
+			sectionResource{resource: resourceSummary.Spec.KustomizeResources[i], section: kustomizeResourcesSection})
+	}
+
+	for i := range resourceSummary.Spec.ChartResources {
+		chart := &resourceSummary.Spec.ChartResources[i]
+		for j := range chart.Resources {
+			resources = append(resources,
+				sectionResource{resource: chart.Resources[j], section: helmResourcesSection})
+		}
+	}
+
+	return resources
+}
+
+// getResourceFromObject returns the Resource corresponding to an object received
+// by an informer
+func getResourceFromObject(obj interface{}) (*libsveltosv1alpha1.Resource, error) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+
+	gvk := u.GroupVersionKind()
+	return &libsveltosv1alpha1.Resource{
+		Group:     gvk.Group,
+		Version:   gvk.Version,
+		Kind:      gvk.Kind,
+		Namespace: u.GetNamespace(),
+		Name:      u.GetName(),
+	}, nil
+}
+
+// getCurrentHash returns the hash of resource as currently present in the informer cache.
+// Empty string is returned if resource does not exist.
+func getCurrentHash(informer cache.SharedIndexInformer, resource *libsveltosv1alpha1.Resource) (string, error) {
+	key := resource.Name
+	if resource.Namespace != "" {
+		key = fmt.Sprintf("%s/%s", resource.Namespace, resource.Name)
+	}
+
+	obj, exists, err := informer.GetStore().GetByKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	if !exists {
+		return "", nil
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return "", fmt.Errorf("unexpected object type %T", obj)
+	}
+
+	return resourcehash.Hash(u)
+}
+
+// updateResourceSummary records the resource hash in the ResourceSummary Status.
+// If a different hash was previously recorded, the section *Changed flag is set.
+func (d *Detector) updateResourceSummary(ctx context.Context, entry *trackingEntry,
+	resource *libsveltosv1alpha1.Resource, currentHash string) error {
+
+	logger := d.log.WithValues("resourcesummary", entry.resourceSummary.String(),
+		"resource", fmt.Sprintf("%s %s/%s", resource.Kind, resource.Namespace, resource.Name))
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		resourceSummary := &libsveltosv1alpha1.ResourceSummary{}
+		err := d.Get(ctx, types.NamespacedName{Namespace: entry.resourceSummary.Namespace,
+			Name: entry.resourceSummary.Name}, resourceSummary)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+
+		hashes := getHashes(&resourceSummary.Status, entry.section)
+
+		changed := false
+		found := false
+		for i := range *hashes {
+			if (*hashes)[i].Resource == *resource {
+				found = true
+				if (*hashes)[i].Hash == currentHash {
+					return nil
+				}
+				(*hashes)[i].Hash = currentHash
+				changed = true
+				break
+			}
+		}
+
+		if !found {
+			*hashes = append(*hashes, libsveltosv1alpha1.ResourceHash{Resource: *resource, Hash: currentHash})
+		}
+
+		if changed {
+			logger.V(logs.LogDebug).Info("configuration drift detected")
+			setChanged(&resourceSummary.Status, entry.section)
+		}
+
+		return d.Status().Update(ctx, resourceSummary)
+	})
+}
+
+func getHashes(status *libsveltosv1alpha1.ResourceSummaryStatus, s section) *[]libsveltosv1alpha1.ResourceHash {
+	switch s {
+	case kustomizeResourcesSection:
+		return &status.KustomizeResourceHashes
+	case helmResourcesSection:
+		return &status.HelmResourceHashes
+	default:
+		return &status.ResourceHashes
+	}
+}
+
+func setChanged(status *libsveltosv1alpha1.ResourceSummaryStatus, s section) {
+	switch s {
+	case kustomizeResourcesSection:
+		status.KustomizeResourcesChanged = true
+	case helmResourcesSection:
+		status.HelmResourcesChanged = true
+	default:
+		status.ResourcesChanged = true
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resourcehash
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/projectsveltos/libsveltos/lib/deployer"
+)
+
+// Different sveltos components (addon-manager, drift-detection-manager, ...)
+// need to compute the hash of the very same resource and compare results.
+// Hash must then be computed in a canonical way:
+// - fields populated by the API server are not considered;
+// - keys are sorted;
+// - fields users are not interested in (per GroupVersionKind) can be ignored.
+
+const (
+	lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+)
+
+var (
+	// serverPopulatedMetadataFields contains all metadata fields set by the API server.
+	serverPopulatedMetadataFields = []string{
+		"managedFields",
+		"resourceVersion",
+		"uid",
+		"generation",
+		"creationTimestamp",
+		"deletionTimestamp",
+		"deletionGracePeriodSeconds",
+		"selfLink",
+		"ownerReferences",
+	}
+
+	// ignoredAnnotations contains annotations which are not part of the
+	// desired state of a resource.
+	ignoredAnnotations = []string{
+		lastAppliedConfigAnnotation,
+		deployer.PolicyHash,
+	}
+)
+
+// IgnoreRule identifies a field not to be considered when hashing a resource.
+type IgnoreRule struct {
+	// Group of the resources this rule applies to. Empty string matches core group.
+	Group string
+
+	// Kind of the resources this rule applies to. Empty string matches any Kind
+	// (in which case Group is not considered).
+	Kind string
+
+	// Path is the path of the field to ignore (for instance []string{"spec", "replicas"}).
+	Path []string
+}
+
+// DefaultIgnoreRules contains fields which are set in managed clusters
+// by Kubernetes controllers and must not be considered when hashing.
+var DefaultIgnoreRules = []IgnoreRule{
+	{Group: "", Kind: "Service", Path: []string{"spec", "clusterIP"}},
+	{Group: "", Kind: "Service", Path: []string{"spec", "clusterIPs"}},
+	{Group: "", Kind: "ServiceAccount", Path: []string{"secrets"}},
+	{Group: "apps", Kind: "Deployment", Path: []string{"metadata", "annotations", "deployment.kubernetes.io/revision"}},
+}
+
+// Hasher computes canonical hashes of resources
+type Hasher struct {
+	rules []IgnoreRule
+}
+
+// New returns a Hasher ignoring, on top of server populated fields,
+// all fields identified by rules.
+func New(rules ...IgnoreRule) *Hasher {
+	return &Hasher{rules: rules}
+}
+
+// Hash returns the canonical hash of u using DefaultIgnoreRules.
+func Hash(u *unstructured.Unstructured) (string, error) {
+	return New(DefaultIgnoreRules...).Hash(u)
+}
+
+// Hash returns the canonical hash of u. u is not modified.
+func (h *Hasher) Hash(u *unstructured.Unstructured) (string, error) {
+	if u == nil {
+		return "", fmt.Errorf("resource is nil")
+	}
+
+	normalized := h.Normalize(u)
+
+	// encoding/json sorts map keys so output is deterministic
+	data, err := json.Marshal(normalized.Object)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal resource %s %s/%s: %w",
+			u.GetKind(), u.GetNamespace(), u.GetName(), err)
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// Normalize returns a copy of u with status, server populated fields and all
+// fields matching Hasher rules removed.
+func (h *Hasher) Normalize(u *unstructured.Unstructured) *unstructured.Unstructured {
+	normalized := u.DeepCopy()
+
+	unstructured.RemoveNestedField(normalized.Object, "status")
+	for i := range serverPopulatedMetadataFields {
+		unstructured.RemoveNestedField(normalized.Object, "metadata", serverPopulatedMetadataFields[i])
+	}
+	for i := range ignoredAnnotations {
+		unstructured.RemoveNestedField(normalized.Object, "metadata", "annotations", ignoredAnnotations[i])
+	}
+
+	gvk := normalized.GroupVersionKind()
+	for i := range h.rules {
+		if h.rules[i].matches(gvk) {
+			unstructured.RemoveNestedField(normalized.Object, h.rules[i].Path...)
+		}
+	}
+
+	removeEmptyMetadataMap(normalized, "annotations")
+	removeEmptyMetadataMap(normalized, "labels")
+
+	return normalized
+}
+
+func (r *IgnoreRule) matches(gvk schema.GroupVersionKind) bool {
+	if len(r.Path) == 0 {
+		return false
+	}
+
+	if r.Kind == "" {
+		return true
+	}
+
+	return r.Kind == gvk.Kind && r.Group == gvk.Group
+}
+
+// removeEmptyMetadataMap removes metadata field if it is an empty map.
+// No annotations and empty annotations must lead to same hash.
+func removeEmptyMetadataMap(u *unstructured.Unstructured, field string) {
+	m, found, err := unstructured.NestedMap(u.Object, "metadata", field)
+	if err == nil && found && len(m) == 0 {
+		unstructured.RemoveNestedField(u.Object, "metadata", field)
+	}
+}
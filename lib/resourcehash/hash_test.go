@@ -0,0 +1,180 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resourcehash_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/projectsveltos/libsveltos/lib/deployer"
+	"github.com/projectsveltos/libsveltos/lib/resourcehash"
+	"github.com/projectsveltos/libsveltos/lib/utils"
+)
+
+const (
+	deploymentTemplate = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: %s
+  namespace: %s
+  labels:
+    app: nginx
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: nginx
+  template:
+    metadata:
+      labels:
+        app: nginx
+    spec:
+      containers:
+      - name: nginx
+        image: nginx:1.14.2`
+
+	// Same Deployment as deploymentTemplate with different key order
+	// and fields populated by the API server
+	deploymentFromServerTemplate = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  annotations:
+    deployment.kubernetes.io/revision: "1"
+    projectsveltos.io/hash: %s
+  creationTimestamp: "2023-11-20T10:00:00Z"
+  generation: 1
+  labels:
+    app: nginx
+  managedFields:
+  - apiVersion: apps/v1
+    manager: addon-manager
+    operation: Apply
+  name: %s
+  namespace: %s
+  resourceVersion: "1234"
+  uid: %s
+spec:
+  template:
+    spec:
+      containers:
+      - image: nginx:1.14.2
+        name: nginx
+    metadata:
+      labels:
+        app: nginx
+  selector:
+    matchLabels:
+      app: nginx
+  replicas: 3
+status:
+  availableReplicas: 3`
+)
+
+var _ = Describe("Hash", func() {
+	var name, namespace string
+
+	BeforeEach(func() {
+		name = randomString()
+		namespace = randomString()
+	})
+
+	It("Hash ignores server populated fields and key order", func() {
+		u, err := utils.GetUnstructured([]byte(fmt.Sprintf(deploymentTemplate, name, namespace)))
+		Expect(err).To(BeNil())
+
+		fromServer, err := utils.GetUnstructured([]byte(fmt.Sprintf(deploymentFromServerTemplate,
+			randomString(), name, namespace, randomString())))
+		Expect(err).To(BeNil())
+
+		hash, err := resourcehash.Hash(u)
+		Expect(err).To(BeNil())
+		Expect(hash).ToNot(BeEmpty())
+
+		serverHash, err := resourcehash.Hash(fromServer)
+		Expect(err).To(BeNil())
+		Expect(serverHash).To(Equal(hash))
+	})
+
+	It("Hash does not modify resource", func() {
+		u, err := utils.GetUnstructured([]byte(fmt.Sprintf(deploymentFromServerTemplate,
+			randomString(), name, namespace, randomString())))
+		Expect(err).To(BeNil())
+
+		original := u.DeepCopy()
+		_, err = resourcehash.Hash(u)
+		Expect(err).To(BeNil())
+		Expect(u).To(Equal(original))
+	})
+
+	It("Hash changes when spec changes", func() {
+		u, err := utils.GetUnstructured([]byte(fmt.Sprintf(deploymentTemplate, name, namespace)))
+		Expect(err).To(BeNil())
+
+		hash, err := resourcehash.Hash(u)
+		Expect(err).To(BeNil())
+
+		Expect(unstructured.SetNestedField(u.Object, int64(5), "spec", "replicas")).To(Succeed())
+		newHash, err := resourcehash.Hash(u)
+		Expect(err).To(BeNil())
+		Expect(newHash).ToNot(Equal(hash))
+	})
+
+	It("Hasher ignores fields matching rules for the resource GroupVersionKind only", func() {
+		u, err := utils.GetUnstructured([]byte(fmt.Sprintf(deploymentTemplate, name, namespace)))
+		Expect(err).To(BeNil())
+
+		replicasRule := resourcehash.IgnoreRule{Group: "apps", Kind: "Deployment", Path: []string{"spec", "replicas"}}
+		hasher := resourcehash.New(replicasRule)
+
+		hash, err := hasher.Hash(u)
+		Expect(err).To(BeNil())
+
+		Expect(unstructured.SetNestedField(u.Object, int64(5), "spec", "replicas")).To(Succeed())
+		newHash, err := hasher.Hash(u)
+		Expect(err).To(BeNil())
+		Expect(newHash).To(Equal(hash))
+
+		otherKindRule := resourcehash.IgnoreRule{Group: "apps", Kind: "StatefulSet", Path: []string{"spec", "replicas"}}
+		hasher = resourcehash.New(otherKindRule)
+		Expect(unstructured.SetNestedField(u.Object, int64(3), "spec", "replicas")).To(Succeed())
+		hash, err = hasher.Hash(u)
+		Expect(err).To(BeNil())
+		Expect(unstructured.SetNestedField(u.Object, int64(5), "spec", "replicas")).To(Succeed())
+		newHash, err = hasher.Hash(u)
+		Expect(err).To(BeNil())
+		Expect(newHash).ToNot(Equal(hash))
+	})
+
+	It("Normalize removes status and sveltos annotations", func() {
+		u, err := utils.GetUnstructured([]byte(fmt.Sprintf(deploymentFromServerTemplate,
+			randomString(), name, namespace, randomString())))
+		Expect(err).To(BeNil())
+
+		normalized := resourcehash.New(resourcehash.DefaultIgnoreRules...).Normalize(u)
+		_, found, err := unstructured.NestedMap(normalized.Object, "status")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeFalse())
+		Expect(normalized.GetAnnotations()).ToNot(HaveKey(deployer.PolicyHash))
+		Expect(normalized.GetResourceVersion()).To(BeEmpty())
+		Expect(normalized.GetUID()).To(BeEmpty())
+		Expect(normalized.GetManagedFields()).To(BeEmpty())
+	})
+})
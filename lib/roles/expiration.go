@@ -0,0 +1,142 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package roles
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/projectsveltos/libsveltos/lib/utils"
+)
+
+const (
+	// IssuedAtAnnotation is the annotation, on Secrets created by CreateSecret, containing
+	// the time (RFC3339) the kubeconfig was stored
+	IssuedAtAnnotation = "projectsveltos.io/kubeconfig-issued-at"
+
+	// ExpiresAtAnnotation is the annotation, on Secrets created by CreateSecret, containing
+	// the time (RFC3339) the kubeconfig (token) expires. Not set if kubeconfig token
+	// has no expiration.
+	ExpiresAtAnnotation = "projectsveltos.io/kubeconfig-expires-at"
+)
+
+// GetExpiration returns the time kubeconfig stored in secret expires.
+// ExpiresAtAnnotation is used if valid. Otherwise (for instance, Secrets created before
+// expiration was tracked) the exp claim of the kubeconfig token is used.
+// Returns nil if expiration is not known (for instance, token has no exp claim).
+func GetExpiration(secret *corev1.Secret) *time.Time {
+	if value, ok := secret.GetAnnotations()[ExpiresAtAnnotation]; ok {
+		expiration, err := time.Parse(time.RFC3339, value)
+		if err == nil {
+			return &expiration
+		}
+	}
+
+	if claims := getTokenClaims(secret.Data[key]); claims != nil {
+		return claims.ExpiresAt
+	}
+	return nil
+}
+
+// NeedsRenewal returns true if kubeconfig stored in secret expires within skew.
+// Kubeconfig whose expiration is not known is considered non-expiring and never
+// needs renewal.
+func NeedsRenewal(secret *corev1.Secret, skew time.Duration) bool {
+	expiration := GetExpiration(secret)
+	if expiration == nil {
+		return false
+	}
+
+	return !time.Now().Add(skew).Before(*expiration)
+}
+
+// ListSecretsDueForRenewal returns all Secrets created for RoleRequests whose kubeconfig
+// expires within skew.
+func ListSecretsDueForRenewal(ctx context.Context, c client.Client, skew time.Duration,
+) ([]corev1.Secret, error) {
+
+	secrets, err := ListSecrets(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]corev1.Secret, 0)
+	for i := range secrets {
+		if NeedsRenewal(&secrets[i], skew) {
+			results = append(results, secrets[i])
+		}
+	}
+
+	return results, nil
+}
+
+// getTokenClaims returns the claims of the token kubeconfig current context authenticates with.
+// Returns nil if kubeconfig does not authenticate with a JWT bearer token.
+func getTokenClaims(kubeconfig []byte) *utils.TokenClaims {
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil
+	}
+
+	currentContext, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return nil
+	}
+
+	authInfo, ok := config.AuthInfos[currentContext.AuthInfo]
+	if !ok || authInfo.Token == "" {
+		return nil
+	}
+
+	claims, err := utils.GetTokenClaims(authInfo.Token)
+	if err != nil {
+		return nil
+	}
+	return claims
+}
+
+// setExpirationAnnotations records, on secret, that a new kubeconfig was just stored.
+// Issue and expiration time are read from the kubeconfig token claims (iat and exp).
+// If iat is not available, kubeconfig is considered issued now. If exp is not available,
+// expiration is not known and ExpiresAtAnnotation is removed.
+func setExpirationAnnotations(secret *corev1.Secret, kubeconfig []byte) {
+	issuedAt := time.Now()
+	var expiresAt *time.Time
+
+	if claims := getTokenClaims(kubeconfig); claims != nil {
+		if claims.IssuedAt != nil {
+			issuedAt = *claims.IssuedAt
+		}
+		expiresAt = claims.ExpiresAt
+	}
+
+	annotations := secret.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[IssuedAtAnnotation] = issuedAt.UTC().Format(time.RFC3339)
+	if expiresAt != nil {
+		annotations[ExpiresAtAnnotation] = expiresAt.UTC().Format(time.RFC3339)
+	} else {
+		delete(annotations, ExpiresAtAnnotation)
+	}
+	secret.SetAnnotations(annotations)
+}
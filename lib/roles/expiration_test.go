@@ -0,0 +1,209 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package roles_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	sveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/crd"
+	"github.com/projectsveltos/libsveltos/lib/roles"
+	"github.com/projectsveltos/libsveltos/lib/utils"
+)
+
+func getSecretExpiringAt(expiration *time.Time) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: randomString(),
+			Name:      randomString(),
+			Labels: map[string]string{
+				sveltosv1alpha1.RoleRequestLabel: "ok",
+			},
+		},
+	}
+
+	if expiration != nil {
+		secret.Annotations = map[string]string{
+			roles.ExpiresAtAnnotation: expiration.UTC().Format(time.RFC3339),
+		}
+	}
+
+	return secret
+}
+
+// getKubeconfigWithToken returns a kubeconfig authenticating with a JWT
+// issued at issuedAt and expiring at expiresAt
+func getKubeconfigWithToken(issuedAt, expiresAt time.Time) []byte {
+	payload := fmt.Sprintf(`{"sub":"system:serviceaccount:default:%s","iat":%d,"exp":%d}`,
+		randomString(), issuedAt.Unix(), expiresAt.Unix())
+	token := "header." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters["sveltos"] = &clientcmdapi.Cluster{Server: "https://10.0.0.1:6443"}
+	kubeconfig.AuthInfos["sveltos"] = &clientcmdapi.AuthInfo{Token: token}
+	kubeconfig.Contexts["sveltos"] = &clientcmdapi.Context{Cluster: "sveltos", AuthInfo: "sveltos"}
+	kubeconfig.CurrentContext = "sveltos"
+
+	data, err := clientcmd.Write(*kubeconfig)
+	Expect(err).To(BeNil())
+	return data
+}
+
+var _ = Describe("Roles expiration", func() {
+	It("CreateSecret does not record an expiration when kubeconfig token has none", func() {
+		clusterNamespace := randomString()
+		clusterName := randomString()
+		serviceAccountNamespace := randomString()
+		serviceaccountName := randomString()
+
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		roleRequestCRD, err := utils.GetUnstructured(crd.GetRoleRequestCRDYAML())
+		Expect(err).To(BeNil())
+		Expect(c.Create(context.TODO(), roleRequestCRD)).To(Succeed())
+
+		roleRequest := &sveltosv1alpha1.RoleRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+			},
+			Spec: sveltosv1alpha1.RoleRequestSpec{
+				ExpirationSeconds: pointer.Int64(7200),
+			},
+		}
+
+		issuedAt := time.Now().Add(-5 * time.Minute).Truncate(time.Second)
+		expiresAt := issuedAt.Add(time.Hour)
+		secret, err := roles.CreateSecret(context.TODO(), c,
+			clusterNamespace, clusterName, serviceAccountNamespace, serviceaccountName,
+			sveltosv1alpha1.ClusterTypeSveltos, getKubeconfigWithToken(issuedAt, expiresAt), roleRequest)
+		Expect(err).To(BeNil())
+		Expect(secret.Annotations).To(HaveKey(roles.ExpiresAtAnnotation))
+
+		// New kubeconfig with no expiration: previous expiration is removed and
+		// kubeconfig is never considered due for renewal
+		secret, err = roles.CreateSecret(context.TODO(), c,
+			clusterNamespace, clusterName, serviceAccountNamespace, serviceaccountName,
+			sveltosv1alpha1.ClusterTypeSveltos, []byte(randomString()), roleRequest)
+		Expect(err).To(BeNil())
+
+		Expect(secret.Annotations).To(HaveKey(roles.IssuedAtAnnotation))
+		storedAt, err := time.Parse(time.RFC3339, secret.Annotations[roles.IssuedAtAnnotation])
+		Expect(err).To(BeNil())
+		Expect(storedAt).To(BeTemporally("~", time.Now(), time.Minute))
+		Expect(secret.Annotations).ToNot(HaveKey(roles.ExpiresAtAnnotation))
+
+		Expect(roles.GetExpiration(secret)).To(BeNil())
+		Expect(roles.NeedsRenewal(secret, 24*time.Hour)).To(BeFalse())
+	})
+
+	It("CreateSecret records issue time and expiration from kubeconfig token claims", func() {
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		roleRequestCRD, err := utils.GetUnstructured(crd.GetRoleRequestCRDYAML())
+		Expect(err).To(BeNil())
+		Expect(c.Create(context.TODO(), roleRequestCRD)).To(Succeed())
+
+		roleRequest := &sveltosv1alpha1.RoleRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+			},
+			Spec: sveltosv1alpha1.RoleRequestSpec{
+				ExpirationSeconds: pointer.Int64(7200),
+			},
+		}
+
+		// API server issued a token valid for less than requested
+		issuedAt := time.Now().Add(-5 * time.Minute).Truncate(time.Second)
+		expiresAt := issuedAt.Add(30 * time.Minute)
+
+		secret, err := roles.CreateSecret(context.TODO(), c,
+			randomString(), randomString(), randomString(), randomString(),
+			sveltosv1alpha1.ClusterTypeSveltos, getKubeconfigWithToken(issuedAt, expiresAt), roleRequest)
+		Expect(err).To(BeNil())
+
+		Expect(secret.Annotations[roles.IssuedAtAnnotation]).To(Equal(issuedAt.UTC().Format(time.RFC3339)))
+		expiration := roles.GetExpiration(secret)
+		Expect(expiration).ToNot(BeNil())
+		Expect(expiration.Equal(expiresAt)).To(BeTrue())
+
+		Expect(roles.NeedsRenewal(secret, time.Minute)).To(BeFalse())
+		Expect(roles.NeedsRenewal(secret, time.Hour)).To(BeTrue())
+	})
+
+	It("GetExpiration falls back to kubeconfig token claims when annotation is missing or invalid", func() {
+		secret := getSecretExpiringAt(nil)
+		Expect(roles.GetExpiration(secret)).To(BeNil())
+		Expect(roles.NeedsRenewal(secret, 24*time.Hour)).To(BeFalse())
+
+		secret.Annotations = map[string]string{roles.ExpiresAtAnnotation: randomString()}
+		Expect(roles.GetExpiration(secret)).To(BeNil())
+		Expect(roles.NeedsRenewal(secret, 24*time.Hour)).To(BeFalse())
+
+		expiresAt := time.Now().Add(5 * time.Minute).Truncate(time.Second)
+		secret.Data = map[string][]byte{
+			roles.Key: getKubeconfigWithToken(expiresAt.Add(-time.Hour), expiresAt),
+		}
+		expiration := roles.GetExpiration(secret)
+		Expect(expiration).ToNot(BeNil())
+		Expect(expiration.Equal(expiresAt)).To(BeTrue())
+		Expect(roles.NeedsRenewal(secret, time.Minute)).To(BeFalse())
+		Expect(roles.NeedsRenewal(secret, 10*time.Minute)).To(BeTrue())
+	})
+
+	It("ListSecretsDueForRenewal returns secrets expiring within skew", func() {
+		expired := time.Now().Add(-time.Minute)
+		expiringSoon := time.Now().Add(5 * time.Minute)
+		valid := time.Now().Add(time.Hour)
+
+		expiredSecret := getSecretExpiringAt(&expired)
+		expiringSoonSecret := getSecretExpiringAt(&expiringSoon)
+		validSecret := getSecretExpiringAt(&valid)
+		// Secrets created before expiration was tracked
+		legacySecret := getSecretExpiringAt(nil)
+		legacyExpiringSoonSecret := getSecretExpiringAt(nil)
+		legacyExpiringSoonSecret.Data = map[string][]byte{
+			roles.Key: getKubeconfigWithToken(expiringSoon.Add(-time.Hour), expiringSoon),
+		}
+
+		initObjects := []client.Object{expiredSecret, expiringSoonSecret, validSecret, legacySecret,
+			legacyExpiringSoonSecret}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		list, err := roles.ListSecretsDueForRenewal(context.TODO(), c, 10*time.Minute)
+		Expect(err).To(BeNil())
+
+		names := make([]string, len(list))
+		for i := range list {
+			names[i] = list[i].Name
+		}
+		Expect(names).To(ConsistOf(expiredSecret.Name, expiringSoonSecret.Name, legacyExpiringSoonSecret.Name))
+	})
+})
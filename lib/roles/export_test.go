@@ -18,7 +18,12 @@ package roles
 
 const (
 	ClusterNameLabel             = clusterNameLabel
+	ClusterTypeLabel             = clusterTypeLabel
 	ServiceAccountNameLabel      = serviceAccountNameLabel
 	ServiceAccountNamespaceLabel = serviceAccountNamespaceLabel
 	Key                          = key
 )
+
+var (
+	GetLabelValue = getLabelValue
+)
@@ -0,0 +1,231 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package roles
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	sveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+// GarbageCollectionReport lists the changes made (or, in dry-run mode, that would be made)
+// by GarbageCollectSecrets
+type GarbageCollectionReport struct {
+	// UpdatedSecrets contains Secrets from which OwnerReferences to RoleRequests
+	// not existing anymore were removed
+	UpdatedSecrets []types.NamespacedName
+
+	// DeletedSecrets contains Secrets that were deleted, with the reason
+	DeletedSecrets map[types.NamespacedName]string
+}
+
+const (
+	reasonClusterNotFound = "cluster not found"
+	reasonNoOwnerLeft     = "no existing RoleRequest owner left"
+)
+
+// GarbageCollectSecrets scans all Secrets created for RoleRequests (see ListSecrets) and:
+// - removes OwnerReferences to RoleRequests that do not exist anymore;
+// - deletes Secrets with no RoleRequest owner left;
+// - deletes Secrets whose cluster does not exist anymore.
+// If dryRun is true, nothing is changed and the returned report contains what would be changed.
+func GarbageCollectSecrets(ctx context.Context, c client.Client, dryRun bool,
+	logger logr.Logger) (*GarbageCollectionReport, error) {
+
+	secrets, err := ListSecrets(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &GarbageCollectionReport{
+		UpdatedSecrets: make([]types.NamespacedName, 0),
+		DeletedSecrets: make(map[types.NamespacedName]string),
+	}
+
+	for i := range secrets {
+		secret := &secrets[i]
+		secretName := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+		l := logger.WithValues("secret", secretName.String(), "dryRun", dryRun)
+
+		clusterExists, err := isClusterPresent(ctx, c, secret)
+		if err != nil {
+			return report, err
+		}
+		if !clusterExists {
+			l.V(logs.LogDebug).Info(fmt.Sprintf("deleting secret: %s", reasonClusterNotFound))
+			if err := deleteSecret(ctx, c, secret, dryRun); err != nil {
+				return report, err
+			}
+			report.DeletedSecrets[secretName] = reasonClusterNotFound
+			continue
+		}
+
+		staleOwners, err := getStaleRoleRequestOwners(ctx, c, secret)
+		if err != nil {
+			return report, err
+		}
+		if len(staleOwners) == 0 {
+			continue
+		}
+
+		ownerReferences := removeOwnerReferences(secret.OwnerReferences, staleOwners)
+		if len(ownerReferences) == 0 {
+			l.V(logs.LogDebug).Info(fmt.Sprintf("deleting secret: %s", reasonNoOwnerLeft))
+			if err := deleteSecret(ctx, c, secret, dryRun); err != nil {
+				return report, err
+			}
+			report.DeletedSecrets[secretName] = reasonNoOwnerLeft
+			continue
+		}
+
+		l.V(logs.LogDebug).Info(fmt.Sprintf("removing %d stale RoleRequest OwnerReferences", len(staleOwners)))
+		if !dryRun {
+			secret.OwnerReferences = ownerReferences
+			if err := c.Update(ctx, secret); err != nil {
+				return report, err
+			}
+		}
+		report.UpdatedSecrets = append(report.UpdatedSecrets, secretName)
+	}
+
+	return report, nil
+}
+
+func deleteSecret(ctx context.Context, c client.Client, secret *corev1.Secret, dryRun bool) error {
+	if dryRun {
+		return nil
+	}
+
+	if err := c.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// isClusterPresent returns true if the cluster secret was created for still exists.
+// Secrets created before cluster type was recorded are checked against all cluster types.
+// Cluster is considered gone only when it is not found. If its type is not registered in
+// the client scheme, or its CRD is not installed, cluster is considered present.
+func isClusterPresent(ctx context.Context, c client.Client, secret *corev1.Secret) (bool, error) {
+	labels := secret.GetLabels()
+	clusterName := getSecretValue(secret, clusterNameLabel)
+	if clusterName == "" {
+		// Not enough information to identify the cluster
+		return true, nil
+	}
+
+	clusterTypes := []sveltosv1alpha1.ClusterType{sveltosv1alpha1.ClusterTypeSveltos, sveltosv1alpha1.ClusterTypeCapi}
+	if clusterType, ok := labels[clusterTypeLabel]; ok && clusterType != "" {
+		clusterTypes = []sveltosv1alpha1.ClusterType{sveltosv1alpha1.ClusterType(clusterType)}
+	}
+
+	for i := range clusterTypes {
+		var cluster client.Object
+		switch clusterTypes[i] {
+		case sveltosv1alpha1.ClusterTypeSveltos:
+			cluster = &sveltosv1alpha1.SveltosCluster{}
+		case sveltosv1alpha1.ClusterTypeCapi:
+			cluster = &clusterv1.Cluster{}
+		default:
+			// Unknown cluster type. Never consider such cluster gone.
+			return true, nil
+		}
+
+		err := c.Get(ctx, types.NamespacedName{Namespace: secret.Namespace, Name: clusterName}, cluster)
+		if err == nil {
+			return true, nil
+		}
+		if meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err) {
+			// Cluster type is not known to the client (scheme) or not installed. Cannot tell
+			// whether cluster exists, so never consider it gone.
+			return true, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// getStaleRoleRequestOwners returns the secret OwnerReferences pointing to RoleRequests that
+// do not exist anymore (or that were recreated, so with a different UID)
+func getStaleRoleRequestOwners(ctx context.Context, c client.Client, secret *corev1.Secret,
+) ([]metav1.OwnerReference, error) {
+
+	stale := make([]metav1.OwnerReference, 0)
+	for i := range secret.OwnerReferences {
+		ref := &secret.OwnerReferences[i]
+		if ref.Kind != sveltosv1alpha1.RoleRequestKind {
+			continue
+		}
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil || gv.Group != sveltosv1alpha1.GroupVersion.Group {
+			continue
+		}
+
+		roleRequest := &sveltosv1alpha1.RoleRequest{}
+		err = c.Get(ctx, types.NamespacedName{Name: ref.Name}, roleRequest)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				stale = append(stale, *ref)
+				continue
+			}
+			return nil, err
+		}
+
+		if ref.UID != "" && roleRequest.UID != ref.UID {
+			stale = append(stale, *ref)
+		}
+	}
+
+	return stale, nil
+}
+
+func removeOwnerReferences(ownerReferences, toRemove []metav1.OwnerReference) []metav1.OwnerReference {
+	result := make([]metav1.OwnerReference, 0, len(ownerReferences))
+	for i := range ownerReferences {
+		found := false
+		for j := range toRemove {
+			if ownerReferences[i].APIVersion == toRemove[j].APIVersion &&
+				ownerReferences[i].Kind == toRemove[j].Kind &&
+				ownerReferences[i].Name == toRemove[j].Name &&
+				ownerReferences[i].UID == toRemove[j].UID {
+
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, ownerReferences[i])
+		}
+	}
+	return result
+}
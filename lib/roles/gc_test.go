@@ -0,0 +1,191 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package roles_test
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/klogr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	sveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/roles"
+)
+
+func getRoleRequestOwnerReference(name, uid string) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: sveltosv1alpha1.GroupVersion.String(),
+		Kind:       sveltosv1alpha1.RoleRequestKind,
+		Name:       name,
+		UID:        types.UID(uid),
+	}
+}
+
+func getRoleRequestSecret(namespace, clusterName string, clusterType *sveltosv1alpha1.ClusterType,
+	owners ...metav1.OwnerReference) *corev1.Secret {
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      randomString(),
+			Labels: map[string]string{
+				sveltosv1alpha1.RoleRequestLabel: "ok",
+				roles.ClusterNameLabel:           clusterName,
+			},
+			OwnerReferences: owners,
+		},
+	}
+	if clusterType != nil {
+		secret.Labels[roles.ClusterTypeLabel] = string(*clusterType)
+	}
+	return secret
+}
+
+var _ = Describe("Roles garbage collection", func() {
+	It("GarbageCollectSecrets removes stale owners and deletes orphaned secrets", func() {
+		sveltosCluster := &sveltosv1alpha1.SveltosCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+			},
+		}
+
+		roleRequest := &sveltosv1alpha1.RoleRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+				UID:  types.UID(randomString()),
+			},
+		}
+
+		clusterType := sveltosv1alpha1.ClusterTypeSveltos
+		existingOwner := getRoleRequestOwnerReference(roleRequest.Name, string(roleRequest.UID))
+		goneOwner := getRoleRequestOwnerReference(randomString(), randomString())
+		recreatedOwner := getRoleRequestOwnerReference(roleRequest.Name, randomString())
+
+		updated := getRoleRequestSecret(sveltosCluster.Namespace, sveltosCluster.Name, &clusterType,
+			existingOwner, goneOwner)
+		noOwnerLeft := getRoleRequestSecret(sveltosCluster.Namespace, sveltosCluster.Name, &clusterType,
+			goneOwner)
+		ownerRecreated := getRoleRequestSecret(sveltosCluster.Namespace, sveltosCluster.Name, &clusterType,
+			recreatedOwner)
+		// Legacy secret with no cluster type
+		clusterGone := getRoleRequestSecret(sveltosCluster.Namespace, randomString(), nil, existingOwner)
+		untouched := getRoleRequestSecret(sveltosCluster.Namespace, sveltosCluster.Name, nil, existingOwner)
+
+		initObjects := []client.Object{sveltosCluster, roleRequest, updated, noOwnerLeft,
+			ownerRecreated, clusterGone, untouched}
+		capiScheme, err := setupScheme()
+		Expect(err).To(BeNil())
+		Expect(clusterv1.AddToScheme(capiScheme)).To(Succeed())
+		c := fake.NewClientBuilder().WithScheme(capiScheme).WithObjects(initObjects...).Build()
+
+		expectedDeleted := map[types.NamespacedName]string{
+			{Namespace: noOwnerLeft.Namespace, Name: noOwnerLeft.Name}:       "no existing RoleRequest owner left",
+			{Namespace: ownerRecreated.Namespace, Name: ownerRecreated.Name}: "no existing RoleRequest owner left",
+			{Namespace: clusterGone.Namespace, Name: clusterGone.Name}:       "cluster not found",
+		}
+
+		By("Running in dry-run mode")
+		report, err := roles.GarbageCollectSecrets(context.TODO(), c, true, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(report.UpdatedSecrets).To(ConsistOf(types.NamespacedName{Namespace: updated.Namespace, Name: updated.Name}))
+		Expect(report.DeletedSecrets).To(Equal(expectedDeleted))
+
+		secrets, err := roles.ListSecrets(context.TODO(), c)
+		Expect(err).To(BeNil())
+		Expect(secrets).To(HaveLen(5))
+		currentSecret := &corev1.Secret{}
+		Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(updated), currentSecret)).To(Succeed())
+		Expect(currentSecret.OwnerReferences).To(HaveLen(2))
+
+		By("Running garbage collection")
+		report, err = roles.GarbageCollectSecrets(context.TODO(), c, false, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(report.UpdatedSecrets).To(ConsistOf(types.NamespacedName{Namespace: updated.Namespace, Name: updated.Name}))
+		Expect(report.DeletedSecrets).To(Equal(expectedDeleted))
+
+		secrets, err = roles.ListSecrets(context.TODO(), c)
+		Expect(err).To(BeNil())
+		Expect(secrets).To(HaveLen(2))
+		Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(updated), currentSecret)).To(Succeed())
+		Expect(currentSecret.OwnerReferences).To(ConsistOf(existingOwner))
+		Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(untouched), currentSecret)).To(Succeed())
+
+		By("Running garbage collection again")
+		report, err = roles.GarbageCollectSecrets(context.TODO(), c, false, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(report.UpdatedSecrets).To(BeEmpty())
+		Expect(report.DeletedSecrets).To(BeEmpty())
+	})
+
+	It("GarbageCollectSecrets does not delete secrets when cluster type is not in scheme", func() {
+		namespace := randomString()
+		capiClusterType := sveltosv1alpha1.ClusterTypeCapi
+		roleRequest := &sveltosv1alpha1.RoleRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+				UID:  types.UID(randomString()),
+			},
+		}
+		owner := getRoleRequestOwnerReference(roleRequest.Name, string(roleRequest.UID))
+
+		capiSecret := getRoleRequestSecret(namespace, randomString(), &capiClusterType, owner)
+		// Legacy secret with no cluster type
+		legacySecret := getRoleRequestSecret(namespace, randomString(), nil, owner)
+
+		// scheme does not contain ClusterAPI Cluster
+		initObjects := []client.Object{roleRequest, capiSecret, legacySecret}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		report, err := roles.GarbageCollectSecrets(context.TODO(), c, false, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(report.DeletedSecrets).To(BeEmpty())
+
+		secrets, err := roles.ListSecrets(context.TODO(), c)
+		Expect(err).To(BeNil())
+		Expect(secrets).To(HaveLen(2))
+	})
+
+	It("DeleteSecret returns error when listing secrets fails", func() {
+		c := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, client client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				return apierrors.NewInternalError(fmt.Errorf("list failed"))
+			},
+		}).Build()
+
+		roleRequest := &sveltosv1alpha1.RoleRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+			},
+		}
+
+		err := roles.DeleteSecret(context.TODO(), c, randomString(), randomString(), randomString(), randomString(),
+			sveltosv1alpha1.ClusterTypeSveltos, roleRequest)
+		Expect(err).ToNot(BeNil())
+	})
+})
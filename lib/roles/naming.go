@@ -0,0 +1,170 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package roles
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+// Secrets are looked up by cluster name, service account namespace and service account name
+// labels. Kubernetes names can be longer than what a label value can contain (63 characters).
+// So each value is stored:
+// - as label, when it is a valid label value, or as a (valid label value) hash of it otherwise;
+// - always in full as annotation (same key as the label).
+// Lookups list by labels and then filter by full values.
+// Secrets created before annotations were introduced only have labels (which, for them,
+// always contain the full value). MigrateSecrets adds the missing annotations.
+
+const (
+	// hashedLabelValuePrefix is the prefix of label values containing an hash
+	hashedLabelValuePrefix = "sha256-"
+)
+
+var (
+	lookupKeys = []string{clusterNameLabel, serviceAccountNamespaceLabel, serviceAccountNameLabel}
+)
+
+// getLabelValue returns value if it is a valid label value. Otherwise, returns a hash of value
+// which is a valid label value.
+func getLabelValue(value string) string {
+	if len(validation.IsValidLabelValue(value)) == 0 {
+		return value
+	}
+
+	return hashedLabelValuePrefix + getSha256(value)[:validation.LabelValueMaxLength-len(hashedLabelValuePrefix)]
+}
+
+// getLookupValues returns, per lookup key, the full value
+func getLookupValues(clusterName, serviceAccountNamespace, serviceAccountName string) map[string]string {
+	return map[string]string{
+		clusterNameLabel:             clusterName,
+		serviceAccountNamespaceLabel: serviceAccountNamespace,
+		serviceAccountNameLabel:      serviceAccountName,
+	}
+}
+
+// getLookupLabels returns the labels, used for lookups, a Secret with values must have
+func getLookupLabels(values map[string]string) map[string]string {
+	labels := make(map[string]string, len(values))
+	for k := range values {
+		labels[k] = getLabelValue(values[k])
+	}
+	return labels
+}
+
+// getSecretValue returns the full value stored in secret for key
+func getSecretValue(secret *corev1.Secret, key string) string {
+	if value, ok := secret.Annotations[key]; ok {
+		return value
+	}
+	return secret.Labels[key]
+}
+
+// isSecretAMatch returns true if secret full values match values. Required as
+// different values can have the same label value (hash).
+func isSecretAMatch(secret *corev1.Secret, values map[string]string) bool {
+	for k := range values {
+		if getSecretValue(secret, k) != values[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// setLookupMetadata sets on secret labels and annotations for values
+func setLookupMetadata(secret *corev1.Secret, values map[string]string) {
+	if secret.Labels == nil {
+		secret.Labels = map[string]string{}
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+
+	for k := range values {
+		secret.Labels[k] = getLabelValue(values[k])
+		secret.Annotations[k] = values[k]
+	}
+}
+
+// listSecrets returns all Secrets storing kubeconfig for serviceAccountNamespace/serviceAccountName in cluster
+func listSecrets(ctx context.Context, c client.Client,
+	clusterNamespace, clusterName, serviceAccountNamespace, serviceAccountName string) ([]corev1.Secret, error) {
+
+	values := getLookupValues(clusterName, serviceAccountNamespace, serviceAccountName)
+
+	secretList := &corev1.SecretList{}
+	err := c.List(ctx, secretList, client.InNamespace(clusterNamespace), client.MatchingLabels(getLookupLabels(values)))
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]corev1.Secret, 0, len(secretList.Items))
+	for i := range secretList.Items {
+		if isSecretAMatch(&secretList.Items[i], values) {
+			results = append(results, secretList.Items[i])
+		}
+	}
+
+	return results, nil
+}
+
+// MigrateSecrets updates all Secrets created for RoleRequests (see ListSecrets) to the current
+// labelling scheme, adding the annotations containing full lookup values.
+// Returns the number of Secrets updated.
+func MigrateSecrets(ctx context.Context, c client.Client, logger logr.Logger) (int, error) {
+	secrets, err := ListSecrets(ctx, c)
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for i := range secrets {
+		secret := &secrets[i]
+
+		values := make(map[string]string)
+		for _, k := range lookupKeys {
+			if value := getSecretValue(secret, k); value != "" {
+				values[k] = value
+			}
+		}
+
+		migrated := secret.DeepCopy()
+		setLookupMetadata(migrated, values)
+		if reflect.DeepEqual(migrated.Labels, secret.Labels) &&
+			reflect.DeepEqual(migrated.Annotations, secret.Annotations) {
+
+			continue
+		}
+
+		logger.V(logs.LogDebug).Info(fmt.Sprintf("migrating secret %s/%s", secret.Namespace, secret.Name))
+		if err := c.Update(ctx, migrated); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package roles_test
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	sveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/crd"
+	"github.com/projectsveltos/libsveltos/lib/roles"
+	"github.com/projectsveltos/libsveltos/lib/utils"
+)
+
+var _ = Describe("Roles naming", func() {
+	It("CreateSecret supports names longer than label values", func() {
+		clusterNamespace := randomString()
+		clusterName := strings.Repeat("c", 100)
+		serviceAccountNamespace := randomString()
+		serviceAccountName := strings.Repeat("a", 200)
+		otherServiceAccountName := strings.Repeat("a", 199) + "b"
+
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		roleRequestCRD, err := utils.GetUnstructured(crd.GetRoleRequestCRDYAML())
+		Expect(err).To(BeNil())
+		Expect(c.Create(context.TODO(), roleRequestCRD)).To(Succeed())
+
+		roleRequest := &sveltosv1alpha1.RoleRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+			},
+		}
+		Expect(addTypeInformationToObject(scheme, roleRequest)).To(Succeed())
+
+		kubeconfig := []byte(randomString())
+		secret, err := roles.CreateSecret(context.TODO(), c,
+			clusterNamespace, clusterName, serviceAccountNamespace, serviceAccountName,
+			sveltosv1alpha1.ClusterTypeSveltos, kubeconfig, roleRequest)
+		Expect(err).To(BeNil())
+
+		for k, v := range secret.Labels {
+			Expect(validation.IsValidLabelValue(v)).To(BeEmpty(), k)
+		}
+		Expect(secret.Annotations[roles.ServiceAccountNameLabel]).To(Equal(serviceAccountName))
+		Expect(secret.Annotations[roles.ClusterNameLabel]).To(Equal(clusterName))
+		Expect(secret.Labels[roles.ServiceAccountNamespaceLabel]).To(Equal(serviceAccountNamespace))
+
+		currentKubeconfig, err := roles.GetKubeconfig(context.TODO(), c,
+			clusterNamespace, clusterName, serviceAccountNamespace, serviceAccountName,
+			sveltosv1alpha1.ClusterTypeSveltos)
+		Expect(err).To(BeNil())
+		Expect(currentKubeconfig).To(Equal(kubeconfig))
+
+		// A different long service account is stored in a different secret
+		otherSecret, err := roles.CreateSecret(context.TODO(), c,
+			clusterNamespace, clusterName, serviceAccountNamespace, otherServiceAccountName,
+			sveltosv1alpha1.ClusterTypeSveltos, []byte(randomString()), roleRequest)
+		Expect(err).To(BeNil())
+		Expect(otherSecret.Name).ToNot(Equal(secret.Name))
+
+		currentSecret, err := roles.GetSecret(context.TODO(), c,
+			clusterNamespace, clusterName, serviceAccountNamespace, serviceAccountName,
+			sveltosv1alpha1.ClusterTypeSveltos)
+		Expect(err).To(BeNil())
+		Expect(currentSecret.Name).To(Equal(secret.Name))
+
+		Expect(roles.DeleteSecret(context.TODO(), c,
+			clusterNamespace, clusterName, serviceAccountNamespace, serviceAccountName,
+			sveltosv1alpha1.ClusterTypeSveltos, roleRequest)).To(Succeed())
+
+		currentSecret, err = roles.GetSecret(context.TODO(), c,
+			clusterNamespace, clusterName, serviceAccountNamespace, serviceAccountName,
+			sveltosv1alpha1.ClusterTypeSveltos)
+		Expect(err).To(BeNil())
+		Expect(currentSecret).To(BeNil())
+	})
+
+	It("GetSecret ignores secrets with same label values but different full values", func() {
+		clusterNamespace := randomString()
+		clusterName := randomString()
+		serviceAccountNamespace := randomString()
+		serviceAccountName := strings.Repeat("a", 100)
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: clusterNamespace,
+				Name:      randomString(),
+				Labels: map[string]string{
+					roles.ClusterNameLabel:             clusterName,
+					roles.ServiceAccountNameLabel:      roles.GetLabelValue(serviceAccountName),
+					roles.ServiceAccountNamespaceLabel: serviceAccountNamespace,
+				},
+				Annotations: map[string]string{
+					roles.ServiceAccountNameLabel: randomString(),
+				},
+			},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build()
+
+		currentSecret, err := roles.GetSecret(context.TODO(), c,
+			clusterNamespace, clusterName, serviceAccountNamespace, serviceAccountName,
+			sveltosv1alpha1.ClusterTypeSveltos)
+		Expect(err).To(BeNil())
+		Expect(currentSecret).To(BeNil())
+	})
+
+	It("MigrateSecrets adds full value annotations to existing secrets", func() {
+		clusterNamespace := randomString()
+		clusterName := randomString()
+		serviceAccountNamespace := randomString()
+		serviceAccountName := randomString()
+
+		legacySecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: clusterNamespace,
+				Name:      randomString(),
+				Labels: map[string]string{
+					roles.ClusterNameLabel:             clusterName,
+					roles.ServiceAccountNameLabel:      serviceAccountName,
+					roles.ServiceAccountNamespaceLabel: serviceAccountNamespace,
+					sveltosv1alpha1.RoleRequestLabel:   "ok",
+				},
+			},
+		}
+
+		initObjects := []client.Object{legacySecret}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		updated, err := roles.MigrateSecrets(context.TODO(), c, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(updated).To(Equal(1))
+
+		currentSecret := &corev1.Secret{}
+		Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(legacySecret), currentSecret)).To(Succeed())
+		Expect(currentSecret.Annotations[roles.ClusterNameLabel]).To(Equal(clusterName))
+		Expect(currentSecret.Annotations[roles.ServiceAccountNameLabel]).To(Equal(serviceAccountName))
+		Expect(currentSecret.Annotations[roles.ServiceAccountNamespaceLabel]).To(Equal(serviceAccountNamespace))
+		Expect(currentSecret.Labels[sveltosv1alpha1.RoleRequestLabel]).To(Equal("ok"))
+
+		updated, err = roles.MigrateSecrets(context.TODO(), c, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(updated).To(BeZero())
+
+		secret, err := roles.GetSecret(context.TODO(), c,
+			clusterNamespace, clusterName, serviceAccountNamespace, serviceAccountName,
+			sveltosv1alpha1.ClusterTypeSveltos)
+		Expect(err).To(BeNil())
+		Expect(secret).ToNot(BeNil())
+		Expect(secret.Name).To(Equal(legacySecret.Name))
+	})
+})
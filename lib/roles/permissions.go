@@ -0,0 +1,381 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package roles
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	sveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+	"github.com/projectsveltos/libsveltos/lib/utils"
+)
+
+// RiskReason describes why a granted permission allows a tenant to escalate privileges
+type RiskReason string
+
+const (
+	// RiskWildcardVerb is reported for rules granting all verbs
+	RiskWildcardVerb = RiskReason("WildcardVerb")
+
+	// RiskWildcardResource is reported for rules granting access to all resources or all API groups
+	RiskWildcardResource = RiskReason("WildcardResource")
+
+	// RiskSecretsAccess is reported for rules granting read access to Secrets
+	RiskSecretsAccess = RiskReason("SecretsAccess")
+
+	// RiskBind is reported for rules granting the bind verb
+	RiskBind = RiskReason("Bind")
+
+	// RiskEscalate is reported for rules granting the escalate verb
+	RiskEscalate = RiskReason("Escalate")
+
+	// RiskImpersonate is reported for rules granting the impersonate verb
+	RiskImpersonate = RiskReason("Impersonate")
+
+	// RiskAggregatedClusterRole is reported for ClusterRoles with an AggregationRule.
+	// Their rules are computed in the managed cluster and cannot be evaluated.
+	RiskAggregatedClusterRole = RiskReason("AggregatedClusterRole")
+)
+
+const (
+	roleKind        = "Role"
+	clusterRoleKind = "ClusterRole"
+)
+
+// EscalationRisk is a permission, granted by a Role/ClusterRole, allowing a tenant
+// to escalate privileges
+type EscalationRisk struct {
+	Reason RiskReason
+
+	// Kind, Namespace and Name identify the Role/ClusterRole granting the permission
+	Kind      string
+	Namespace string
+	Name      string
+
+	// Rule is the rule granting the permission
+	Rule rbacv1.PolicyRule
+}
+
+func (r *EscalationRisk) String() string {
+	source := fmt.Sprintf("%s %s", r.Kind, r.Name)
+	if r.Namespace != "" {
+		source = fmt.Sprintf("%s %s/%s", r.Kind, r.Namespace, r.Name)
+	}
+
+	if r.Reason == RiskAggregatedClusterRole {
+		return fmt.Sprintf("%s: %s", source, r.Reason)
+	}
+
+	return fmt.Sprintf("%s: %s (verbs %v on apiGroups %v resources %v)", source, r.Reason,
+		r.Rule.Verbs, r.Rule.APIGroups, r.Rule.Resources)
+}
+
+// PermissionReport contains the effective permissions granted to a tenant in a cluster
+type PermissionReport struct {
+	// ClusterRules contains the aggregated rules granted cluster wide by ClusterRoles
+	ClusterRules []rbacv1.PolicyRule
+
+	// NamespacedRules contains, per namespace, the aggregated rules granted by Roles
+	NamespacedRules map[string][]rbacv1.PolicyRule
+
+	// EscalationRisks contains all permissions allowing tenant to escalate privileges
+	EscalationRisks []EscalationRisk
+}
+
+// GetPermissionReport returns the effective permissions granted to the tenant of roleRequest
+// in a cluster. Roles and ClusterRoles are collected from the ConfigMaps/Secrets referenced
+// in RoleRequest RoleRefs. RoleRefs with no namespace are looked up in clusterNamespace.
+// ClusterRoles grant permissions cluster wide, Roles in their own namespace.
+func GetPermissionReport(ctx context.Context, c client.Client, roleRequest *sveltosv1alpha1.RoleRequest,
+	clusterNamespace string, logger logr.Logger) (*PermissionReport, error) {
+
+	objects := make([]*unstructured.Unstructured, 0)
+	for i := range roleRequest.Spec.RoleRefs {
+		refObjects, err := getReferencedObjects(ctx, c, &roleRequest.Spec.RoleRefs[i], clusterNamespace)
+		if err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to collect content of %s: %v",
+				roleRequest.Spec.RoleRefs[i].String(), err))
+			return nil, err
+		}
+		objects = append(objects, refObjects...)
+	}
+
+	return GetPermissionReportForObjects(objects)
+}
+
+// GetPermissionReportForObjects returns the effective permissions granted by the Roles and
+// ClusterRoles in objects. Any other object is ignored.
+func GetPermissionReportForObjects(objects []*unstructured.Unstructured) (*PermissionReport, error) {
+	clusterRules := make([]rbacv1.PolicyRule, 0)
+	namespacedRules := make(map[string][]rbacv1.PolicyRule)
+	risks := make([]EscalationRisk, 0)
+
+	for i := range objects {
+		if objects[i].GroupVersionKind().Group != rbacv1.GroupName {
+			continue
+		}
+
+		switch objects[i].GetKind() {
+		case clusterRoleKind:
+			clusterRole := &rbacv1.ClusterRole{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(objects[i].Object, clusterRole); err != nil {
+				return nil, err
+			}
+			if clusterRole.AggregationRule != nil {
+				risks = append(risks, EscalationRisk{Reason: RiskAggregatedClusterRole,
+					Kind: clusterRoleKind, Name: clusterRole.Name})
+			}
+			clusterRules = append(clusterRules, clusterRole.Rules...)
+			risks = append(risks, getEscalationRisks(clusterRoleKind, "", clusterRole.Name, clusterRole.Rules)...)
+		case roleKind:
+			role := &rbacv1.Role{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(objects[i].Object, role); err != nil {
+				return nil, err
+			}
+			namespace := role.Namespace
+			if namespace == "" {
+				namespace = metav1.NamespaceDefault
+			}
+			namespacedRules[namespace] = append(namespacedRules[namespace], role.Rules...)
+			risks = append(risks, getEscalationRisks(roleKind, namespace, role.Name, role.Rules)...)
+		}
+	}
+
+	report := &PermissionReport{
+		ClusterRules:    aggregateRules(clusterRules),
+		NamespacedRules: make(map[string][]rbacv1.PolicyRule, len(namespacedRules)),
+		EscalationRisks: risks,
+	}
+	for namespace := range namespacedRules {
+		report.NamespacedRules[namespace] = aggregateRules(namespacedRules[namespace])
+	}
+
+	return report, nil
+}
+
+// GetPermissionSummary returns the summary of report, to be stored in RoleRequest Status
+func GetPermissionSummary(report *PermissionReport) *sveltosv1alpha1.PermissionSummary {
+	summary := &sveltosv1alpha1.PermissionSummary{
+		ClusterRules: len(report.ClusterRules),
+	}
+
+	for namespace := range report.NamespacedRules {
+		summary.Namespaces = append(summary.Namespaces, namespace)
+		summary.NamespacedRules += len(report.NamespacedRules[namespace])
+	}
+	sort.Strings(summary.Namespaces)
+
+	seen := make(map[string]bool)
+	for i := range report.EscalationRisks {
+		risk := report.EscalationRisks[i].String()
+		if !seen[risk] {
+			seen[risk] = true
+			summary.EscalationRisks = append(summary.EscalationRisks, risk)
+		}
+	}
+
+	return summary
+}
+
+// getEscalationRisks returns the escalation risks in rules granted by Role/ClusterRole kind namespace/name
+func getEscalationRisks(kind, namespace, name string, rules []rbacv1.PolicyRule) []EscalationRisk {
+	risks := make([]EscalationRisk, 0)
+
+	for i := range rules {
+		rule := &rules[i]
+		addRisk := func(reason RiskReason) {
+			risks = append(risks, EscalationRisk{Reason: reason, Kind: kind, Namespace: namespace,
+				Name: name, Rule: *rule.DeepCopy()})
+		}
+
+		if contains(rule.Verbs, rbacv1.VerbAll) {
+			addRisk(RiskWildcardVerb)
+		}
+		if contains(rule.Resources, rbacv1.ResourceAll) || contains(rule.APIGroups, rbacv1.APIGroupAll) {
+			addRisk(RiskWildcardResource)
+		}
+		if (contains(rule.APIGroups, "") || contains(rule.APIGroups, rbacv1.APIGroupAll)) &&
+			contains(rule.Resources, "secrets") &&
+			(contains(rule.Verbs, "get") || contains(rule.Verbs, "list") || contains(rule.Verbs, "watch") ||
+				contains(rule.Verbs, rbacv1.VerbAll)) {
+
+			addRisk(RiskSecretsAccess)
+		}
+		if contains(rule.Verbs, "bind") {
+			addRisk(RiskBind)
+		}
+		if contains(rule.Verbs, "escalate") {
+			addRisk(RiskEscalate)
+		}
+		if contains(rule.Verbs, "impersonate") {
+			addRisk(RiskImpersonate)
+		}
+	}
+
+	return risks
+}
+
+// aggregateRules merges rules applying to the same apiGroups/resources/resourceNames/nonResourceURLs,
+// doing the union of their verbs. Result is sorted.
+func aggregateRules(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	aggregated := make(map[string]*rbacv1.PolicyRule)
+	verbs := make(map[string]map[string]bool)
+
+	for i := range rules {
+		rule := normalizeRule(&rules[i])
+		key := fmt.Sprintf("%v|%v|%v|%v", rule.APIGroups, rule.Resources, rule.ResourceNames, rule.NonResourceURLs)
+		if _, ok := aggregated[key]; !ok {
+			aggregated[key] = rule
+			verbs[key] = make(map[string]bool)
+		}
+		for _, verb := range rule.Verbs {
+			verbs[key][verb] = true
+		}
+	}
+
+	keys := make([]string, 0, len(aggregated))
+	for key := range aggregated {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]rbacv1.PolicyRule, len(keys))
+	for i, key := range keys {
+		rule := aggregated[key]
+		rule.Verbs = sortedKeys(verbs[key])
+		result[i] = *rule
+	}
+
+	return result
+}
+
+// normalizeRule returns a copy of rule with all lists sorted
+func normalizeRule(rule *rbacv1.PolicyRule) *rbacv1.PolicyRule {
+	normalized := rule.DeepCopy()
+	sort.Strings(normalized.APIGroups)
+	sort.Strings(normalized.Resources)
+	sort.Strings(normalized.ResourceNames)
+	sort.Strings(normalized.NonResourceURLs)
+	return normalized
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(values []string, value string) bool {
+	for i := range values {
+		if values[i] == value {
+			return true
+		}
+	}
+	return false
+}
+
+// getReferencedObjects returns all the objects contained in the ConfigMap/Secret referenced by ref.
+// If ref namespace is empty, clusterNamespace is used.
+func getReferencedObjects(ctx context.Context, c client.Client, ref *sveltosv1alpha1.PolicyRef,
+	clusterNamespace string) ([]*unstructured.Unstructured, error) {
+
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = clusterNamespace
+	}
+
+	contents := make([]string, 0)
+	switch ref.Kind {
+	case string(sveltosv1alpha1.ConfigMapReferencedResourceKind):
+		configMap := &corev1.ConfigMap{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, configMap); err != nil {
+			return nil, err
+		}
+		for k := range configMap.Data {
+			contents = append(contents, configMap.Data[k])
+		}
+	case string(sveltosv1alpha1.SecretReferencedResourceKind):
+		secret := &corev1.Secret{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, secret); err != nil {
+			return nil, err
+		}
+		for k := range secret.Data {
+			contents = append(contents, string(secret.Data[k]))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported RoleRef kind %q", ref.Kind)
+	}
+
+	objects := make([]*unstructured.Unstructured, 0)
+	for i := range contents {
+		contentObjects, err := getObjectsFromContent(contents[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s %s/%s: %w", ref.Kind, namespace, ref.Name, err)
+		}
+		objects = append(objects, contentObjects...)
+	}
+
+	return objects, nil
+}
+
+// getObjectsFromContent returns all the objects contained in content, a (multi document) YAML or JSON
+func getObjectsFromContent(content string) ([]*unstructured.Unstructured, error) {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(strings.NewReader(content)))
+
+	objects := make([]*unstructured.Unstructured, 0)
+	for {
+		doc, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		object, err := utils.GetUnstructured(doc)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package roles_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	sveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/roles"
+)
+
+const (
+	podViewerAndDeploymentRoles = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: pod-viewer
+rules:
+- apiGroups: [""]
+  resources: ["pods"]
+  verbs: ["get", "list"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: deployment-viewer
+  namespace: apps
+rules:
+- apiGroups: ["apps"]
+  resources: ["deployments"]
+  verbs: ["get"]
+- apiGroups: ["apps"]
+  resources: ["deployments"]
+  verbs: ["list", "get"]
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ignored
+  namespace: apps
+`
+
+	riskyClusterRoles = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: risky
+rules:
+- apiGroups: [""]
+  resources: ["secrets", "pods"]
+  verbs: ["get"]
+- apiGroups: ["rbac.authorization.k8s.io"]
+  resources: ["clusterroles"]
+  verbs: ["bind", "escalate"]
+- apiGroups: ["*"]
+  resources: ["*"]
+  verbs: ["*"]
+- apiGroups: [""]
+  resources: ["users"]
+  verbs: ["impersonate"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: aggregated
+aggregationRule:
+  clusterRoleSelectors:
+  - matchLabels:
+      rbac.example.com/aggregate: "true"
+`
+)
+
+var _ = Describe("Roles permissions", func() {
+	It("GetPermissionReport aggregates rules granted by RoleRefs", func() {
+		clusterNamespace := randomString()
+
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: clusterNamespace,
+				Name:      randomString(),
+			},
+			Data: map[string]string{
+				"policy": podViewerAndDeploymentRoles,
+			},
+		}
+
+		roleRequest := &sveltosv1alpha1.RoleRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+			},
+			Spec: sveltosv1alpha1.RoleRequestSpec{
+				RoleRefs: []sveltosv1alpha1.PolicyRef{
+					// Namespace is left empty, so cluster namespace is used
+					{Kind: string(sveltosv1alpha1.ConfigMapReferencedResourceKind), Name: configMap.Name},
+				},
+			},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(configMap).Build()
+
+		report, err := roles.GetPermissionReport(context.TODO(), c, roleRequest, clusterNamespace, klogr.New())
+		Expect(err).To(BeNil())
+		Expect(report.ClusterRules).To(ConsistOf(rbacv1.PolicyRule{
+			APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list"},
+		}))
+		Expect(report.NamespacedRules).To(HaveLen(1))
+		Expect(report.NamespacedRules["apps"]).To(ConsistOf(rbacv1.PolicyRule{
+			APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"get", "list"},
+		}))
+		Expect(report.EscalationRisks).To(BeEmpty())
+
+		summary := roles.GetPermissionSummary(report)
+		Expect(summary.ClusterRules).To(Equal(1))
+		Expect(summary.NamespacedRules).To(Equal(1))
+		Expect(summary.Namespaces).To(ConsistOf("apps"))
+		Expect(summary.EscalationRisks).To(BeEmpty())
+
+		// Referenced resource not existing
+		roleRequest.Spec.RoleRefs[0].Namespace = randomString()
+		_, err = roles.GetPermissionReport(context.TODO(), c, roleRequest, clusterNamespace, klogr.New())
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("GetPermissionReport flags escalation risks", func() {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+			},
+			Type: sveltosv1alpha1.ClusterProfileSecretType,
+			Data: map[string][]byte{
+				"policy": []byte(riskyClusterRoles),
+			},
+		}
+
+		roleRequest := &sveltosv1alpha1.RoleRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+			},
+			Spec: sveltosv1alpha1.RoleRequestSpec{
+				RoleRefs: []sveltosv1alpha1.PolicyRef{
+					{Kind: string(sveltosv1alpha1.SecretReferencedResourceKind),
+						Namespace: secret.Namespace, Name: secret.Name},
+				},
+			},
+		}
+
+		initObjects := []client.Object{secret}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		report, err := roles.GetPermissionReport(context.TODO(), c, roleRequest, randomString(), klogr.New())
+		Expect(err).To(BeNil())
+		Expect(report.ClusterRules).To(HaveLen(4))
+
+		reasons := make([]roles.RiskReason, len(report.EscalationRisks))
+		for i := range report.EscalationRisks {
+			reasons[i] = report.EscalationRisks[i].Reason
+			Expect(report.EscalationRisks[i].Kind).To(Equal("ClusterRole"))
+		}
+		Expect(reasons).To(ConsistOf(roles.RiskSecretsAccess, roles.RiskBind, roles.RiskEscalate,
+			roles.RiskWildcardVerb, roles.RiskWildcardResource, roles.RiskImpersonate,
+			roles.RiskAggregatedClusterRole))
+
+		summary := roles.GetPermissionSummary(report)
+		Expect(summary.EscalationRisks).To(HaveLen(7))
+		Expect(summary.EscalationRisks).To(ContainElement("ClusterRole aggregated: AggregatedClusterRole"))
+	})
+})
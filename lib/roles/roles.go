@@ -34,6 +34,8 @@ import (
 const (
 	clusterNameLabel = "projectsveltos.io/role-cluster"
 
+	clusterTypeLabel = "projectsveltos.io/role-cluster-type"
+
 	serviceAccountNameLabel = "projectsveltos.io/role-service-account-name"
 
 	serviceAccountNamespaceLabel = "projectsveltos.io/role-service-account-namespace"
@@ -57,18 +59,17 @@ func GetSecret(ctx context.Context, c client.Client,
 	clusterNamespace, clusterName, serviceAccountNamespace, serviceAccountName string,
 	clusterType sveltosv1alpha1.ClusterType) (*corev1.Secret, error) {
 
-	secretList := &corev1.SecretList{}
-	err := c.List(ctx, secretList, getListOptionsForSecret(clusterNamespace, clusterName,
-		serviceAccountNamespace, serviceAccountName)...)
+	secrets, err := listSecrets(ctx, c, clusterNamespace, clusterName,
+		serviceAccountNamespace, serviceAccountName)
 	if err != nil {
 		return nil, err
 	}
 
-	switch len(secretList.Items) {
+	switch len(secrets) {
 	case 0:
 		return nil, nil
 	case 1:
-		return &secretList.Items[0], nil
+		return &secrets[0], nil
 	default:
 		return nil, fmt.Errorf("found more than one existing secret for %s in cluster %s/%s",
 			serviceAccountName, clusterNamespace, clusterName)
@@ -78,26 +79,28 @@ func GetSecret(ctx context.Context, c client.Client,
 // CreateSecret returns the secret to be used to store kubeconfig for serviceAccountNamespace/serviceAccountName
 // in cluster. It does create it if it does not exist yet.
 // If Secret already exists, updates Data section if necessary (kubeconfig is different)
+// Every time a new kubeconfig is stored, IssuedAtAnnotation and ExpiresAtAnnotation are set
+// from the kubeconfig token iat and exp claims. If token has no exp claim, expiration is not
+// known and ExpiresAtAnnotation is not set.
 func CreateSecret(ctx context.Context, c client.Client,
 	clusterNamespace, clusterName, serviceAccountNamespace, serviceAccountName string,
 	clusterType sveltosv1alpha1.ClusterType, kubeconfig []byte, owner client.Object) (*corev1.Secret, error) {
 
-	secretList := &corev1.SecretList{}
-	err := c.List(ctx, secretList, getListOptionsForSecret(clusterNamespace, clusterName,
-		serviceAccountNamespace, serviceAccountName)...)
+	secrets, err := listSecrets(ctx, c, clusterNamespace, clusterName,
+		serviceAccountNamespace, serviceAccountName)
 	if err != nil {
 		return nil, err
 	}
 
-	switch len(secretList.Items) {
+	switch len(secrets) {
 	case 0:
 		return createSecret(ctx, c, clusterNamespace, clusterName, serviceAccountNamespace,
-			serviceAccountName, kubeconfig, owner)
+			serviceAccountName, clusterType, kubeconfig, owner)
 	case 1:
-		if shouldUpdate(&secretList.Items[0], kubeconfig, owner) {
-			return updateSecret(ctx, c, &secretList.Items[0], kubeconfig, owner)
+		if shouldUpdate(&secrets[0], kubeconfig, owner) {
+			return updateSecret(ctx, c, &secrets[0], kubeconfig, owner)
 		}
-		return &secretList.Items[0], nil
+		return &secrets[0], nil
 	default:
 		return nil, fmt.Errorf("found more than one existing secret for %s in cluster %s/%s",
 			serviceAccountName, clusterNamespace, clusterName)
@@ -110,18 +113,17 @@ func DeleteSecret(ctx context.Context, c client.Client,
 	clusterNamespace, clusterName, serviceAccountNamespace, serviceAccountName string,
 	clusterType sveltosv1alpha1.ClusterType, owner client.Object) error {
 
-	secretList := &corev1.SecretList{}
-	err := c.List(ctx, secretList, getListOptionsForSecret(clusterNamespace, clusterName,
-		serviceAccountNamespace, serviceAccountName)...)
+	secrets, err := listSecrets(ctx, c, clusterNamespace, clusterName,
+		serviceAccountNamespace, serviceAccountName)
 	if err != nil {
-		return nil
+		return err
 	}
 
-	for i := range secretList.Items {
-		deployer.RemoveOwnerReference(&secretList.Items[i], owner)
+	for i := range secrets {
+		deployer.RemoveOwnerReference(&secrets[i], owner)
 
-		if len(secretList.Items[i].GetOwnerReferences()) != 0 {
-			err = c.Update(ctx, &secretList.Items[i])
+		if len(secrets[i].GetOwnerReferences()) != 0 {
+			err = c.Update(ctx, &secrets[i])
 			if err != nil {
 				return err
 			}
@@ -129,7 +131,7 @@ func DeleteSecret(ctx context.Context, c client.Client,
 			continue
 		}
 
-		err = c.Delete(ctx, &secretList.Items[i])
+		err = c.Delete(ctx, &secrets[i])
 		if err != nil {
 			return err
 		}
@@ -194,30 +196,37 @@ func GetKubeconfig(ctx context.Context, c client.Client,
 	clusterNamespace, clusterName, serviceAccountNamespace, serviceAccountName string,
 	clusterType sveltosv1alpha1.ClusterType) ([]byte, error) {
 
-	secretList := &corev1.SecretList{}
-	err := c.List(ctx, secretList, getListOptionsForSecret(clusterNamespace, clusterName, serviceAccountNamespace, serviceAccountName)...)
+	secrets, err := listSecrets(ctx, c, clusterNamespace, clusterName,
+		serviceAccountNamespace, serviceAccountName)
 	if err != nil {
 		return nil, err
 	}
 
-	switch len(secretList.Items) {
+	switch len(secrets) {
 	case 0:
 		return nil, nil
 	case 1:
-		if secretList.Items[0].Data == nil {
-			return nil, nil
-		}
-		kubeconfig, ok := secretList.Items[0].Data[key]
-		if !ok {
-			return nil, nil
-		}
-		return kubeconfig, nil
+		return GetKubeconfigFromSecret(&secrets[0]), nil
 	default:
 		return nil, fmt.Errorf("found more than one existing secret for %s in cluster %s/%s",
 			serviceAccountName, clusterNamespace, clusterName)
 	}
 }
 
+// GetKubeconfigFromSecret returns the kubeconfig stored in a Secret created by CreateSecret.
+// Returns nil if kubeconfig is not found.
+func GetKubeconfigFromSecret(secret *corev1.Secret) []byte {
+	if secret.Data == nil {
+		return nil
+	}
+
+	return secret.Data[key]
+}
+
+// ServiceAccountNamespaceInManagedCluster is the namespace where ServiceAccounts
+// are created in managed clusters when processing a RoleRequest
+const ServiceAccountNamespaceInManagedCluster = "projectsveltos"
+
 // GetServiceAccountNameInManagedCluster given:
 // -namespace
 // -name
@@ -242,22 +251,14 @@ func getSha256(text string) string {
 
 func createSecret(ctx context.Context, c client.Client,
 	namespace, clusterName, serviceAccountNamespace, serviceAccountName string,
-	kubeconfig []byte, ownerReference metav1.Object) (*corev1.Secret, error) {
-
-	var config string
-	config += clusterName
-	config += serviceAccountNamespace
-	config += serviceAccountName
-	name := fmt.Sprintf("sveltos-%s", getSha256(config))
+	clusterType sveltosv1alpha1.ClusterType, kubeconfig []byte, ownerReference client.Object) (*corev1.Secret, error) {
 
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: namespace,
-			Name:      name,
+			Name:      getSecretName(clusterName, serviceAccountNamespace, serviceAccountName),
 			Labels: map[string]string{
-				clusterNameLabel:                 clusterName,
-				serviceAccountNameLabel:          serviceAccountName,
-				serviceAccountNamespaceLabel:     serviceAccountNamespace,
+				clusterTypeLabel:                 string(clusterType),
 				sveltosv1alpha1.RoleRequestLabel: "ok",
 			},
 		},
@@ -266,6 +267,9 @@ func createSecret(ctx context.Context, c client.Client,
 		},
 	}
 
+	setLookupMetadata(secret, getLookupValues(clusterName, serviceAccountNamespace, serviceAccountName))
+	setExpirationAnnotations(secret, kubeconfig)
+
 	if err := controllerutil.SetOwnerReference(ownerReference, secret, c.Scheme()); err != nil {
 		return nil, err
 	}
@@ -277,17 +281,11 @@ func createSecret(ctx context.Context, c client.Client,
 	return secret, nil
 }
 
-func getListOptionsForSecret(clusterNamespace, clusterName, serviceAccountNamespace, serviceAccountName string,
-) []client.ListOption {
-
-	return []client.ListOption{
-		client.InNamespace(clusterNamespace),
-		client.MatchingLabels{
-			clusterNameLabel:             clusterName,
-			serviceAccountNameLabel:      serviceAccountName,
-			serviceAccountNamespaceLabel: serviceAccountNamespace,
-		},
-	}
+// getSecretName returns the name of the Secret storing kubeconfig for serviceAccountNamespace/serviceAccountName
+// in cluster. Values are separated by a character not allowed in names, so different values never
+// produce the same name.
+func getSecretName(clusterName, serviceAccountNamespace, serviceAccountName string) string {
+	return fmt.Sprintf("sveltos-%s", getSha256(clusterName+"/"+serviceAccountNamespace+"/"+serviceAccountName))
 }
 
 // shouldUpdate returns true if secret needs to be updated, which happens
@@ -312,6 +310,11 @@ func updateSecret(ctx context.Context, c client.Client, secret *corev1.Secret,
 
 	deployer.AddOwnerReference(secret, owner)
 
+	if secret.Data == nil || !reflect.DeepEqual(secret.Data[key], kubeconfig) {
+		// A new kubeconfig is being stored
+		setExpirationAnnotations(secret, kubeconfig)
+	}
+
 	secret.Data = map[string][]byte{
 		key: kubeconfig,
 	}
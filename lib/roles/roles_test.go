@@ -175,6 +175,10 @@ var _ = Describe("Roles", func() {
 		Expect(ok).To(BeTrue())
 		Expect(v).To(Equal(clusterName))
 
+		v, ok = secret.Labels[roles.ClusterTypeLabel]
+		Expect(ok).To(BeTrue())
+		Expect(v).To(Equal(string(sveltosv1alpha1.ClusterTypeSveltos)))
+
 		Expect(secret.OwnerReferences).ToNot(BeNil())
 		Expect(len(secret.OwnerReferences)).To(Equal(1))
 	})
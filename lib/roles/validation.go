@@ -0,0 +1,271 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package roles
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	sveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+)
+
+const (
+	roleBindingKind        = "RoleBinding"
+	clusterRoleBindingKind = "ClusterRoleBinding"
+
+	systemMastersGroup = "system:masters"
+
+	// systemRolePrefix is the prefix of the name of ClusterRoles created by Kubernetes
+	systemRolePrefix = "system:"
+)
+
+var (
+	// privilegedClusterRoles are the built-in user-facing ClusterRoles tenants cannot bind to
+	privilegedClusterRoles = []string{"cluster-admin", "admin", "edit"}
+)
+
+// ValidationOptions customizes RoleRequest validation
+type ValidationOptions struct {
+	// AllowedNamespaces, if not empty, lists the namespaces Roles and RoleBindings
+	// can be deployed to
+	AllowedNamespaces []string
+}
+
+// PolicyValidationError is returned when the content referenced by a RoleRequest is not valid
+type PolicyValidationError struct {
+	// Violations lists all the reasons content is not valid
+	Violations []string
+}
+
+func (e *PolicyValidationError) Error() string {
+	return fmt.Sprintf("RoleRequest policies are not valid: %s", strings.Join(e.Violations, "; "))
+}
+
+// ValidateRoleRequest validates the content of all ConfigMaps/Secrets referenced by roleRequest
+// RoleRefs. RoleRefs with no namespace are looked up in clusterNamespace.
+// Returns a PolicyValidationError if content is not valid.
+func ValidateRoleRequest(ctx context.Context, c client.Client, roleRequest *sveltosv1alpha1.RoleRequest,
+	clusterNamespace string, options *ValidationOptions) error {
+
+	objects := make([]*unstructured.Unstructured, 0)
+	for i := range roleRequest.Spec.RoleRefs {
+		refObjects, err := getReferencedObjects(ctx, c, &roleRequest.Spec.RoleRefs[i], clusterNamespace)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, refObjects...)
+	}
+
+	return ValidateRoleRequestObjects(roleRequest, objects, options)
+}
+
+// ValidateRoleRequestObjects validates objects, the content referenced by roleRequest. It does not
+// need access to any cluster, so it can be used by admission webhooks.
+// Objects are valid if:
+// - only Roles, ClusterRoles, RoleBindings and ClusterRoleBindings are present;
+// - Roles and RoleBindings are in one of options AllowedNamespaces (if any);
+// - bindings only have, as subject, the ServiceAccount created for the tenant in the managed cluster
+// (so, for instance, no binding to system:masters group);
+// - bindings only reference Roles/ClusterRoles defined in objects (a RoleBinding can reference a Role
+// in its own namespace). Built-in ClusterRoles (cluster-admin, admin, edit, system:*) can neither be
+// referenced nor redefined.
+// Returns a PolicyValidationError if content is not valid.
+func ValidateRoleRequestObjects(roleRequest *sveltosv1alpha1.RoleRequest, objects []*unstructured.Unstructured,
+	options *ValidationOptions) error {
+
+	if options == nil {
+		options = &ValidationOptions{}
+	}
+
+	definedRoles := getDefinedRoles(objects)
+
+	violations := make([]string, 0)
+	for i := range objects {
+		violations = append(violations, validateObject(roleRequest, objects[i], definedRoles, options)...)
+	}
+
+	if len(violations) != 0 {
+		return &PolicyValidationError{Violations: violations}
+	}
+	return nil
+}
+
+// SetFailureMessage records err in roleRequest Status FailureMessage. A nil err resets it.
+func SetFailureMessage(roleRequest *sveltosv1alpha1.RoleRequest, err error) {
+	if err == nil {
+		roleRequest.Status.FailureMessage = nil
+		return
+	}
+
+	message := err.Error()
+	roleRequest.Status.FailureMessage = &message
+}
+
+func validateObject(roleRequest *sveltosv1alpha1.RoleRequest, object *unstructured.Unstructured,
+	definedRoles map[string]bool, options *ValidationOptions) []string {
+
+	source := fmt.Sprintf("%s %s", object.GetKind(), object.GetName())
+	if object.GroupVersionKind().Group != rbacv1.GroupName {
+		return []string{fmt.Sprintf("%s: kind %s is not allowed. Only RBAC resources can be deployed",
+			source, object.GroupVersionKind().GroupKind().String())}
+	}
+
+	violations := make([]string, 0)
+	switch object.GetKind() {
+	case roleKind:
+		violations = append(violations, validateNamespace(source, object.GetNamespace(), options)...)
+	case clusterRoleKind:
+		if isPrivilegedClusterRole(object.GetName()) {
+			violations = append(violations, fmt.Sprintf("%s: built-in ClusterRole cannot be redefined", source))
+		}
+	case roleBindingKind:
+		violations = append(violations, validateNamespace(source, object.GetNamespace(), options)...)
+		roleBinding := &rbacv1.RoleBinding{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, roleBinding); err != nil {
+			return append(violations, fmt.Sprintf("%s: %v", source, err))
+		}
+		violations = append(violations, validateSubjects(roleRequest, source, roleBinding.Subjects)...)
+		violations = append(violations,
+			validateRoleRef(source, roleBinding.Namespace, &roleBinding.RoleRef, definedRoles)...)
+	case clusterRoleBindingKind:
+		clusterRoleBinding := &rbacv1.ClusterRoleBinding{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, clusterRoleBinding); err != nil {
+			return append(violations, fmt.Sprintf("%s: %v", source, err))
+		}
+		violations = append(violations, validateSubjects(roleRequest, source, clusterRoleBinding.Subjects)...)
+		if clusterRoleBinding.RoleRef.Kind != clusterRoleKind {
+			violations = append(violations, fmt.Sprintf("%s: roleRef kind %s is not allowed. Only ClusterRole can be referenced",
+				source, clusterRoleBinding.RoleRef.Kind))
+		} else {
+			violations = append(violations,
+				validateRoleRef(source, "", &clusterRoleBinding.RoleRef, definedRoles)...)
+		}
+	default:
+		violations = append(violations, fmt.Sprintf("%s: kind %s is not allowed", source, object.GetKind()))
+	}
+
+	return violations
+}
+
+// getDefinedRoles returns the Roles and ClusterRoles present in objects (see getRoleKey)
+func getDefinedRoles(objects []*unstructured.Unstructured) map[string]bool {
+	definedRoles := make(map[string]bool)
+	for i := range objects {
+		object := objects[i]
+		if object.GroupVersionKind().Group != rbacv1.GroupName {
+			continue
+		}
+		switch object.GetKind() {
+		case roleKind:
+			definedRoles[getRoleKey(roleKind, object.GetNamespace(), object.GetName())] = true
+		case clusterRoleKind:
+			definedRoles[getRoleKey(clusterRoleKind, "", object.GetName())] = true
+		}
+	}
+	return definedRoles
+}
+
+func getRoleKey(kind, namespace, name string) string {
+	if kind == clusterRoleKind {
+		return kind + "/" + name
+	}
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	return kind + "/" + namespace + "/" + name
+}
+
+// validateRoleRef verifies a binding in namespace (empty for ClusterRoleBindings) only references
+// a Role/ClusterRole defined in RoleRequest content and never a built-in privileged ClusterRole
+func validateRoleRef(source, namespace string, roleRef *rbacv1.RoleRef, definedRoles map[string]bool) []string {
+	if roleRef.APIGroup != rbacv1.GroupName {
+		return []string{fmt.Sprintf("%s: roleRef apiGroup %q is not allowed", source, roleRef.APIGroup)}
+	}
+
+	switch roleRef.Kind {
+	case clusterRoleKind:
+		if isPrivilegedClusterRole(roleRef.Name) {
+			return []string{fmt.Sprintf("%s: binding to ClusterRole %s is not allowed", source, roleRef.Name)}
+		}
+	case roleKind:
+	default:
+		return []string{fmt.Sprintf("%s: roleRef kind %s is not allowed", source, roleRef.Kind)}
+	}
+
+	if !definedRoles[getRoleKey(roleRef.Kind, namespace, roleRef.Name)] {
+		return []string{fmt.Sprintf("%s: roleRef %s %s is not defined in RoleRequest content",
+			source, roleRef.Kind, roleRef.Name)}
+	}
+	return nil
+}
+
+func isPrivilegedClusterRole(name string) bool {
+	return strings.HasPrefix(name, systemRolePrefix) || contains(privilegedClusterRoles, name)
+}
+
+func validateNamespace(source, namespace string, options *ValidationOptions) []string {
+	if len(options.AllowedNamespaces) == 0 {
+		return nil
+	}
+
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	if !contains(options.AllowedNamespaces, namespace) {
+		return []string{fmt.Sprintf("%s: namespace %s is not allowed", source, namespace)}
+	}
+	return nil
+}
+
+// validateSubjects verifies the only subject is the ServiceAccount created for the tenant in the
+// managed cluster
+func validateSubjects(roleRequest *sveltosv1alpha1.RoleRequest, source string, subjects []rbacv1.Subject) []string {
+	tenantServiceAccount := GetServiceAccountNameInManagedCluster(roleRequest.Spec.ServiceAccountNamespace,
+		roleRequest.Spec.ServiceAccountName)
+
+	violations := make([]string, 0)
+	for i := range subjects {
+		subject := &subjects[i]
+		switch {
+		case subject.Kind == rbacv1.GroupKind && subject.Name == systemMastersGroup:
+			violations = append(violations, fmt.Sprintf("%s: binding to group %s is not allowed",
+				source, systemMastersGroup))
+		case subject.Kind != rbacv1.ServiceAccountKind ||
+			subject.Namespace != ServiceAccountNamespaceInManagedCluster ||
+			subject.Name != tenantServiceAccount:
+
+			violations = append(violations, fmt.Sprintf("%s: subject %s %s is not allowed. Only ServiceAccount %s/%s can be bound",
+				source, subject.Kind, getSubjectName(subject), ServiceAccountNamespaceInManagedCluster, tenantServiceAccount))
+		}
+	}
+	return violations
+}
+
+func getSubjectName(subject *rbacv1.Subject) string {
+	if subject.Namespace != "" {
+		return subject.Namespace + "/" + subject.Name
+	}
+	return subject.Name
+}
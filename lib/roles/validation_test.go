@@ -0,0 +1,284 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package roles_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	sveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/roles"
+	"github.com/projectsveltos/libsveltos/lib/utils"
+)
+
+const (
+	tenantBinding = `apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: tenant
+  namespace: %s
+subjects:
+- kind: ServiceAccount
+  name: %s
+  namespace: projectsveltos
+roleRef:
+  kind: Role
+  name: tenant
+  apiGroup: rbac.authorization.k8s.io`
+
+	mastersBinding = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: masters
+subjects:
+- kind: Group
+  name: system:masters
+  apiGroup: rbac.authorization.k8s.io
+- kind: User
+  name: eve
+  apiGroup: rbac.authorization.k8s.io
+roleRef:
+  kind: ClusterRole
+  name: cluster-admin
+  apiGroup: rbac.authorization.k8s.io`
+
+	viewClusterRole = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: %s
+rules:
+- apiGroups: [""]
+  resources: ["pods"]
+  verbs: ["get", "watch", "list"]`
+
+	tenantRole = `apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: tenant
+  namespace: %s
+rules:
+- apiGroups: [""]
+  resources: ["configmaps"]
+  verbs: ["get"]`
+
+	clusterAdminBinding = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: tenant-admin
+subjects:
+- kind: ServiceAccount
+  name: %s
+  namespace: projectsveltos
+roleRef:
+  kind: ClusterRole
+  name: %s
+  apiGroup: rbac.authorization.k8s.io`
+
+	tenantClusterRoleBinding = `apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: tenant-view
+  namespace: %s
+subjects:
+- kind: ServiceAccount
+  name: %s
+  namespace: projectsveltos
+roleRef:
+  kind: ClusterRole
+  name: %s
+  apiGroup: rbac.authorization.k8s.io`
+
+	deployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  namespace: default
+spec:
+  selector:
+    matchLabels:
+      app: nginx
+  template:
+    metadata:
+      labels:
+        app: nginx
+    spec:
+      containers:
+      - name: nginx
+        image: nginx`
+)
+
+var _ = Describe("Roles validation", func() {
+	var roleRequest *sveltosv1alpha1.RoleRequest
+
+	BeforeEach(func() {
+		roleRequest = &sveltosv1alpha1.RoleRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+			},
+			Spec: sveltosv1alpha1.RoleRequestSpec{
+				ServiceAccountNamespace: randomString(),
+				ServiceAccountName:      randomString(),
+			},
+		}
+	})
+
+	It("ValidateRoleRequestObjects accepts RBAC resources bound to tenant ServiceAccount", func() {
+		tenantServiceAccount := roles.GetServiceAccountNameInManagedCluster(roleRequest.Spec.ServiceAccountNamespace,
+			roleRequest.Spec.ServiceAccountName)
+
+		role, err := utils.GetUnstructured([]byte(fmt.Sprintf(tenantRole, "tenant-ns")))
+		Expect(err).To(BeNil())
+		binding, err := utils.GetUnstructured([]byte(fmt.Sprintf(tenantBinding, "tenant-ns", tenantServiceAccount)))
+		Expect(err).To(BeNil())
+		clusterRole, err := utils.GetUnstructured([]byte(fmt.Sprintf(viewClusterRole, randomString())))
+		Expect(err).To(BeNil())
+
+		options := &roles.ValidationOptions{AllowedNamespaces: []string{"tenant-ns"}}
+		Expect(roles.ValidateRoleRequestObjects(roleRequest, []*unstructured.Unstructured{role, binding, clusterRole},
+			options)).To(Succeed())
+		Expect(roles.ValidateRoleRequestObjects(roleRequest, []*unstructured.Unstructured{role, binding, clusterRole},
+			nil)).To(Succeed())
+
+		// Role in a namespace tenant is not allowed to use
+		options.AllowedNamespaces = []string{randomString()}
+		err = roles.ValidateRoleRequestObjects(roleRequest, []*unstructured.Unstructured{role, binding}, options)
+		var validationErr *roles.PolicyValidationError
+		Expect(errors.As(err, &validationErr)).To(BeTrue())
+		Expect(validationErr.Violations).To(HaveLen(2))
+	})
+
+	It("ValidateRoleRequestObjects rejects non RBAC resources and bindings to other subjects", func() {
+		otherBinding, err := utils.GetUnstructured([]byte(fmt.Sprintf(tenantBinding, "tenant-ns", randomString())))
+		Expect(err).To(BeNil())
+		masters, err := utils.GetUnstructured([]byte(mastersBinding))
+		Expect(err).To(BeNil())
+		nginx, err := utils.GetUnstructured([]byte(deployment))
+		Expect(err).To(BeNil())
+
+		err = roles.ValidateRoleRequestObjects(roleRequest, []*unstructured.Unstructured{otherBinding, masters, nginx}, nil)
+		var validationErr *roles.PolicyValidationError
+		Expect(errors.As(err, &validationErr)).To(BeTrue())
+		Expect(validationErr.Violations).To(HaveLen(6))
+		Expect(validationErr.Violations).To(ContainElement(
+			"ClusterRoleBinding masters: binding to group system:masters is not allowed"))
+		Expect(validationErr.Violations).To(ContainElement(
+			"ClusterRoleBinding masters: binding to ClusterRole cluster-admin is not allowed"))
+		Expect(validationErr.Violations).To(ContainElement(
+			"RoleBinding tenant: roleRef Role tenant is not defined in RoleRequest content"))
+		Expect(err.Error()).To(ContainSubstring("Deployment nginx: kind Deployment.apps is not allowed"))
+	})
+
+	It("ValidateRoleRequestObjects rejects bindings to ClusterRoles not defined in content", func() {
+		tenantServiceAccount := roles.GetServiceAccountNameInManagedCluster(roleRequest.Spec.ServiceAccountNamespace,
+			roleRequest.Spec.ServiceAccountName)
+
+		for _, name := range []string{"cluster-admin", "admin", "edit", "system:controller:namespace-controller"} {
+			binding, err := utils.GetUnstructured([]byte(fmt.Sprintf(clusterAdminBinding, tenantServiceAccount, name)))
+			Expect(err).To(BeNil())
+			err = roles.ValidateRoleRequestObjects(roleRequest, []*unstructured.Unstructured{binding}, nil)
+			var validationErr *roles.PolicyValidationError
+			Expect(errors.As(err, &validationErr)).To(BeTrue())
+			Expect(validationErr.Violations).To(Equal([]string{
+				fmt.Sprintf("ClusterRoleBinding tenant-admin: binding to ClusterRole %s is not allowed", name)}))
+
+			// Built-in ClusterRoles cannot be redefined either
+			clusterRole, err := utils.GetUnstructured([]byte(fmt.Sprintf(viewClusterRole, name)))
+			Expect(err).To(BeNil())
+			err = roles.ValidateRoleRequestObjects(roleRequest, []*unstructured.Unstructured{clusterRole, binding}, nil)
+			Expect(errors.As(err, &validationErr)).To(BeTrue())
+			Expect(validationErr.Violations).To(HaveLen(2))
+		}
+
+		// ClusterRole not defined in content
+		viewName := randomString()
+		binding, err := utils.GetUnstructured([]byte(fmt.Sprintf(clusterAdminBinding, tenantServiceAccount, viewName)))
+		Expect(err).To(BeNil())
+		err = roles.ValidateRoleRequestObjects(roleRequest, []*unstructured.Unstructured{binding}, nil)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring(
+			fmt.Sprintf("roleRef ClusterRole %s is not defined in RoleRequest content", viewName)))
+
+		// ClusterRole defined in content, bound cluster wide and in a namespace
+		clusterRole, err := utils.GetUnstructured([]byte(fmt.Sprintf(viewClusterRole, viewName)))
+		Expect(err).To(BeNil())
+		roleBinding, err := utils.GetUnstructured([]byte(
+			fmt.Sprintf(tenantClusterRoleBinding, "tenant-ns", tenantServiceAccount, viewName)))
+		Expect(err).To(BeNil())
+		Expect(roles.ValidateRoleRequestObjects(roleRequest,
+			[]*unstructured.Unstructured{clusterRole, binding, roleBinding}, nil)).To(Succeed())
+	})
+
+	It("ValidateRoleRequestObjects rejects RoleBindings to a Role in a different namespace", func() {
+		tenantServiceAccount := roles.GetServiceAccountNameInManagedCluster(roleRequest.Spec.ServiceAccountNamespace,
+			roleRequest.Spec.ServiceAccountName)
+
+		role, err := utils.GetUnstructured([]byte(fmt.Sprintf(tenantRole, "tenant-ns")))
+		Expect(err).To(BeNil())
+		binding, err := utils.GetUnstructured([]byte(fmt.Sprintf(tenantBinding, "other-ns", tenantServiceAccount)))
+		Expect(err).To(BeNil())
+
+		err = roles.ValidateRoleRequestObjects(roleRequest, []*unstructured.Unstructured{role, binding}, nil)
+		var validationErr *roles.PolicyValidationError
+		Expect(errors.As(err, &validationErr)).To(BeTrue())
+		Expect(validationErr.Violations).To(Equal([]string{
+			"RoleBinding tenant: roleRef Role tenant is not defined in RoleRequest content"}))
+	})
+
+	It("ValidateRoleRequest validates content referenced by RoleRefs and SetFailureMessage surfaces result", func() {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+			},
+			Data: map[string]string{
+				"role":       fmt.Sprintf(tenantRole, "tenant-ns"),
+				"deployment": deployment,
+			},
+		}
+		roleRequest.Spec.RoleRefs = []sveltosv1alpha1.PolicyRef{
+			{Kind: string(sveltosv1alpha1.ConfigMapReferencedResourceKind), Name: configMap.Name},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(configMap).Build()
+
+		err := roles.ValidateRoleRequest(context.TODO(), c, roleRequest, configMap.Namespace, nil)
+		var validationErr *roles.PolicyValidationError
+		Expect(errors.As(err, &validationErr)).To(BeTrue())
+		Expect(validationErr.Violations).To(HaveLen(1))
+
+		roles.SetFailureMessage(roleRequest, err)
+		Expect(roleRequest.Status.FailureMessage).ToNot(BeNil())
+		Expect(*roleRequest.Status.FailureMessage).To(Equal(err.Error()))
+
+		delete(configMap.Data, "deployment")
+		Expect(c.Update(context.TODO(), configMap)).To(Succeed())
+		err = roles.ValidateRoleRequest(context.TODO(), c, roleRequest, configMap.Namespace, nil)
+		Expect(err).To(BeNil())
+
+		roles.SetFailureMessage(roleRequest, err)
+		Expect(roleRequest.Status.FailureMessage).To(BeNil())
+	})
+})
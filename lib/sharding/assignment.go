@@ -0,0 +1,127 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharding
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"sync"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// By default sharding is manual: only clusters with ShardAnnotation are managed by
+// sharded deployments.
+// Automatic sharding can be optionally enabled configuring a set of shard keys.
+// Every cluster with no ShardAnnotation is then assigned to one of those keys using
+// rendezvous (highest random weight) hashing. Adding (or removing) a shard key only
+// moves the clusters assigned to (or from) that key, about 1/N of the clusters.
+// All controllers must be configured with the same set of shard keys to compute
+// the same assignment. Empty string can be one of the keys, representing the
+// deployment started with no shard-key arg.
+
+var (
+	automaticShardKeys []string
+	automaticShardMux  sync.RWMutex
+)
+
+// SetAutomaticShardKeys enables automatic sharding, assigning each cluster with no ShardAnnotation
+// to one of shardKeys. Passing an empty list disables automatic sharding.
+func SetAutomaticShardKeys(shardKeys []string) {
+	automaticShardMux.Lock()
+	defer automaticShardMux.Unlock()
+
+	automaticShardKeys = normalizeShardKeys(shardKeys)
+}
+
+// GetAutomaticShardKeys returns the shard keys used for automatic sharding.
+// Returns an empty list if automatic sharding is not enabled.
+func GetAutomaticShardKeys() []string {
+	automaticShardMux.RLock()
+	defer automaticShardMux.RUnlock()
+
+	result := make([]string, len(automaticShardKeys))
+	copy(result, automaticShardKeys)
+	return result
+}
+
+// GetShardKey returns the shard key of the deployment managing cluster:
+// - cluster ShardAnnotation, if set;
+// - otherwise, if automatic sharding is enabled, the shard key assigned by AssignShard;
+// - otherwise, empty string.
+func GetShardKey(cluster client.Object) string {
+	annotations := cluster.GetAnnotations()
+	if v, ok := annotations[ShardAnnotation]; ok {
+		return v
+	}
+
+	automaticShardMux.RLock()
+	defer automaticShardMux.RUnlock()
+
+	if len(automaticShardKeys) == 0 {
+		return ""
+	}
+
+	return assignShard(automaticShardKeys, cluster.GetNamespace(), cluster.GetName())
+}
+
+// AssignShard returns, using rendezvous hashing, the shard key, among shardKeys, assigned
+// to cluster clusterNamespace/clusterName. Result does not depend on shardKeys order.
+// Returns empty string if shardKeys is empty.
+func AssignShard(shardKeys []string, clusterNamespace, clusterName string) string {
+	return assignShard(normalizeShardKeys(shardKeys), clusterNamespace, clusterName)
+}
+
+// assignShard expects shardKeys to be normalized (see normalizeShardKeys)
+func assignShard(shardKeys []string, clusterNamespace, clusterName string) string {
+	clusterID := clusterNamespace + "/" + clusterName
+
+	var assigned string
+	var highest uint64
+	for i := range shardKeys {
+		score := getScore(shardKeys[i], clusterID)
+		// shardKeys are sorted, so on a tie lowest key wins
+		if i == 0 || score > highest {
+			assigned = shardKeys[i]
+			highest = score
+		}
+	}
+
+	return assigned
+}
+
+// getScore returns the weight of shardKey for clusterID
+func getScore(shardKey, clusterID string) uint64 {
+	h := sha256.Sum256([]byte(shardKey + "\x00" + clusterID))
+	return binary.BigEndian.Uint64(h[:8])
+}
+
+// normalizeShardKeys returns the sorted list of unique shardKeys
+func normalizeShardKeys(shardKeys []string) []string {
+	unique := make(map[string]bool, len(shardKeys))
+	for i := range shardKeys {
+		unique[shardKeys[i]] = true
+	}
+
+	result := make([]string, 0, len(unique))
+	for k := range unique {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,126 @@
+package sharding_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	libsveltosv1alpha1 "github.com/projectsveltos/libsveltos/api/v1alpha1"
+	"github.com/projectsveltos/libsveltos/lib/sharding"
+)
+
+var _ = Describe("Automatic shard assignment", func() {
+	AfterEach(func() {
+		sharding.SetAutomaticShardKeys(nil)
+	})
+
+	It("AssignShard distributes clusters across all shard keys", func() {
+		shardKeys := []string{"shard1", "shard2", "shard3"}
+		const numClusters = 3000
+
+		assigned := make(map[string]int)
+		for i := 0; i < numClusters; i++ {
+			assigned[sharding.AssignShard(shardKeys, "default", fmt.Sprintf("cluster-%d", i))]++
+		}
+
+		Expect(len(assigned)).To(Equal(len(shardKeys)))
+		for i := range shardKeys {
+			// Expected numClusters/3 per shard. Allow for some deviation.
+			Expect(assigned[shardKeys[i]]).To(BeNumerically(">", 800))
+			Expect(assigned[shardKeys[i]]).To(BeNumerically("<", 1200))
+		}
+	})
+
+	It("AssignShard does not depend on shard keys order and duplicates", func() {
+		for i := 0; i < 100; i++ {
+			name := randomString()
+			Expect(sharding.AssignShard([]string{"a", "b", "c"}, "default", name)).To(
+				Equal(sharding.AssignShard([]string{"c", "a", "b", "a"}, "default", name)))
+		}
+
+		Expect(sharding.AssignShard(nil, "default", randomString())).To(BeEmpty())
+	})
+
+	It("AssignShard moves only clusters to the new shard key when a shard key is added", func() {
+		shardKeys := []string{"shard1", "shard2", "shard3"}
+		newShardKeys := append([]string{"shard4"}, shardKeys...)
+		const numClusters = 3000
+
+		moved := 0
+		for i := 0; i < numClusters; i++ {
+			name := fmt.Sprintf("cluster-%d", i)
+			before := sharding.AssignShard(shardKeys, "default", name)
+			after := sharding.AssignShard(newShardKeys, "default", name)
+			if before != after {
+				Expect(after).To(Equal("shard4"))
+				moved++
+			}
+		}
+
+		// Expected numClusters/4 moved clusters. Allow for some deviation.
+		Expect(moved).To(BeNumerically(">", 600))
+		Expect(moved).To(BeNumerically("<", 900))
+	})
+
+	It("IsShardAMatch matches exactly one shard key when automatic sharding is enabled", func() {
+		shardKeys := []string{"", "shard1", "shard2"}
+		sharding.SetAutomaticShardKeys(shardKeys)
+		Expect(sharding.GetAutomaticShardKeys()).To(ConsistOf(shardKeys))
+
+		for i := 0; i < 100; i++ {
+			cluster := &libsveltosv1alpha1.SveltosCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: randomString(),
+					Name:      randomString(),
+				},
+			}
+
+			matches := 0
+			for j := range shardKeys {
+				if sharding.IsShardAMatch(shardKeys[j], cluster) {
+					matches++
+				}
+			}
+			Expect(matches).To(Equal(1))
+			Expect(sharding.GetShardKey(cluster)).To(Equal(
+				sharding.AssignShard(shardKeys, cluster.Namespace, cluster.Name)))
+		}
+	})
+
+	It("ShardAnnotation takes precedence over automatic assignment", func() {
+		sharding.SetAutomaticShardKeys([]string{"shard1", "shard2"})
+
+		cluster := &libsveltosv1alpha1.SveltosCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+				Annotations: map[string]string{
+					sharding.ShardAnnotation: "manual",
+				},
+			},
+		}
+
+		Expect(sharding.GetShardKey(cluster)).To(Equal("manual"))
+		Expect(sharding.IsShardAMatch("manual", cluster)).To(BeTrue())
+		Expect(sharding.IsShardAMatch("shard1", cluster)).To(BeFalse())
+		Expect(sharding.IsShardAMatch("shard2", cluster)).To(BeFalse())
+	})
+
+	It("Clusters with no ShardAnnotation match empty shard key when automatic sharding is disabled", func() {
+		Expect(sharding.GetAutomaticShardKeys()).To(BeEmpty())
+
+		cluster := &libsveltosv1alpha1.SveltosCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+			},
+		}
+
+		Expect(sharding.GetShardKey(cluster)).To(BeEmpty())
+		Expect(sharding.IsShardAMatch("", cluster)).To(BeTrue())
+		Expect(sharding.IsShardAMatch("shard1", cluster)).To(BeFalse())
+	})
+})
@@ -37,29 +37,18 @@ import (
 // A cluster with shardAnnotation will only be managed by
 // the corresponding deployment with matching shard-key.
 // A cluster with no shardAnnotation will only be managed by
-// the deployment started with no shard-key arg.
+// the deployment started with no shard-key arg (or, if automatic
+// sharding is enabled, by the deployment with the assigned shard-key).
 
 const (
 	ShardAnnotation = "sharding.projectsveltos.io/key"
 )
 
 // IsShardAMatch returns true if a cluster is a shard match.
+// A cluster with no ShardAnnotation is only managed by deployment started
+// with no shard-key arg, unless automatic sharding is enabled (see SetAutomaticShardKeys).
 func IsShardAMatch(shardKey string, cluster client.Object) bool {
-	annotations := cluster.GetAnnotations()
-	if len(annotations) == 0 {
-		// A cluster with no ShardAnnotation is only managed by
-		// deployment started with no shard-key arg.
-		return shardKey == ""
-	}
-
-	v, ok := annotations[ShardAnnotation]
-	if !ok {
-		// A cluster with no ShardAnnotation is only managed by
-		// deployment started with no shard-key arg.
-		return shardKey == ""
-	}
-
-	return v == shardKey
+	return GetShardKey(cluster) == shardKey
 }
 
 // When sharding is used, each cluster at any point of time
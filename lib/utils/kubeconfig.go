@@ -0,0 +1,263 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	apiv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+)
+
+const (
+	execAPIVersionV1      = "client.authentication.k8s.io/v1"
+	execAPIVersionV1beta1 = "client.authentication.k8s.io/v1beta1"
+)
+
+var (
+	hostnameRegExp = regexp.MustCompile(`^[0-9a-zA-Z]([0-9a-zA-Z-.]*[0-9a-zA-Z])?$`)
+)
+
+// KubeconfigFieldError is returned when a value used to build a kubeconfig is not valid.
+// Field identifies the offending value (e.g. server, clientKeyData, exec.command).
+type KubeconfigFieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *KubeconfigFieldError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+func newKubeconfigFieldError(field, reason string) error {
+	return &KubeconfigFieldError{Field: field, Reason: reason}
+}
+
+// GetKubeconfigWithClientCertificate returns a kubeconfig authenticating user with
+// a client certificate and key (mTLS).
+// certData and keyData are PEM encoded. server is the API server URL, with scheme
+// https and optional port and path (e.g. https://[::1]:6443, https://host/k8s/clusters/c-1).
+func GetKubeconfigWithClientCertificate(certData, keyData, caData []byte,
+	user, server string) ([]byte, error) {
+
+	if user == "" {
+		return nil, newKubeconfigFieldError("user", "cannot be empty")
+	}
+
+	if err := validateClientCertificate(certData, keyData); err != nil {
+		return nil, err
+	}
+
+	return buildKubeconfig(caData, user, server, apiv1.AuthInfo{
+		ClientCertificateData: certData,
+		ClientKeyData:         keyData,
+	})
+}
+
+// GetKubeconfigWithExecPlugin returns a kubeconfig authenticating user with an exec
+// credential plugin.
+// If exec InteractiveMode is not set, it defaults to IfAvailable.
+func GetKubeconfigWithExecPlugin(exec *apiv1.ExecConfig, caData []byte,
+	user, server string) ([]byte, error) {
+
+	if user == "" {
+		return nil, newKubeconfigFieldError("user", "cannot be empty")
+	}
+
+	execConfig, err := getExecConfig(exec)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildKubeconfig(caData, user, server, apiv1.AuthInfo{
+		Exec: execConfig,
+	})
+}
+
+// buildKubeconfig returns a kubeconfig with a single context, named after user,
+// using authInfo to authenticate against server
+func buildKubeconfig(caData []byte, user, server string, authInfo apiv1.AuthInfo) ([]byte, error) {
+	if err := validateServer(server); err != nil {
+		return nil, err
+	}
+
+	if len(caData) == 0 {
+		return nil, newKubeconfigFieldError("certificateAuthorityData", "cannot be empty")
+	}
+
+	config := apiv1.Config{
+		Kind:       "Config",
+		APIVersion: "v1",
+		AuthInfos: []apiv1.NamedAuthInfo{
+			{
+				Name:     user,
+				AuthInfo: authInfo,
+			},
+		},
+		Clusters: []apiv1.NamedCluster{
+			{
+				Name: user,
+				Cluster: apiv1.Cluster{
+					Server:                   server,
+					CertificateAuthorityData: caData,
+				},
+			},
+		},
+		Contexts: []apiv1.NamedContext{
+			{
+				Name: user,
+				Context: apiv1.Context{
+					Cluster:  user,
+					AuthInfo: user,
+				},
+			},
+		},
+		CurrentContext: user,
+		Preferences:    apiv1.Preferences{},
+	}
+
+	kubeconfig, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating kubeconfig: %w", err)
+	}
+
+	return kubeconfig, nil
+}
+
+// validateServer verifies server is an https URL. Host can be a hostname, an IPv4 or
+// a (bracketed) IPv6 address. Port and path are optional. Query, fragment and
+// user info are not allowed.
+func validateServer(server string) error {
+	const field = "server"
+
+	if server == "" {
+		return newKubeconfigFieldError(field, "cannot be empty")
+	}
+
+	u, err := url.Parse(server)
+	if err != nil {
+		return newKubeconfigFieldError(field, fmt.Sprintf("not a valid URL: %v", err))
+	}
+
+	if u.Scheme != "https" {
+		return newKubeconfigFieldError(field,
+			fmt.Sprintf("scheme must be https. valid values e.g. https://127.0.0.1:123, https://hostname:321, "+
+				"https://[::1]:6443, https://hostname/path. got %q", server))
+	}
+
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return newKubeconfigFieldError(field, "user info, query and fragment are not allowed")
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return newKubeconfigFieldError(field, "host cannot be empty")
+	}
+
+	if strings.HasPrefix(u.Host, "[") {
+		if ip := net.ParseIP(host); ip == nil || ip.To4() != nil {
+			return newKubeconfigFieldError(field, fmt.Sprintf("%q is not a valid IPv6 address", host))
+		}
+	} else if strings.Contains(host, ":") {
+		return newKubeconfigFieldError(field, fmt.Sprintf("IPv6 address %q must be enclosed in brackets", host))
+	} else if net.ParseIP(host) == nil && !hostnameRegExp.MatchString(host) {
+		return newKubeconfigFieldError(field, fmt.Sprintf("%q is not a valid hostname or IP address", host))
+	}
+
+	if strings.HasSuffix(u.Host, ":") {
+		return newKubeconfigFieldError(field, "port cannot be empty")
+	}
+
+	if port := u.Port(); port != "" {
+		value, err := strconv.Atoi(port)
+		if err != nil || value < 1 || value > 65535 {
+			return newKubeconfigFieldError(field, fmt.Sprintf("%q is not a valid port", port))
+		}
+	}
+
+	return nil
+}
+
+// validateClientCertificate verifies certData is a PEM encoded certificate and keyData
+// the matching PEM encoded private key
+func validateClientCertificate(certData, keyData []byte) error {
+	if len(certData) == 0 {
+		return newKubeconfigFieldError("clientCertificateData", "cannot be empty")
+	}
+
+	if len(keyData) == 0 {
+		return newKubeconfigFieldError("clientKeyData", "cannot be empty")
+	}
+
+	block, _ := pem.Decode(certData)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return newKubeconfigFieldError("clientCertificateData", "not a PEM encoded certificate")
+	}
+
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return newKubeconfigFieldError("clientCertificateData", fmt.Sprintf("failed to parse certificate: %v", err))
+	}
+
+	if _, err := tls.X509KeyPair(certData, keyData); err != nil {
+		return newKubeconfigFieldError("clientKeyData",
+			fmt.Sprintf("not a valid private key for clientCertificateData: %v", err))
+	}
+
+	return nil
+}
+
+// getExecConfig validates exec and returns a copy of it with defaults set
+func getExecConfig(exec *apiv1.ExecConfig) (*apiv1.ExecConfig, error) {
+	if exec == nil {
+		return nil, newKubeconfigFieldError("exec", "cannot be empty")
+	}
+
+	if exec.Command == "" {
+		return nil, newKubeconfigFieldError("exec.command", "cannot be empty")
+	}
+
+	if exec.APIVersion != execAPIVersionV1 && exec.APIVersion != execAPIVersionV1beta1 {
+		return nil, newKubeconfigFieldError("exec.apiVersion",
+			fmt.Sprintf("must be one of %s, %s. got %q", execAPIVersionV1, execAPIVersionV1beta1, exec.APIVersion))
+	}
+
+	for i := range exec.Env {
+		if exec.Env[i].Name == "" {
+			return nil, newKubeconfigFieldError(fmt.Sprintf("exec.env[%d].name", i), "cannot be empty")
+		}
+	}
+
+	execConfig := exec.DeepCopy()
+	switch execConfig.InteractiveMode {
+	case "":
+		execConfig.InteractiveMode = apiv1.IfAvailableExecInteractiveMode
+	case apiv1.NeverExecInteractiveMode, apiv1.IfAvailableExecInteractiveMode, apiv1.AlwaysExecInteractiveMode:
+	default:
+		return nil, newKubeconfigFieldError("exec.interactiveMode",
+			fmt.Sprintf("unknown value %q", execConfig.InteractiveMode))
+	}
+
+	return execConfig, nil
+}
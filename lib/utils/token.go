@@ -0,0 +1,73 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TokenClaims contains the claims, of a JWT bearer token, Sveltos relies upon
+type TokenClaims struct {
+	// Subject is the "sub" claim. For ServiceAccount tokens it is in the
+	// form system:serviceaccount:<namespace>:<name>
+	Subject string
+
+	// IssuedAt is the "iat" claim. Nil if not present.
+	IssuedAt *time.Time
+
+	// ExpiresAt is the "exp" claim. Nil if not present.
+	ExpiresAt *time.Time
+}
+
+// GetTokenClaims returns the claims of a JWT. Token signature is not verified,
+// so claims must not be used for authentication decisions.
+func GetTokenClaims(token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token payload: %w", err)
+	}
+
+	claims := struct {
+		Subject   string `json:"sub"`
+		IssuedAt  *int64 `json:"iat"`
+		ExpiresAt *int64 `json:"exp"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to parse token claims: %w", err)
+	}
+
+	result := &TokenClaims{Subject: claims.Subject}
+	if claims.IssuedAt != nil {
+		issuedAt := time.Unix(*claims.IssuedAt, 0)
+		result.IssuedAt = &issuedAt
+	}
+	if claims.ExpiresAt != nil {
+		expiresAt := time.Unix(*claims.ExpiresAt, 0)
+		result.ExpiresAt = &expiresAt
+	}
+
+	return result, nil
+}
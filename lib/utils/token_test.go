@@ -0,0 +1,59 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils_test
+
+import (
+	"encoding/base64"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/projectsveltos/libsveltos/lib/utils"
+)
+
+var _ = Describe("Token", func() {
+	It("GetTokenClaims returns subject, issue and expiration time", func() {
+		payload := `{"sub":"system:serviceaccount:default:sveltos","iat":1700000000,"exp":1700003600}`
+		token := "header." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+
+		claims, err := utils.GetTokenClaims(token)
+		Expect(err).To(BeNil())
+		Expect(claims.Subject).To(Equal("system:serviceaccount:default:sveltos"))
+		Expect(claims.IssuedAt).ToNot(BeNil())
+		Expect(claims.IssuedAt.Equal(time.Unix(1700000000, 0))).To(BeTrue())
+		Expect(claims.ExpiresAt).ToNot(BeNil())
+		Expect(claims.ExpiresAt.Sub(*claims.IssuedAt)).To(Equal(time.Hour))
+	})
+
+	It("GetTokenClaims returns nil times when claims are not present", func() {
+		token := "header." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + ".signature"
+
+		claims, err := utils.GetTokenClaims(token)
+		Expect(err).To(BeNil())
+		Expect(claims.IssuedAt).To(BeNil())
+		Expect(claims.ExpiresAt).To(BeNil())
+	})
+
+	It("GetTokenClaims fails for a token which is not a JWT", func() {
+		_, err := utils.GetTokenClaims("not-a-jwt")
+		Expect(err).ToNot(BeNil())
+
+		_, err = utils.GetTokenClaims("header.!!!.signature")
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -18,9 +18,7 @@ package utils
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"regexp"
 
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -38,11 +36,6 @@ import (
 	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
 )
 
-var (
-	serverWithPortRegExp    = regexp.MustCompile(`^https://[0-9a-zA-Z][0-9a-zA-Z-.]+[0-9a-zA-Z]:\d+$`)
-	serverWithoutPortRegExp = regexp.MustCompile(`^https://[0-9a-zA-Z][0-9a-zA-Z-.]+[0-9a-zA-Z]$`)
-)
-
 // GetUnstructured returns an unstructured given a []bytes containing it
 func GetUnstructured(object []byte) (*unstructured.Unstructured, error) {
 	request := &unstructured.Unstructured{}
@@ -91,16 +84,18 @@ func GetDynamicResourceInterface(config *rest.Config, gvk schema.GroupVersionKin
 }
 
 // GetKubeconfigWithUserToken() accepts a k8s client interface, OIDC IDToken,
-// username (e.g. user@example.org) and server with scheme, host, and port
-// (e.g.  https://127.0.0.1:1234) to return a kube-config with credentials set
-// for the user.
+// username (e.g. user@example.org) and server with scheme, host, and optional port
+// and path (e.g.  https://127.0.0.1:1234, https://[::1]:6443) to return a kube-config
+// with credentials set for the user.
 func GetKubeconfigWithUserToken(ctx context.Context, idToken, caData []byte,
 	userID, server string) ([]byte, error) {
 
 	// Input validations.
-	if userID == "" || len(idToken) == 0 {
-		return nil,
-			fmt.Errorf("userID and IDToken cannot be empty")
+	if userID == "" {
+		return nil, newKubeconfigFieldError("userID", "cannot be empty")
+	}
+	if len(idToken) == 0 {
+		return nil, newKubeconfigFieldError("idToken", "cannot be empty")
 	}
 
 	return getUserOrSAKubeconfig(idToken, caData, userID, server)
@@ -113,58 +108,9 @@ func getUserOrSAKubeconfig(
 	user, server string,
 ) ([]byte, error) {
 
-	if server == "" {
-		return nil, fmt.Errorf("server cannot be empty")
-	}
-
-	if !serverWithPortRegExp.MatchString(server) && !serverWithoutPortRegExp.MatchString(server) {
-		return nil,
-			fmt.Errorf("server value is invalid. valid values e.g. https://127.0.0.1:123, https://hostname:321, https://127.0.0.1")
-	}
-
-	config := apiv1.Config{
-		Kind:       "Config",
-		APIVersion: "v1",
-		AuthInfos: []apiv1.NamedAuthInfo{
-			{
-				Name: user,
-				AuthInfo: apiv1.AuthInfo{
-					Token: string(token),
-				},
-			},
-		},
-		Clusters: []apiv1.NamedCluster{
-			{
-				Name: user,
-				Cluster: apiv1.Cluster{
-					Server:                   server,
-					CertificateAuthorityData: caData,
-				},
-			},
-		},
-		Contexts: []apiv1.NamedContext{
-			{
-				Name: user,
-				Context: apiv1.Context{
-					Cluster:  user,
-					AuthInfo: user,
-				},
-			},
-		},
-		CurrentContext: user,
-		Preferences:    apiv1.Preferences{},
-	}
-
-	if caData == nil {
-		return nil, fmt.Errorf("empty CA data")
-	}
-
-	kubeconfig, err := json.Marshal(config)
-	if err != nil {
-		return nil, fmt.Errorf("error creating kubeconfig: %w", err)
-	}
-
-	return kubeconfig, nil
+	return buildKubeconfig(caData, user, server, apiv1.AuthInfo{
+		Token: string(token),
+	})
 }
 
 func GetKubernetesVersion(ctx context.Context, cfg *rest.Config, logger logr.Logger) (string, error) {
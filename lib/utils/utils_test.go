@@ -78,11 +78,11 @@ var _ = Describe("utils ", func() {
 	It("should validate UserID and IDToken input in GetKubeconfigWithUserToken()", func() {
 		_, err := utils.GetKubeconfigWithUserToken(ctx, nil, []byte(caData), "user@example.org", serverValue)
 		Expect(err).NotTo(BeNil())
-		Expect(err.Error()).To(ContainSubstring("userID and IDToken cannot be empty"))
+		Expect(err.Error()).To(Equal("invalid idToken: cannot be empty"))
 
 		_, err = utils.GetKubeconfigWithUserToken(ctx, []byte("0x123456"), []byte(caData), "", serverValue)
 		Expect(err).NotTo(BeNil())
-		Expect(err.Error()).To(ContainSubstring("userID and IDToken cannot be empty"))
+		Expect(err.Error()).To(Equal("invalid userID: cannot be empty"))
 	})
 
 	It("GetKubeconfigWithUserToken() should return a usable kubeconfig", func() {